@@ -0,0 +1,616 @@
+// Command jsonengine 是 jsonengine 规则的命令行工具, 用于在不写 Go 代码的情况下匹配、过滤、检查以及格式化规则。
+//
+// 用法:
+//
+//	jsonengine match    [flags] (RULE | -e TEXT) [DOC]
+//	jsonengine filter   [flags] (RULE | -e TEXT) [NDJSON]
+//	jsonengine explain  [flags] (RULE | -e TEXT) [DOC]
+//	jsonengine validate [flags] (RULE... | -e TEXT)
+//	jsonengine fmt      [flags] RULE...
+//
+// RULE 为 .json、.yaml 或 .yml 格式的规则文件, -e 表示文本形式的规则, 如 -e 'age >= 18 AND vip = true'。
+// DOC 以及 NDJSON 省略或者为 - 时从标准输入读取。
+//
+// match 和 explain 在规则命中时退出码为 0, 未命中时为 1, 出错时为 2; 其他子命令成功时为 0, validate 发现
+// 问题或者 fmt -l 发现需要格式化的文件时为 1, 出错时为 2。
+//
+// fmt 与 validate 一样严格解析规则文件, 有未知的 key 等问题时报错, 不会输出或者写回文件
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Andrew-M-C/go-jsonengine/jsonengine"
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	exitOK    = 0
+	exitFalse = 1
+	exitError = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// commands 为所有的子命令及其用法
+var commands = []struct {
+	name  string
+	usage string
+}{
+	{"match", "[flags] (RULE | -e TEXT) [DOC]"},
+	{"filter", "[flags] (RULE | -e TEXT) [NDJSON]"},
+	{"explain", "[flags] (RULE | -e TEXT) [DOC]"},
+	{"validate", "[flags] (RULE... | -e TEXT)"},
+	{"fmt", "[flags] RULE..."},
+}
+
+// cli 表示一次运行的输入输出
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		c.usage()
+		return exitError
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		c.usage()
+		return exitOK
+	}
+	switch args[0] {
+	case "match":
+		return c.match(args[1:])
+	case "filter":
+		return c.filter(args[1:])
+	case "explain":
+		return c.explain(args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "fmt":
+		return c.format(args[1:])
+	}
+	fmt.Fprintf(stderr, "jsonengine: unknown command '%s'\n", args[0])
+	c.usage()
+	return exitError
+}
+
+func (c *cli) usage() {
+	fmt.Fprintln(c.stderr, "usage:")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  jsonengine %-8s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(c.stderr, "\nrun 'jsonengine <command> -h' for flags of each command")
+}
+
+// fail 输出错误并返回 exitError
+func (c *cli) fail(err error) int {
+	fmt.Fprintf(c.stderr, "jsonengine: %v\n", err)
+	return exitError
+}
+
+// ----------------
+// MARK: flags
+
+// flags 表示各个子命令共用的参数
+type flags struct {
+	*flag.FlagSet
+	text string
+
+	whenNotFound     returnFlag
+	whenTypeMismatch returnFlag
+	timeFormat       string
+}
+
+func (c *cli) newFlags(name string) *flags {
+	f := &flags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.SetOutput(c.stderr)
+	f.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(c.stderr, "usage: jsonengine %s %s\n", name, cmd.usage)
+			}
+		}
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// withRule 添加 -e 参数
+func (f *flags) withRule() *flags {
+	f.StringVar(&f.text, "e", "", "rule in text syntax, e.g. 'age >= 18 AND vip = true', instead of a rule file")
+	return f
+}
+
+// withOptions 添加与 jsonengine.Option 对应的参数
+func (f *flags) withOptions() *flags {
+	f.Var(&f.whenNotFound, "when-not-found", "result when a field is not found: 'error' or 'false'")
+	f.Var(&f.whenTypeMismatch, "when-type-mismatch", "result when value types mismatch: 'error' or 'false'")
+	f.StringVar(&f.timeFormat, "time-format", "", "Go time layout for comparing timed strings, e.g. '2006-01-02'")
+	return f
+}
+
+func (f *flags) options() []jsonengine.Option {
+	return []jsonengine.Option{
+		jsonengine.OptWhenNotFound(f.whenNotFound.typ),
+		jsonengine.OptWhenTypeMismatch(f.whenTypeMismatch.typ),
+		jsonengine.OptDateTimeFormat(f.timeFormat),
+	}
+}
+
+// parse 解析参数, 与标准库不同, 参数可以出现在文件名之后, -- 之后的均视为文件名
+func (f *flags) parse(args []string) ([]string, error) {
+	var files []string
+	for len(args) > 0 {
+		if args[0] == "--" {
+			return append(files, args[1:]...), nil
+		}
+		if len(args[0]) < 2 || args[0][0] != '-' {
+			files = append(files, args[0])
+			args = args[1:]
+			continue
+		}
+		if err := f.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := f.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(files, rest...), nil
+		}
+		args = rest
+	}
+	return files, nil
+}
+
+// flagError 返回参数解析失败时的退出码, 错误信息已经由 flag 输出。-h 不视为错误
+func flagError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitError
+}
+
+// returnFlag 表示 jsonengine.ReturnType 类型的参数
+type returnFlag struct {
+	typ jsonengine.ReturnType
+}
+
+func (r *returnFlag) String() string {
+	if r.typ == jsonengine.ReturnFalse {
+		return "false"
+	}
+	return "error"
+}
+
+func (r *returnFlag) Set(s string) error {
+	switch strings.ToLower(s) {
+	default:
+		return fmt.Errorf("expecting 'error' or 'false' but got '%s'", s)
+	case "error":
+		r.typ = jsonengine.ReturnError
+	case "false":
+		r.typ = jsonengine.ReturnFalse
+	}
+	return nil
+}
+
+// rule 按照 -e 或者第一个文件名读取规则, 返回其余的文件名
+func (f *flags) rule(files []string) (jsonengine.Condition, []string, error) {
+	if f.text != "" {
+		cond, err := jsonengine.Parse(f.text)
+		return cond, files, err
+	}
+	if len(files) == 0 {
+		return jsonengine.Condition{}, nil, errors.New("missing rule, expecting a rule file or -e")
+	}
+	cond, err := jsonengine.LoadFile(files[0])
+	return cond, files[1:], err
+}
+
+// ----------------
+// MARK: match & explain
+
+func (c *cli) match(args []string) int {
+	f := c.newFlags("match").withRule().withOptions()
+	quiet := f.Bool("q", false, "do not print the result, only set the exit code")
+	p, doc, code := c.prepare(f, args)
+	if p == nil {
+		return code
+	}
+
+	b, err := p.Match(doc)
+	if err != nil {
+		return c.fail(err)
+	}
+	if !*quiet {
+		fmt.Fprintln(c.stdout, b)
+	}
+	return exitCode(b)
+}
+
+func (c *cli) explain(args []string) int {
+	f := c.newFlags("explain").withRule().withOptions()
+	asJSON := f.Bool("json", false, "print the trace as JSON")
+	p, doc, code := c.prepare(f, args)
+	if p == nil {
+		return code
+	}
+
+	e, err := p.Explain(doc)
+	if e != nil {
+		if *asJSON {
+			b, _ := json.MarshalIndent(e, "", "  ")
+			fmt.Fprintf(c.stdout, "%s\n", b)
+		} else {
+			fmt.Fprint(c.stdout, e.String())
+		}
+	}
+	if err != nil {
+		return c.fail(err)
+	}
+	return exitCode(e.Result)
+}
+
+// prepare 解析参数, 编译规则并读取文档, 返回的 Program 为 nil 时应当以返回的退出码结束
+func (c *cli) prepare(f *flags, args []string) (*jsonengine.Program, *jsonvalue.V, int) {
+	files, err := f.parse(args)
+	if err != nil {
+		return nil, nil, flagError(err)
+	}
+	cond, files, err := f.rule(files)
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+	if len(files) > 1 {
+		return nil, nil, c.fail(fmt.Errorf("expecting at most one document but got %d", len(files)))
+	}
+	p, err := jsonengine.Compile(cond, f.options()...)
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+
+	in, closeFn, err := c.open(files)
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+	defer closeFn()
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+	doc, err := jsonvalue.Unmarshal(bytes.TrimSpace(b))
+	if err != nil {
+		return nil, nil, c.fail(fmt.Errorf("parse document error (%w)", err))
+	}
+	return p, doc, exitOK
+}
+
+// open 打开输入文件, 没有文件或者为 - 时使用标准输入
+func (c *cli) open(files []string) (io.Reader, func(), error) {
+	if len(files) == 0 || files[0] == "-" {
+		return c.stdin, func() {}, nil
+	}
+	file, err := os.Open(files[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+func exitCode(matched bool) int {
+	if matched {
+		return exitOK
+	}
+	return exitFalse
+}
+
+// ----------------
+// MARK: filter
+
+func (c *cli) filter(args []string) int {
+	f := c.newFlags("filter").withRule().withOptions()
+	workers := f.Int("workers", 1, "number of parallel workers, 0 means GOMAXPROCS; output keeps the input order")
+	files, err := f.parse(args)
+	if err != nil {
+		return flagError(err)
+	}
+	cond, files, err := f.rule(files)
+	if err != nil {
+		return c.fail(err)
+	}
+	if len(files) > 1 {
+		return c.fail(fmt.Errorf("expecting at most one input but got %d", len(files)))
+	}
+
+	opts := f.options()
+	if *workers != 1 {
+		opts = append(opts, jsonengine.OptWorkers(*workers))
+	}
+	p, err := jsonengine.Compile(cond, opts...)
+	if err != nil {
+		return c.fail(err)
+	}
+
+	in, closeFn, err := c.open(files)
+	if err != nil {
+		return c.fail(err)
+	}
+	defer closeFn()
+	if _, err := p.FilterReader(in, c.stdout); err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+// ----------------
+// MARK: validate
+
+func (c *cli) validate(args []string) int {
+	f := c.newFlags("validate").withRule().withOptions()
+	files, err := f.parse(args)
+	if err != nil {
+		return flagError(err)
+	}
+
+	code := exitOK
+	report := func(name string, err error) {
+		if err == nil {
+			return
+		}
+		var errs jsonengine.ValidationErrors
+		if !errors.As(err, &errs) {
+			errs = jsonengine.ValidationErrors{{Err: err}}
+		}
+		for _, e := range errs {
+			fmt.Fprintf(c.stdout, "%s: %v\n", name, e)
+		}
+		code = exitFalse
+	}
+
+	if f.text != "" {
+		if len(files) > 0 {
+			return c.fail(errors.New("rule files should not be given together with -e"))
+		}
+		cond, err := jsonengine.Parse(f.text)
+		if err == nil {
+			err = cond.Validate(f.options()...)
+		}
+		report("-e", err)
+		return code
+	}
+	if len(files) == 0 {
+		return c.fail(errors.New("missing rule, expecting rule files or -e"))
+	}
+
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return c.fail(err)
+		}
+		unmarshal, err := strictUnmarshaler(file)
+		if err == nil {
+			_, err = unmarshal(b, f.options()...)
+		}
+		report(file, err)
+	}
+	return code
+}
+
+// strictUnmarshaler 按照文件的扩展名返回严格解析规则的方法
+func strictUnmarshaler(file string) (func([]byte, ...jsonengine.Option) (jsonengine.Condition, error), error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	default:
+		return nil, fmt.Errorf("unsupported file extension '%s', expecting .json, .yaml or .yml", filepath.Ext(file))
+	case ".json":
+		return jsonengine.UnmarshalStrict, nil
+	case ".yaml", ".yml":
+		return jsonengine.UnmarshalYAMLStrict, nil
+	}
+}
+
+// ----------------
+// MARK: fmt
+
+func (c *cli) format(args []string) int {
+	f := c.newFlags("fmt")
+	write := f.Bool("w", false, "write the result back to the rule files instead of stdout")
+	list := f.Bool("l", false, "list files whose formatting differs, instead of printing them")
+	to := f.String("to", "", "output format: 'json', 'yaml' or 'text', defaults to the format of each file")
+	files, err := f.parse(args)
+	if err != nil {
+		return flagError(err)
+	}
+	if len(files) == 0 {
+		return c.fail(errors.New("missing rule files"))
+	}
+	if *to != "" && (*write || *list) {
+		return c.fail(errors.New("-to should not be used together with -w or -l"))
+	}
+
+	code := exitOK
+	for _, file := range files {
+		// 严格解析, 以免写回时丢掉拼错的 key 等无法识别的内容
+		orig, err := os.ReadFile(file)
+		if err != nil {
+			return c.fail(err)
+		}
+		unmarshal, err := strictUnmarshaler(file)
+		if err != nil {
+			return c.fail(err)
+		}
+		cond, err := unmarshal(orig)
+		if err != nil {
+			return c.fail(fmt.Errorf("%s: %w", file, err))
+		}
+		format := *to
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+		}
+		out, err := canonical(cond, format)
+		if err != nil {
+			return c.fail(err)
+		}
+
+		switch {
+		default:
+			c.stdout.Write(out)
+		case *list || *write:
+			if bytes.Equal(orig, out) {
+				continue
+			}
+			if *list {
+				fmt.Fprintln(c.stdout, file)
+				code = exitFalse
+			}
+			if *write {
+				if err := os.WriteFile(file, out, 0o644); err != nil {
+					return c.fail(err)
+				}
+			}
+		}
+	}
+	if *write {
+		return exitOK
+	}
+	return code
+}
+
+// canonical 返回规则的规范形式
+func canonical(cond jsonengine.Condition, format string) ([]byte, error) {
+	switch format {
+	default:
+		return nil, fmt.Errorf("unsupported format '%s', expecting json, yaml or text", format)
+	case "json":
+		b, err := cond.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.Buffer{}
+		if err := indentJSON(&buf, b, 0); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	case "yaml", "yml":
+		buf := bytes.Buffer{}
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(cond); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "text":
+		return []byte(cond.String() + "\n"), nil
+	}
+}
+
+// indentJSON 缩进 MarshalJSON 的结果, SQL 风格的叶子条件以及只包含标量的数组保持在同一行, 其他的逐行输出
+func indentJSON(buf *bytes.Buffer, b []byte, depth int) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return errors.New("unexpected empty JSON value")
+	}
+	indent := strings.Repeat("  ", depth+1)
+
+	switch b[0] {
+	default:
+		buf.Write(b)
+		return nil
+
+	case '[':
+		var arr []json.RawMessage
+		if err := json.Unmarshal(b, &arr); err != nil {
+			return err
+		}
+		nested := false
+		for _, v := range arr {
+			if v := bytes.TrimSpace(v); v[0] == '{' || v[0] == '[' {
+				nested = true
+			}
+		}
+		if !nested || isSQLStyle(arr) {
+			buf.WriteByte('[')
+			for i, v := range arr {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				buf.Write(bytes.TrimSpace(v))
+			}
+			buf.WriteByte(']')
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, v := range arr {
+			if i > 0 {
+				buf.WriteString(",\n")
+			}
+			buf.WriteString(indent)
+			if err := indentJSON(buf, v, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + indent[2:] + "]")
+		return nil
+
+	case '{':
+		// 使用 Decoder 逐个读取, 保持 key 的顺序
+		dec := json.NewDecoder(bytes.NewReader(b))
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		buf.WriteString("{")
+		for i := 0; dec.More(); i++ {
+			tk, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(tk)
+			fmt.Fprintf(buf, "\n%s%s: ", indent, k)
+			if err := indentJSON(buf, v, depth+1); err != nil {
+				return err
+			}
+		}
+		if buf.Bytes()[buf.Len()-1] != '{' {
+			buf.WriteString("\n" + indent[2:])
+		}
+		buf.WriteString("}")
+		return nil
+	}
+}
+
+// isSQLStyle 表示数组是否为 SQL 风格的叶子条件, 即 [field, op] 或 [field, op, value]
+func isSQLStyle(arr []json.RawMessage) bool {
+	if len(arr) != 2 && len(arr) != 3 {
+		return false
+	}
+	for _, v := range arr[:2] {
+		if v := bytes.TrimSpace(v); v[0] != '"' {
+			return false
+		}
+	}
+	return true
+}
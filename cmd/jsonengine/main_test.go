@@ -0,0 +1,306 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	cv = convey.Convey
+	so = convey.So
+	eq = convey.ShouldEqual
+)
+
+// runCLI 运行命令, 返回退出码、标准输出以及标准错误
+func runCLI(stdin string, args ...string) (int, string, string) {
+	stdout, stderr := &strings.Builder{}, &strings.Builder{}
+	code := run(args, strings.NewReader(stdin), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// writeFiles 在临时目录中创建文件, 返回目录
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCLI(t *testing.T) {
+	cv("match", t, func() { testCLIMatch(t) })
+	cv("filter", t, func() { testCLIFilter(t) })
+	cv("explain", t, func() { testCLIExplain(t) })
+	cv("validate", t, func() { testCLIValidate(t) })
+	cv("fmt", t, func() { testCLIFormat(t) })
+	cv("usage", t, func() { testCLIUsage(t) })
+}
+
+func testCLIMatch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"rule.yaml": "and:\n  - [age, '>=', 18]\n  - [vip, =, true]\n",
+		"doc.json":  `{"age":20,"vip":true}`,
+	})
+	rule, doc := filepath.Join(dir, "rule.yaml"), filepath.Join(dir, "doc.json")
+
+	code, out, _ := runCLI("", "match", rule, doc)
+	so(code, eq, exitOK)
+	so(out, eq, "true\n")
+
+	code, out, _ = runCLI(`{"age":17,"vip":true}`, "match", rule)
+	so(code, eq, exitFalse)
+	so(out, eq, "false\n")
+
+	code, out, _ = runCLI(`{"age":17,"vip":true}`, "match", "-q", rule, "-")
+	so(code, eq, exitFalse)
+	so(out, eq, "")
+
+	// 参数可以出现在文件名之后
+	code, _, errOut := runCLI(`{"age":20}`, "match", rule)
+	so(code, eq, exitError)
+	so(errOut, convey.ShouldContainSubstring, "not found")
+
+	code, out, _ = runCLI(`{"age":20}`, "match", rule, "--when-not-found=false")
+	so(code, eq, exitFalse)
+	so(out, eq, "false\n")
+
+	code, _, _ = runCLI(`{"age":20,"vip":"yes"}`, "match", "--when-type-mismatch", "false", rule)
+	so(code, eq, exitFalse)
+
+	// 文本规则以及时间格式
+	code, _, _ = runCLI(`{"t":"2024-05-01"}`, "match", "-e", `t > "2024-01-01"`, "--time-format=2006-01-02")
+	so(code, eq, exitOK)
+	code, _, errOut = runCLI(`{"t":"2024-05-01"}`, "match", "-e", `t > "2024-01-01"`)
+	so(code, eq, exitError)
+	so(errOut, convey.ShouldStartWith, "jsonengine: ")
+
+	// 出错
+	for _, args := range [][]string{
+		{"match"},
+		{"match", "-e", "age >>= 1"},
+		{"match", "-e", "age > 1", "--time-format=abc"},
+		{"match", "-e", "age > 1", "--when-not-found=true"},
+		{"match", "-e", "age > 1", doc, doc},
+		{"match", "-e", "age > 1", filepath.Join(dir, "missing.json")},
+		{"match", filepath.Join(dir, "missing.json"), doc},
+		{"match", "--unknown", rule, doc},
+	} {
+		t.Log(args)
+		code, _, _ = runCLI("{}", args...)
+		so(code, eq, exitError)
+	}
+
+	code, _, _ = runCLI("not json", "match", rule)
+	so(code, eq, exitError)
+}
+
+func testCLIFilter(t *testing.T) {
+	in := `{"level":"warn","id":1}` + "\n" +
+		`{"level":"info","id":2}` + "\n" +
+		"\n" +
+		`{"level":"error","id":3}` + "\n"
+	expected := `{"level":"warn","id":1}` + "\n" + `{"level":"error","id":3}` + "\n"
+
+	for _, workers := range []string{"1", "4", "0"} {
+		code, out, _ := runCLI(in, "filter", "-e", `level in ["warn", "error"]`, "--workers", workers)
+		so(code, eq, exitOK)
+		so(out, eq, expected)
+	}
+
+	dir := writeFiles(t, map[string]string{
+		"rule.json": `["level", "=", "info"]`,
+		"in.ndjson": in,
+	})
+	code, out, _ := runCLI("", "filter", filepath.Join(dir, "rule.json"), filepath.Join(dir, "in.ndjson"))
+	so(code, eq, exitOK)
+	so(out, eq, `{"level":"info","id":2}`+"\n")
+
+	code, out, errOut := runCLI(in+"{\n", "filter", "-e", `level = "warn"`)
+	so(code, eq, exitError)
+	so(out, eq, `{"level":"warn","id":1}`+"\n")
+	so(errOut, convey.ShouldContainSubstring, "line 5")
+
+	code, _, _ = runCLI(in+`{"id":4}`, "filter", "-e", `level = "warn"`)
+	so(code, eq, exitError)
+	code, _, _ = runCLI(in+`{"id":4}`, "filter", "-e", `level = "warn"`, "--when-not-found=false")
+	so(code, eq, exitOK)
+}
+
+func testCLIExplain(t *testing.T) {
+	code, out, _ := runCLI(`{"age":20}`, "explain", "-e", `age >= 18 AND name = "x"`, "--when-not-found=false")
+	so(code, eq, exitFalse)
+	so(out, eq, strings.Join([]string{
+		"AND => false",
+		"    age >= 18 => true",
+		"      - age = 20 => true",
+		`    name = "x" => false, ignored error: target not found`,
+		"      - name, error: target not found",
+		"",
+	}, "\n"))
+
+	code, out, _ = runCLI(`{"age":20}`, "explain", "--json", "-e", `age >= 18`)
+	so(code, eq, exitOK)
+	so(out, convey.ShouldStartWith, "{\n  \"type\": \"expr\",\n  \"result\": true,")
+
+	// 出错时仍然输出已经完成的部分
+	code, out, errOut := runCLI(`{"age":20}`, "explain", "-e", `name = "x"`)
+	so(code, eq, exitError)
+	so(out, convey.ShouldStartWith, `name = "x" => false, error: `)
+	so(errOut, convey.ShouldContainSubstring, "not found")
+}
+
+func testCLIValidate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"good.json": `{"and":[["a","=",1],["b","exists"]]}`,
+		"bad.json":  `{"and":[["a","=>",1],{"field":"b","op":"=","value":1,"or":[["c","=",1]]}]}`,
+		"bad.yaml":  "or:\n  - [a, =, 1]\n  - {field: b, op: =, valu: 1}\n",
+		"rule.txt":  "a = 1",
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	code, out, _ := runCLI("", "validate", path("good.json"))
+	so(code, eq, exitOK)
+	so(out, eq, "")
+
+	code, out, _ = runCLI("", "validate", path("good.json"), path("bad.json"), path("bad.yaml"))
+	so(code, eq, exitFalse)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	so(len(lines), eq, 3)
+	so(lines[0], convey.ShouldStartWith, path("bad.json")+": and[0]: illegal operator")
+	so(lines[1], convey.ShouldStartWith, path("bad.json")+": and[1]: illegal condition")
+	so(lines[2], eq, path("bad.yaml")+": or[1]: unknown key 'valu'")
+
+	code, out, _ = runCLI("", "validate", path("rule.txt"))
+	so(code, eq, exitFalse)
+	so(out, convey.ShouldContainSubstring, "unsupported file extension '.txt'")
+
+	code, out, _ = runCLI("", "validate", "-e", "a = 1 AND b ~~ 2")
+	so(code, eq, exitFalse)
+	so(out, convey.ShouldStartWith, "-e: ")
+
+	code, _, _ = runCLI("", "validate", "-e", "a >= '2024'", "--time-format", "2006")
+	so(code, eq, exitOK)
+	code, _, _ = runCLI("", "validate", "-e", "a >= 'x'", "--time-format", "2006")
+	so(code, eq, exitFalse)
+
+	for _, args := range [][]string{
+		{"validate"},
+		{"validate", "-e", "a = 1", path("good.json")},
+		{"validate", path("missing.json")},
+	} {
+		code, _, _ = runCLI("", args...)
+		so(code, eq, exitError)
+	}
+}
+
+func testCLIFormat(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.json": `{"and":[{"field":"age","op":">=","value":18},{"or":[["tags","containsall",["x","y"]],` +
+			`{"field":"items","any":["sku","startswith","A<"]}]}]}`,
+		"b.yaml": "{and: [[vip, '=', true], {field: n, op: in, value: [1, 2]}]}\n",
+	})
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.yaml")
+
+	code, out, _ := runCLI("", "fmt", a)
+	so(code, eq, exitOK)
+	so(out, eq, `{
+  "and": [
+    ["age", ">=", 18],
+    {
+      "or": [
+        ["tags", "containsall", ["x","y"]],
+        {
+          "field": "items",
+          "any": ["sku", "startswith", "A<"]
+        }
+      ]
+    }
+  ]
+}
+`)
+
+	code, out, _ = runCLI("", "fmt", b)
+	so(code, eq, exitOK)
+	so(out, eq, "and:\n  - [vip, =, true]\n  - [n, in, [1, 2]]\n")
+
+	code, out, _ = runCLI("", "fmt", "-to", "text", a, b)
+	so(code, eq, exitOK)
+	so(out, eq, `age >= 18 AND (tags containsall ["x","y"] OR items ANY (sku startswith "A<"))`+"\n"+
+		"vip = true AND n in [1,2]\n")
+
+	code, out, _ = runCLI("", "fmt", "-to", "yaml", a)
+	so(code, eq, exitOK)
+	so(out, convey.ShouldStartWith, "and:\n  - [age, '>=', 18]\n")
+
+	// -l 列出需要格式化的文件, -w 写回
+	code, out, _ = runCLI("", "fmt", "-l", a, b)
+	so(code, eq, exitFalse)
+	so(out, eq, a+"\n"+b+"\n")
+
+	code, out, _ = runCLI("", "fmt", "-w", a, b)
+	so(code, eq, exitOK)
+	so(out, eq, "")
+
+	code, out, _ = runCLI("", "fmt", "-l", a, b)
+	so(code, eq, exitOK)
+	so(out, eq, "")
+
+	// 大整数保持原样
+	big := filepath.Join(dir, "big.yaml")
+	err := os.WriteFile(big, []byte("[id, =, 9007199254740993]\n"), 0o644)
+	so(err, convey.ShouldBeNil)
+	code, out, _ = runCLI("", "fmt", "-to", "json", big)
+	so(code, eq, exitOK)
+	so(out, eq, `["id", "=", 9007199254740993]`+"\n")
+	code, out, _ = runCLI("", "fmt", "-to", "text", a, big)
+	so(code, eq, exitOK)
+	so(out, convey.ShouldEndWith, "\nid = 9007199254740993\n")
+
+	// 拼错的 key 不会在写回时被丢掉
+	typo := filepath.Join(dir, "typo.yaml")
+	content := "or:\n  - [a, =, 1]\n  - {field: b, op: =, valu: 1}\n"
+	err = os.WriteFile(typo, []byte(content), 0o644)
+	so(err, convey.ShouldBeNil)
+	code, out, errOut := runCLI("", "fmt", "-w", typo)
+	so(code, eq, exitError)
+	so(out, eq, "")
+	so(errOut, convey.ShouldContainSubstring, "or[1]: unknown key 'valu'")
+	got, err := os.ReadFile(typo)
+	so(err, convey.ShouldBeNil)
+	so(string(got), eq, content)
+
+	for _, args := range [][]string{
+		{"fmt"},
+		{"fmt", typo},
+		{"fmt", "-l", typo},
+		{"fmt", "-to", "xml", a},
+		{"fmt", "-to", "json", "-w", a},
+		{"fmt", filepath.Join(dir, "missing.json")},
+	} {
+		code, _, _ = runCLI("", args...)
+		so(code, eq, exitError)
+	}
+}
+
+func testCLIUsage(t *testing.T) {
+	code, _, errOut := runCLI("")
+	so(code, eq, exitError)
+	so(errOut, convey.ShouldStartWith, "usage:\n  jsonengine match")
+
+	code, _, _ = runCLI("", "help")
+	so(code, eq, exitOK)
+
+	code, _, errOut = runCLI("", "unknown")
+	so(code, eq, exitError)
+	so(errOut, convey.ShouldStartWith, "jsonengine: unknown command 'unknown'")
+
+	code, _, errOut = runCLI("", "filter", "-h")
+	so(code, eq, exitOK)
+	so(errOut, convey.ShouldContainSubstring, "-workers int")
+}
@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/Andrew-M-C/go.jsonvalue v1.3.9-0.20240706033503-8c40629d9c2c
 	github.com/smartystreets/goconvey v1.7.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
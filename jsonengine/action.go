@@ -0,0 +1,272 @@
+package jsonengine
+
+import (
+	"fmt"
+	"strings"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: type - Action
+
+// Action 表示规则命中 (Rule.Then) 或者未命中 (Rule.Else) 时执行的动作
+type Action struct {
+	// Result 为任意 JSON 值, 表示决策的结果。多条规则都给出 Result 时, 以最先执行的, 即优先级最高的为准
+	Result any `json:"result,omitempty" yaml:"result,omitempty"`
+	// Tags 追加到决策的标签中, 重复的标签只保留第一个
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Score 累加到决策的分数中, 可以为负数
+	Score float64 `json:"score,omitempty" yaml:"score,omitempty"`
+	// Message 为消息模板, 其中的 ${field} 会被替换为文档中 field 的值, field 的写法与 Expr.Field 相同, 但是必须
+	// 指向唯一的一个值。字符串原样输出, 其他类型输出为 JSON, 找不到的值输出为空字符串。$${ 表示 ${ 本身
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+type actionWrapping Action
+
+// UnmarshalJSON 与 Condition 相同, Result 中的整数不会丢失精度
+func (a *Action) UnmarshalJSON(b []byte) error {
+	w := &actionWrapping{}
+	if err := unmarshalExact(b, w); err != nil {
+		return err
+	}
+	v, err := exactNumbers(w.Result)
+	if err != nil {
+		return err
+	}
+	w.Result = v
+	*a = Action(*w)
+	return nil
+}
+
+// UnmarshalYAML 与 Condition 相同, Result 中数字的类型与 JSON 形式保持一致
+func (a *Action) UnmarshalYAML(node *yaml.Node) error {
+	w := &actionWrapping{}
+	if err := node.Decode(w); err != nil {
+		return err
+	}
+	if n := yamlMappingValue(node, "result"); n != nil {
+		v, err := yamlValue(n)
+		if err != nil {
+			return err
+		}
+		w.Result = v
+	}
+	*a = Action(*w)
+	return nil
+}
+
+// compiledAction 表示编译之后的 Action
+type compiledAction struct {
+	act Action
+	msg template
+}
+
+// compileAction 编译 Action。Action 会被深拷贝, 编译之后调用方再修改 a 不会影响结果
+func compileAction(a *Action) (*compiledAction, error) {
+	if a == nil {
+		return nil, nil
+	}
+	msg, err := parseTemplate(a.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &compiledAction{act: *copyAction(a), msg: msg}, nil
+}
+
+// copyAction 深拷贝 Action 的 Result 和 Tags, a 为 nil 时返回 nil
+func copyAction(a *Action) *Action {
+	if a == nil {
+		return nil
+	}
+	act := *a
+	act.Result = copyValue(a.Result)
+	act.Tags = append([]string(nil), a.Tags...)
+	return &act
+}
+
+// copyValue 深拷贝由 JSON 或 YAML 解析得到的值, 即其中的 []any 以及 map[string]any, 其他类型原样返回
+func copyValue(v any) any {
+	switch v := v.(type) {
+	default:
+		return v
+	case []any:
+		res := make([]any, len(v))
+		for i, sub := range v {
+			res[i] = copyValue(sub)
+		}
+		return res
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, sub := range v {
+			res[k] = copyValue(sub)
+		}
+		return res
+	}
+}
+
+// ----------------
+// MARK: type - Decision
+
+// Decision 表示 Decide 的结果, 即所有执行了的动作汇总之后的结果
+type Decision struct {
+	Result   any       `json:"result,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Score    float64   `json:"score"`
+	Messages []string  `json:"messages,omitempty"`
+	Outcomes []Outcome `json:"outcomes,omitempty"`
+}
+
+// Outcome 表示一条规则所执行的动作, 按照执行顺序排列在 Decision.Outcomes 中
+type Outcome struct {
+	ID string `json:"id"`
+	// Matched 为 true 表示执行了 Then, 否则执行了 Else
+	Matched bool     `json:"matched"`
+	Result  any      `json:"result,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Score   float64  `json:"score,omitempty"`
+	// Message 为替换了 ${field} 之后的消息
+	Message string `json:"message,omitempty"`
+}
+
+// Decide 编译规则并作出决策, 参见 RuleSet.Decide。如果同一组规则需要多次决策, 请使用 NewRuleSet 编译之后复用
+func Decide(value any, rules []Rule, opts ...Option) (*Decision, error) {
+	rs, err := NewRuleSet(rules, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return rs.Decide(value)
+}
+
+// Decide 按照优先级匹配所有已启用的规则, 命中时执行 Then, 否则执行 Else, 并汇总所有动作的结果。没有 Then
+// 和 Else 的规则仍然会被匹配, 但是不产生任何结果。出错时的处理与 FirstMatch 相同。
+//
+// 返回的 Result 和 Tags 每次都是新的拷贝, 调用方可以任意修改
+func (rs *RuleSet) Decide(value any) (*Decision, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decision{}
+	tags := map[string]struct{}{}
+	for _, r := range rs.sorted {
+		b, err := r.prog.Match(v)
+		if err != nil {
+			return nil, fmt.Errorf("match rule '%s' error (%w)", r.rule.ID, err)
+		}
+		a := r.els
+		if b {
+			a = r.then
+		}
+		if a == nil {
+			continue
+		}
+
+		o := Outcome{
+			ID:      r.rule.ID,
+			Matched: b,
+			Result:  copyValue(a.act.Result),
+			Tags:    append([]string(nil), a.act.Tags...),
+			Score:   a.act.Score,
+			Message: a.msg.render(v),
+		}
+		d.Outcomes = append(d.Outcomes, o)
+
+		if d.Result == nil {
+			d.Result = copyValue(a.act.Result)
+		}
+		for _, t := range o.Tags {
+			if _, exist := tags[t]; !exist {
+				tags[t] = struct{}{}
+				d.Tags = append(d.Tags, t)
+			}
+		}
+		d.Score += o.Score
+		if o.Message != "" {
+			d.Messages = append(d.Messages, o.Message)
+		}
+	}
+	return d, nil
+}
+
+// ----------------
+// MARK: type - template
+
+// template 表示解析之后的消息模板, 由文本和 ${field} 交替组成
+type template []templatePart
+
+type templatePart struct {
+	text string
+	// ref 为 true 时表示 ${field}, chain 为 nil 表示文档根
+	ref   bool
+	chain []field
+}
+
+func parseTemplate(s string) (template, error) {
+	var t template
+	text := strings.Builder{}
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			text.WriteString(s)
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			// $${ 表示 ${ 本身
+			text.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		text.WriteString(s[:i])
+		s = s[i+2:]
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("%w, unclosed '${' in message template", ErrIllegalTargetValue)
+		}
+		f := strings.TrimSpace(s[:end])
+		s = s[end+1:]
+
+		chain, err := ParsePathSyntax(f, SyntaxAuto)
+		if err != nil {
+			return nil, fmt.Errorf("%w, message template (%v)", ErrIllegalField, err)
+		}
+		if !isSingle(chain) {
+			return nil, fmt.Errorf("%w '%s', field in message template should refer to exactly one value",
+				ErrIllegalField, f)
+		}
+		if text.Len() > 0 {
+			t = append(t, templatePart{text: text.String()})
+			text.Reset()
+		}
+		t = append(t, templatePart{ref: true, chain: chain})
+	}
+	if text.Len() > 0 {
+		t = append(t, templatePart{text: text.String()})
+	}
+	return t, nil
+}
+
+func (t template) render(root *jsonvalue.V) string {
+	b := strings.Builder{}
+	for _, p := range t {
+		if !p.ref {
+			b.WriteString(p.text)
+			continue
+		}
+		v, err := resolveChain(root, p.chain)
+		switch {
+		case err != nil:
+			// 找不到的值输出为空字符串
+		case v.IsString():
+			b.WriteString(v.String())
+		default:
+			s := v.MustMarshalString(jsonvalue.OptDefaultStringSequence(), jsonvalue.OptEscapeHTML(false))
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
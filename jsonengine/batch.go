@@ -0,0 +1,352 @@
+package jsonengine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: array
+
+// Filter 返回 values 中满足条件的所有元素, 参见 Program.Filter
+func Filter(values any, cond Condition, opts ...Option) ([]any, error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Filter(values)
+}
+
+// Partition 将 values 中的元素分为满足条件和不满足条件的两组, 参见 Program.Partition
+func Partition(values any, cond Condition, opts ...Option) (matched, unmatched []any, err error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.Partition(values)
+}
+
+// Count 返回 values 中满足条件的元素个数, 参见 Program.Count
+func Count(values any, cond Condition, opts ...Option) (int, error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return p.Count(values)
+}
+
+// FindFirst 返回 values 中第一个满足条件的元素及其下标, 参见 Program.FindFirst
+func FindFirst(values any, cond Condition, opts ...Option) (any, int, error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return nil, -1, err
+	}
+	return p.FindFirst(values)
+}
+
+// Filter 返回 values 中满足条件的所有元素, 保持原有的顺序, 没有元素满足时返回 nil。
+//
+// values 可以是 JSON 数组类型的 *jsonvalue.V, 此时返回的元素均为 *jsonvalue.V; 也可以是 []any 或者其他
+// 任意类型的切片和数组, 此时返回原始的元素。每个元素只会被导入一次, 任何一个元素匹配出错时立即返回错误
+func (p *Program) Filter(values any) ([]any, error) {
+	var res []any
+	err := p.rangeValues(values, func(_ int, elem any, matched bool) bool {
+		if matched {
+			res = append(res, elem)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Partition 将 values 中的元素分为满足条件和不满足条件的两组, 均保持原有的顺序。values 的要求与 Filter 相同
+func (p *Program) Partition(values any) (matched, unmatched []any, err error) {
+	err = p.rangeValues(values, func(_ int, elem any, b bool) bool {
+		if b {
+			matched = append(matched, elem)
+		} else {
+			unmatched = append(unmatched, elem)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return matched, unmatched, nil
+}
+
+// Count 返回 values 中满足条件的元素个数。values 的要求与 Filter 相同
+func (p *Program) Count(values any) (int, error) {
+	n := 0
+	err := p.rangeValues(values, func(_ int, _ any, matched bool) bool {
+		if matched {
+			n++
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// FindFirst 返回 values 中第一个满足条件的元素及其下标, 之后的元素不再匹配。没有元素满足时返回 nil 和 -1。
+// values 的要求与 Filter 相同
+func (p *Program) FindFirst(values any) (any, int, error) {
+	var res any
+	at := -1
+	err := p.rangeValues(values, func(i int, elem any, matched bool) bool {
+		if matched {
+			res, at = elem, i
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, -1, err
+	}
+	return res, at, nil
+}
+
+// rangeValues 依次匹配 values 中的每一个元素, callback 返回 false 时停止遍历
+func (p *Program) rangeValues(values any, callback func(i int, elem any, matched bool) bool) error {
+	n, at, err := arrayElements(values)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		elem := at(i)
+		b, err := p.Match(elem)
+		if err != nil {
+			return fmt.Errorf("match element %d error (%w)", i, err)
+		}
+		if !callback(i, elem, b) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// arrayElements 返回数组的长度以及获取其中元素的方法
+func arrayElements(values any) (int, func(int) any, error) {
+	switch arr := values.(type) {
+	case *jsonvalue.V:
+		if arr == nil || !arr.IsArray() {
+			return 0, nil, fmt.Errorf("%w, values should be an array", ErrTypeNotMatch)
+		}
+		children := arr.ForRangeArr()
+		return len(children), func(i int) any { return children[i] }, nil
+
+	case []any:
+		return len(arr), func(i int) any { return arr[i] }, nil
+	}
+
+	rv := reflect.ValueOf(values)
+	if k := rv.Kind(); k != reflect.Slice && k != reflect.Array {
+		return 0, nil, fmt.Errorf("%w, values should be an array but got %T", ErrTypeNotMatch, values)
+	}
+	return rv.Len(), func(i int) any { return rv.Index(i).Interface() }, nil
+}
+
+// ----------------
+// MARK: NDJSON
+
+// ndjsonBatchSize 表示 FilterReader 每次交给一个 worker 处理的行数
+const ndjsonBatchSize = 256
+
+// FilterReader 从 r 中读取 NDJSON (每行一个 JSON 值), 并将满足条件的行写入 w, 参见 Program.FilterReader
+func FilterReader(r io.Reader, w io.Writer, cond Condition, opts ...Option) (int, error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return p.FilterReader(r, w)
+}
+
+// FilterReader 从 r 中读取 NDJSON (每行一个 JSON 值), 并将满足条件的行原样写入 w, 每行以 \n 结尾, 返回写入的
+// 行数。空行会被忽略。
+//
+// 内存的占用与输入的大小无关: 编译时使用 OptWorkers 指定多个 worker 时, 同时持有的大约为 3 * workers 批, 每批
+// 256 行; 否则逐批处理。多个 worker 并行时输出的顺序仍然与输入相同。
+//
+// 任何一行无法解析或者匹配出错时停止读取并返回错误, 错误中带有行号, 在此之前满足条件的行已经写入 w
+func (p *Program) FilterReader(r io.Reader, w io.Writer) (int, error) {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var n int
+	var err error
+	if p.opt.workers > 1 {
+		n, err = p.filterParallel(br, bw, p.opt.workers)
+	} else {
+		n, err = p.filterSequential(br, bw)
+	}
+	if flushErr := bw.Flush(); err == nil {
+		err = flushErr
+	}
+	return n, err
+}
+
+// ndjsonBatch 表示一批连续的行
+type ndjsonBatch struct {
+	// first 表示第一行的行号, 从 1 开始
+	first int
+	lines [][]byte
+	// matched 表示每一行是否满足条件, err 表示第一个出错的行
+	matched []bool
+	err     error
+	done    chan struct{}
+}
+
+// readBatch 读取下一批, 没有更多的行时返回 nil。读取出错时返回已经读取的行以及错误
+func readBatch(r *bufio.Reader, line *int) (*ndjsonBatch, error) {
+	b := &ndjsonBatch{first: *line + 1}
+	for len(b.lines) < ndjsonBatchSize {
+		s, err := r.ReadBytes('\n')
+		if len(s) > 0 {
+			*line++
+			b.lines = append(b.lines, s)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				if len(b.lines) == 0 {
+					return nil, nil
+				}
+			}
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// matchBatch 匹配一批中的所有行, 遇到错误时停止
+func (p *Program) matchBatch(b *ndjsonBatch) {
+	b.matched = make([]bool, len(b.lines))
+	for i, s := range b.lines {
+		s = bytes.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		v, err := jsonvalue.Unmarshal(s)
+		if err != nil {
+			b.err = fmt.Errorf("line %d: %w", b.first+i, err)
+			return
+		}
+		if b.matched[i], err = p.Match(v); err != nil {
+			b.err = fmt.Errorf("line %d: match error (%w)", b.first+i, err)
+			return
+		}
+	}
+}
+
+// write 写入一批中满足条件的行, 返回写入的行数。出错的行之前的结果仍然会被写入
+func (b *ndjsonBatch) write(w *bufio.Writer) (int, error) {
+	n := 0
+	for i, s := range b.lines {
+		if !b.matched[i] {
+			continue
+		}
+		if _, err := w.Write(bytes.TrimRight(s, "\r\n")); err != nil {
+			return n, err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, b.err
+}
+
+func (p *Program) filterSequential(r *bufio.Reader, w *bufio.Writer) (int, error) {
+	total, line := 0, 0
+	for {
+		b, readErr := readBatch(r, &line)
+		if b == nil {
+			return total, readErr
+		}
+		p.matchBatch(b)
+		n, err := b.write(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if readErr != nil {
+			return total, readErr
+		}
+	}
+}
+
+// filterParallel 由一个 goroutine 读取, 多个 worker 匹配, 当前 goroutine 按照读取的顺序写入
+func (p *Program) filterParallel(r *bufio.Reader, w *bufio.Writer, workers int) (int, error) {
+	jobs := make(chan *ndjsonBatch, workers)
+	order := make(chan *ndjsonBatch, workers*2)
+	stop := make(chan struct{})
+
+	var readErr error
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		line := 0
+		for {
+			// 写入出错后不再读取; 下面的 select 在两个 case 均就绪时随机选择, 不能仅依赖它退出
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			b, err := readBatch(r, &line)
+			if b != nil {
+				b.done = make(chan struct{})
+				select {
+				case order <- b:
+				case <-stop:
+					return
+				}
+				jobs <- b
+			}
+			if b == nil || err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range jobs {
+				p.matchBatch(b)
+				close(b.done)
+			}
+		}()
+	}
+
+	total := 0
+	var err error
+	for b := range order {
+		<-b.done
+		if err != nil {
+			// 已经出错, 等待读取的 goroutine 退出
+			continue
+		}
+		n, writeErr := b.write(w)
+		total += n
+		if writeErr != nil {
+			err = writeErr
+			close(stop)
+		}
+	}
+	if err != nil {
+		return total, err
+	}
+	return total, readErr
+}
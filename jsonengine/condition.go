@@ -1,13 +1,12 @@
 package jsonengine
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
-	"time"
-
-	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
 )
 
 // ----------------
@@ -15,277 +14,177 @@ import (
 
 // Condition 表示一个条件
 type Condition struct {
-	Expr
+	Expr `yaml:",inline"`
 
 	OR  OR  `json:"or,omitempty"  yaml:"or,omitempty"`
 	AND AND `json:"and,omitempty" yaml:"and,omitempty"`
 
 	NOT *NOT `json:"not,omitempty" yaml:"not,omitempty"`
+
+	// Any, All, None 表示数组元素条件, 类似于 MongoDB 的 $elemMatch。Field 指向一个数组 (不能包含 [+]
+	// 或 [*]), 子条件以每一个数组元素为根进行匹配, 分别表示任意一个、每一个、没有一个元素满足子条件。
+	// 与 [+] 不同, 子条件中的多个 Expr 一定是由同一个元素满足的
+	Any  *Condition `json:"any,omitempty"  yaml:"any,omitempty"`
+	All  *Condition `json:"all,omitempty"  yaml:"all,omitempty"`
+	None *Condition `json:"none,omitempty" yaml:"none,omitempty"`
 }
 
 type conditionWrapping Condition
 
 // UnmarshalJSON is redefined to support simple SQL style expression written as
-// a 3-element-array, like ["result.list.[+].code", "=", 200]
+// a 3-element-array, like ["result.list.[+].code", "=", 200]. The value could be
+// omitted for operators which do not need one, like ["result.msg", "exists"]
+//
+// Integers in the value are decoded as int64 (or uint64) instead of float64, see Expr
 func (c *Condition) UnmarshalJSON(b []byte) error {
 	w := &conditionWrapping{}
-	officialErr := json.Unmarshal(b, w)
+	officialErr := unmarshalExact(b, w)
 	if officialErr == nil {
+		v, err := exactNumbers(w.Value)
+		if err != nil {
+			return err
+		}
+		w.Value = v
 		*c = *(*Condition)(w)
 		return nil
 	}
 
-	j, err := jsonvalue.Unmarshal(b)
-	if err != nil {
-		return officialErr
-	}
-	if !j.IsArray() {
+	var arr []json.RawMessage
+	if err := json.Unmarshal(b, &arr); err != nil {
 		return officialErr
 	}
-	if j.Len() != 3 {
-		return fmt.Errorf("SQL style expr should have length 3, but got %d", j.Len())
+	if len(arr) != 2 && len(arr) != 3 {
+		return fmt.Errorf("SQL style expr should have length 2 or 3, but got %d", len(arr))
 	}
 
-	f, err := j.GetString(0)
-	if err != nil {
+	var f, o string
+	if err := json.Unmarshal(arr[0], &f); err != nil {
 		return fmt.Errorf("get SQL style expr field error (%w)", err)
 	}
-
-	o, err := j.GetString(1)
-	if err != nil {
+	if err := json.Unmarshal(arr[1], &o); err != nil {
 		return fmt.Errorf("get SQL style expr operator error (%w)", err)
 	}
 
 	c.Field = f
 	c.Operator = o
-	c.Value, _ = j.Get(2)
-	return nil
-}
-
-// ----------------
-// MARK: type - Expr
-
-// Expr 表示一个最简单的表达式条件。
-//
-// Field 使用点分隔, 需要注意的是, [*] 表示数组中所有的类型都需要匹配, [+] 表示数组中任意一个满足条件即可
-type Expr struct {
-	Field    string `json:"field,omitempty" yaml:"field,omitempty"`
-	Operator string `json:"op"              yaml:"op"`
-	Value    any    `json:"value"           yaml:"value"`
-
-	// lazy init
-	targetValue *jsonvalue.V
-	fieldChain  []field
-}
-
-type exprOption struct {
-	TimeFormat string
-}
-
-func (e *Expr) match(v *jsonvalue.V, opt exprOption) (bool, error) {
-	if e.fieldChain == nil {
-		e.fieldChain = parseField(e.Field)
-	}
-	if e.targetValue == nil {
-		tgt, err := jsonvalue.Import(e.Value)
+	if len(arr) == 3 {
+		var v any
+		err := unmarshalExact(arr[2], &v)
+		if err == nil {
+			v, err = exactNumbers(v)
+		}
 		if err != nil {
-			return false, fmt.Errorf("%w (%v)", ErrImportTargetValue, err)
+			return fmt.Errorf("get SQL style expr value error (%w)", err)
 		}
-		e.targetValue = tgt
+		c.setSQLStyleValue(v)
 	}
+	return nil
+}
 
-	debug("got expr %+v", e)
-
-	// 当前值比较
-	if len(e.fieldChain) == 0 {
-		return compare(v, e.Operator, e.targetValue, opt.TimeFormat)
+// setSQLStyleValue 设置 SQL 风格数组中的值。只有一个字符串类型 ref 字段的 object 表示引用, 如
+// ["a",">=",{"ref":"$b"}], 其他值 (包括 "$USD" 这样的字符串) 均为字面量
+func (e *Expr) setSQLStyleValue(v any) {
+	if ref, ok := refObject(v); ok {
+		e.Ref = ref
+		return
 	}
+	e.Value = v
+}
 
-	// 以下层层匹配
-	subExpr := &Expr{
-		Operator:    e.Operator,
-		targetValue: e.targetValue,
-		fieldChain:  e.fieldChain[1:],
+// refObject 判断 v 是否为 {"ref":"xxx"} 形式的引用
+func refObject(v any) (string, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
 	}
+	ref, ok := m["ref"].(string)
+	return ref, ok
+}
 
-	// object 就是最简单的单层匹配就行了
-	top := e.fieldChain[0]
-	if top.Object != "" {
-		subV, err := v.Get(top.Object)
-		if err != nil {
-			debug("Get and got error: '%v', top field '%v', value %v", err, top.Object, v)
-			return false, err
-		}
-		return subExpr.match(subV, opt)
+// unmarshalExact 与 json.Unmarshal 相同, 但是 any 类型中的数字解析为 json.Number, 需要再使用 exactNumbers 转换
+func unmarshalExact(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
 	}
-
-	// 以下是数组逻辑
-	if !v.IsArray() {
-		return false, fmt.Errorf("%w, target to match is not an array", ErrTypeNotMatch)
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after top-level value")
 	}
+	return nil
+}
 
-	// 数组中的任意一个
-	if top.Array.Any {
-		var lastErr error
-		for _, subV := range v.ForRangeArr() {
-			b, err := subExpr.match(subV, opt)
+// exactNumbers 将值中所有的 json.Number 转为 numberValue 的结果
+func exactNumbers(v any) (any, error) {
+	switch v := v.(type) {
+	default:
+		return v, nil
+	case json.Number:
+		return numberValue(string(v))
+	case []any:
+		for i, sub := range v {
+			n, err := exactNumbers(sub)
 			if err != nil {
-				lastErr = err
-				continue
-			}
-			if b {
-				// 只要有一个符合条件, 那么就不返回 err 了
-				return true, nil
+				return nil, err
 			}
+			v[i] = n
 		}
-		return false, lastErr
-	}
-
-	// 数组中的每一个
-	if top.Array.All {
-		for _, subV := range v.ForRangeArr() {
-			b, err := subExpr.match(subV, opt)
+		return v, nil
+	case map[string]any:
+		for k, sub := range v {
+			n, err := exactNumbers(sub)
 			if err != nil {
-				return false, err
-			}
-			if !b {
-				return false, nil
+				return nil, err
 			}
+			v[k] = n
 		}
-		return true, nil
-	}
-
-	// 如果是指定 array 的具体某个 index, 那也算简单匹配
-	subV, err := v.Get(top.Array.At)
-	if err != nil {
-		return false, err
+		return v, nil
 	}
-	return subExpr.match(subV, opt)
 }
 
-func compare(v *jsonvalue.V, op string, target *jsonvalue.V, timeFmt string) (bool, error) {
-	switch op = strings.ToLower(strings.TrimSpace(op)); op {
-	default:
-		// 所有奇怪的符号都交给这里统一过滤
-		return compareNumber(v, op, target, timeFmt)
-
-	case "≹", "≸", "≠", "<>", "!=", "ne":
-		res := !v.Equal(target)
-		debug("%v != %v ? %v", v, target, res)
-		return res, nil
-
-	case "=", "==", "===", "eq":
-		if v.ValueType() != target.ValueType() {
-			return false, fmt.Errorf(
-				"%w value and target should have same value type, but got (%v, %v)",
-				ErrTypeNotMatch, v.ValueType(), target.ValueType(),
-			)
-		}
-		res := v.Equal(target)
-		debug("%v == %v ? %v", v, target, res)
-		return res, nil
-
-	case "in":
-		return compareIn(v, target)
-	}
-}
-
-func compareNumber(v *jsonvalue.V, op string, target *jsonvalue.V, timeFmt string) (bool, error) {
-	formatError := func() error {
-		return fmt.Errorf(
-			"%w, expected both value and target both number or timed string, but got (%v, %v)",
-			ErrTypeNotMatch, v.ValueType(), target.ValueType(),
-		)
-	}
-
-	debug("timeFmt: %s", timeFmt)
-
-	compareTime := false
-	var leftTime, rightTime time.Time
-	if v.IsNumber() && target.IsNumber() {
-		// OK
-	} else if timeFmt != "" && v.IsString() && target.IsString() {
-		var err error
-		if leftTime, err = time.Parse(timeFmt, v.String()); err != nil {
-			debug("parse time error: %v, source %v", err, v)
-			return false, formatError()
-		}
-		if rightTime, err = time.Parse(timeFmt, target.String()); err != nil {
-			debug("parse time error: %v, source %v", err, target)
-			return false, formatError()
-		}
-		compareTime = true
-	} else {
-		return false, formatError()
+// numberValue 解析数字字面量。整数解析为 int64 (超出范围的正整数为 uint64), 以保证大于 2^53 的整数不丢失精度;
+// 其他数字解析为 float64
+func numberValue(s string) (any, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
 	}
-
-	var res bool
-	switch op {
-	default:
-		return false, fmt.Errorf("%w (%s)", ErrIllegalOperator, op)
-
-	case "≶", "≷":
-		if compareTime {
-			res = leftTime.Equal(rightTime)
-		} else {
-			res = !v.Equal(target)
-		}
-		debug("%v != %v ? %v", v, target, res)
-		return res, nil
-
-	case "<", "≱", "lt":
-		if compareTime {
-			res = leftTime.Before(rightTime)
-		} else {
-			res = v.Float64() < target.Float64()
-		}
-		debug("%v < %v ? %v", v, target, res)
-		return res, nil
-
-	case "<=", "≤", "≦", "≯", "le":
-		if compareTime {
-			res = !leftTime.After(rightTime)
-		} else {
-			res = v.Float64() <= target.Float64()
-		}
-		debug("%v <= %v ? %v", v, target, res)
-		return res, nil
-
-	case ">", "≰", "gt":
-		if compareTime {
-			res = leftTime.After(rightTime)
-		} else {
-			res = v.Float64() > target.Float64()
-		}
-		debug("%v > %v ? %v", v, target, res)
-		return res, nil
-
-	case ">=", "≥", "≧", "≮", "ge":
-		if compareTime {
-			res = !leftTime.Before(rightTime)
-		} else {
-			res = v.Float64() >= target.Float64()
-		}
-		debug("%v >= %v ? %v", v, target, res)
-		return res, nil
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u, nil
 	}
+	return strconv.ParseFloat(s, 64)
 }
 
-func compareIn(v *jsonvalue.V, target *jsonvalue.V) (bool, error) {
-	if !target.IsArray() {
-		return false, fmt.Errorf(
-			"%w, target value should be an array but got %v",
-			ErrTypeNotMatch, target.ValueType(),
-		)
-	}
-
-	for _, subTarget := range target.ForRangeArr() {
-		if v.Equal(subTarget) {
-			return true, nil
-		}
-	}
+// ----------------
+// MARK: type - Expr
 
-	return false, nil
+// Expr 表示一个最简单的表达式条件。
+//
+// Field 使用点分隔, 需要注意的是, [*] 表示数组中所有的类型都需要匹配, [+] 表示数组中任意一个满足条件即可,
+// [!] 或 [none] 表示数组中没有一个满足条件, [one] 表示恰好有一个满足条件, [=N]、[>=N]、[<=N]、[>N]、[<N]
+// 表示满足条件的元素个数。量词 +、*、! 之后可以跟上 Python 风格的切片, 表示仅匹配切片范围内的元素,
+// 如 [+0:3] 表示前三个元素中的任意一个, [*-5:] 表示最后五个元素中的每一个, [!::2] 表示偶数下标的元素中没有
+// 一个满足条件。{*}、{+} 等表示按照同样的量词遍历 object 的所有值; ..name 表示在任意深度查找 name 字段,
+// 任意一个满足条件即可。最后一段为 # 时表示取长度, 如 items.# 表示 items 数组的长度。包含 . 等特殊字符的 key
+// 可以使用引号括起来或者使用反斜杠转义, 如 headers.'x-request.id', 完整的语法参见 Path
+//
+// Field 也可以使用 JSON Pointer (如 /items/0/sku) 或 JSONPath (如 $.items[*].sku) 书写, 由 Syntax 指定,
+// Syntax 为空时按照前缀自动识别: 以 / 开头的是 JSON Pointer, 以 $ 开头的是 JSONPath, 详见 FieldSyntax
+//
+// Ref 表示与文档中另一个值进行比较, 与 Value 互斥。Ref 默认相对于文档根, 可以带有 $ 前缀, 如 $.order.date;
+// 以 @ 开头时表示相对于 Field 中正在遍历的数组元素, 如 @.qty。SQL 风格数组中使用 {"ref":"xxx"} 作为值表示引用,
+// 如 ["shipping.date",">=",{"ref":"$order.date"}]; 其他值均为字面量, 如 ["currency","=","$USD"] 比较的是
+// 字符串 $USD。Parse 中不带引号的 $xxx / @xxx 同样表示引用
+//
+// 通过 JSON、YAML 或者 Parse 解析得到的 Value 中, 整数为 int64 (超出范围的正整数为 uint64), 其他数字为 float64,
+// 以保证大于 2^53 的整数不丢失精度。注意这与之前的版本不兼容: 之前所有的数字均为 float64。匹配时数字按照数值比较,
+// int64(18) 与 float64(18) 相等, 但是大于 2^53 的整数与已经丢失了精度的 float64 不再相等, 如使用 encoding/json
+// 解析得到的文档中的 9007199254740993 (实际为 9007199254740992)
+type Expr struct {
+	Field    string      `json:"field,omitempty"  yaml:"field,omitempty"`
+	Syntax   FieldSyntax `json:"syntax,omitempty" yaml:"syntax,omitempty"`
+	Operator string      `json:"op"               yaml:"op"`
+	Value    any         `json:"value"            yaml:"value"`
+	Ref      string      `json:"ref,omitempty"    yaml:"ref,omitempty"`
 }
 
 // ----------------
@@ -312,54 +211,105 @@ type NOT struct {
 // MARK: type - field
 
 type field struct {
+	// 表示取当前值的长度, 即数组长度、对象的 key 数量或者是字符串的字符数, 使用 # 表示
+	Length bool
 	// 表示 object 类型的一个字段
 	Object string
+	// 表示 JSON Pointer 中的数字 token, 当前值为数组时按照下标 Array.At 处理, 否则按照 key Object 处理
+	Index bool
+	// 表示在任意深度查找 Object 字段, 使用 ..name 表示
+	Recursive bool
+	// 不为 nil 时表示按照量词遍历 object 的所有值, 使用 {*}、{+} 等表示
+	Values *quantifier
 	// 表示数组的字段
-	Array struct {
-		All bool
-		Any bool
-		At  int
-	}
+	Array arrayField
 }
 
-// parseField 解析 Field 字段
-func parseField(f string) []field {
-	parts := strings.Split(f, ".")
-	fieldChain := make([]field, 0, len(parts))
-
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]") {
-			if f, ok := parseArrayField(part); ok {
-				fieldChain = append(fieldChain, f)
-			}
-		} else {
-			fieldChain = append(fieldChain, field{Object: part})
-		}
-	}
-
-	return fieldChain
+type arrayField struct {
+	// Quant 表示量词, 为 quantIndex 时表示指定下标 At
+	Quant quantifier
+	At    int
+	// Slice 不为 nil 时表示量词仅作用于切片范围内的元素
+	Slice *arraySlice
+	// Filter 不为 nil 时表示量词仅作用于满足过滤条件的元素, 如 [?(@.qty>1)]
+	Filter *filter
 }
 
 func parseArrayField(part string) (field, bool) {
 	f := field{}
 
 	le := len(part)
-	switch s := part[1 : le-1]; s {
-	default:
-		n, err := strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			return f, false
-		}
-		f.Array.At = int(n)
+	s := strings.TrimSpace(part[1 : le-1])
+	if strings.Contains(s, ":") {
+		return parseSlicedArrayField(s)
+	}
+	if q, ok := parseQuantifier(s); ok {
+		f.Array.Quant = q
 		return f, true
+	}
 
-	case "*":
-		f.Array.All = true
-		return f, true
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return f, false
+	}
+	f.Array.At = int(n)
+	return f, true
+}
 
+// parseQuantifier 解析数组或 object 遍历的量词
+func parseQuantifier(s string) (quantifier, bool) {
+	switch s {
+	default:
+		return parseCountQuantifier(s)
+	case "*":
+		return quantifier{kind: quantAll}, true
 	case "+":
-		f.Array.Any = true
-		return f, true
+		return quantifier{kind: quantAny}, true
+	case "!", "none":
+		return quantifier{kind: quantNone}, true
+	case "one":
+		return quantifier{kind: quantCount, cmp: opEqual, n: 1}, true
+	}
+}
+
+// parseSlicedArrayField 解析带有切片的数组字段, 切片前必须带有 +、* 或 ! 量词, 如 +0:3
+func parseSlicedArrayField(s string) (field, bool) {
+	f := field{}
+	if s == "" {
+		return f, false
+	}
+	switch s[0] {
+	default:
+		return f, false
+	case '+':
+		f.Array.Quant.kind = quantAny
+	case '*':
+		f.Array.Quant.kind = quantAll
+	case '!':
+		f.Array.Quant.kind = quantNone
+	}
+
+	sl, ok := parseArraySlice(s[1:])
+	if !ok {
+		return f, false
+	}
+	f.Array.Slice = sl
+	return f, true
+}
+
+// parseCountQuantifier 解析计数量词, 如 =2, >=2, <=2, >2, <2
+func parseCountQuantifier(s string) (quantifier, bool) {
+	q := quantifier{kind: quantCount}
+	for _, prefix := range []string{">=", "<=", "=", ">", "<"} {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(s[len(prefix):]), 10, 31)
+		if err != nil {
+			return q, false
+		}
+		q.cmp, q.n = operatorAliases[prefix], int(n)
+		return q, true
 	}
+	return q, false
 }
@@ -0,0 +1,414 @@
+package jsonengine
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - quantifier
+
+// quantKind 表示量词的种类
+type quantKind uint8
+
+const (
+	// quantIndex 表示没有量词, 即指定数组下标
+	quantIndex quantKind = iota
+	quantAny
+	quantAll
+	quantNone
+	quantCount
+)
+
+// quantifier 表示如何汇总数组中每一个元素的匹配结果
+type quantifier struct {
+	kind quantKind
+	// 以下仅 quantCount 有效, 表示满足条件的元素个数与 n 的比较关系
+	cmp opKind
+	n   int
+}
+
+func (q quantifier) String() string {
+	switch q.kind {
+	default:
+		return "index"
+	case quantAny:
+		return "any"
+	case quantAll:
+		return "all"
+	case quantNone:
+		return "none"
+	case quantCount:
+		return fmt.Sprintf("count%v%d", q.cmp, q.n)
+	}
+}
+
+// countMatched 表示 n 个元素满足条件时, 是否满足计数量词
+func (q quantifier) countMatched(n int) bool {
+	switch q.cmp {
+	default:
+		return n == q.n
+	case opLess:
+		return n < q.n
+	case opLessOrEqual:
+		return n <= q.n
+	case opGreater:
+		return n > q.n
+	case opGreaterOrEqual:
+		return n >= q.n
+	}
+}
+
+// countDecided 表示 n 个元素满足条件时, 是否已经可以确定结果而不需要继续遍历
+func (q quantifier) countDecided(n int) bool {
+	switch q.cmp {
+	default: // =, <=, >
+		return n > q.n
+	case opLess, opGreaterOrEqual:
+		return n >= q.n
+	}
+}
+
+// missing 表示数组不存在或者不是数组, 并且按照 OptWhenNotFound / OptWhenTypeMismatch 返回 false 时量词的结果。
+// 与 rangeQuantified 中出错的元素相同, 视为没有任何元素满足条件
+func (q quantifier) missing() bool {
+	switch q.kind {
+	default:
+		return false
+	case quantNone:
+		return true
+	case quantCount:
+		return q.countMatched(0)
+	}
+}
+
+// firstQuantifier 返回 field 中第一个会遍历多个值的量词, 没有时返回 quantIndex
+func firstQuantifier(chain []field) quantifier {
+	for _, f := range chain {
+		switch {
+		case f.Values != nil:
+			return *f.Values
+		case f.Recursive:
+			return quantifier{kind: quantAny}
+		case f.Array.Quant.kind != quantIndex:
+			return f.Array.Quant
+		}
+	}
+	return quantifier{kind: quantIndex}
+}
+
+// rangeFunc 表示遍历数组元素的方法, 与 jsonvalue.V.RangeArray 相同
+type rangeFunc func(callback func(i int, v *jsonvalue.V) bool)
+
+// rangeQuantified 遍历数组中的元素, 并按照量词汇总匹配结果。
+//
+// 对于出错的元素: 无论出错的元素是否满足条件结果都相同时, 忽略错误; 否则按照 OptWhenNotFound /
+// OptWhenTypeMismatch 处理最后一个错误, 返回 false 时出错的元素视为不满足条件。因此 none 和 [=0] 的结果
+// (或者错误) 总是与 NOT any 相同。
+//
+// 例外的是 all ([*]), 与之前的版本保持一致, 按顺序遍历时遇到出错的元素立即停止并处理该错误, 即只有出错的元素
+// 之前已经有不满足条件的元素时才返回 false 而不是错误
+func rangeQuantified(
+	ctx *matchContext, rangeArray rangeFunc, q quantifier, each func(int, *jsonvalue.V) (bool, error),
+) (bool, error) {
+	if q.kind == quantIndex {
+		return false, fmt.Errorf("unexpected quantifier %v", q)
+	}
+
+	matched, unmatched, unknown := 0, 0, 0
+	var lastErr error
+	rangeArray(func(i int, subV *jsonvalue.V) bool {
+		b, err := each(i, subV)
+		switch {
+		case err != nil:
+			lastErr, unknown = err, unknown+1
+			if q.kind == quantAll {
+				return false
+			}
+		case b:
+			matched++
+		default:
+			unmatched++
+		}
+		return !q.decided(matched, unmatched)
+	})
+
+	res := q.result(matched, unmatched+unknown)
+	if q.decided(matched, unmatched) {
+		return res, nil
+	}
+	for i := 1; i <= unknown; i++ {
+		if q.result(matched+i, unmatched+unknown-i) != res {
+			if _, err := ctx.tolerate(lastErr); err != nil {
+				return false, err
+			}
+			break
+		}
+	}
+	return res, nil
+}
+
+// result 返回 matched 个元素满足条件, unmatched 个元素不满足条件时的结果
+func (q quantifier) result(matched, unmatched int) bool {
+	switch q.kind {
+	default: // any
+		return matched > 0
+	case quantAll:
+		return unmatched == 0
+	case quantNone:
+		return matched == 0
+	case quantCount:
+		return q.countMatched(matched)
+	}
+}
+
+// decided 表示是否已经可以确定结果而不需要继续遍历
+func (q quantifier) decided(matched, unmatched int) bool {
+	switch q.kind {
+	default: // any, none
+		return matched > 0
+	case quantAll:
+		return unmatched > 0
+	case quantCount:
+		return q.countDecided(matched)
+	}
+}
+
+// ----------------
+// MARK: type - elemMatcher
+
+// elemMatcher 表示编译后的数组元素条件
+type elemMatcher struct {
+	field string
+	chain []field
+	q     quantifier
+	sub   matcher
+}
+
+func compileElem(e *Expr, q quantifier, cond *Condition, o *options) (*elemMatcher, error) {
+	chain, err := compileElemField(e, q)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := compileCondition(cond, o)
+	if err != nil {
+		return nil, err
+	}
+	return &elemMatcher{
+		field: e.Field,
+		chain: chain,
+		q:     q,
+		sub:   sub,
+	}, nil
+}
+
+// compileElemField 解析数组元素条件的 field, 它必须指向唯一的一个值
+func compileElemField(e *Expr, q quantifier) ([]field, error) {
+	chain, err := ParsePathSyntax(e.Field, e.Syntax)
+	if err != nil {
+		return nil, err
+	}
+	if !isSingle(chain) {
+		return nil, fmt.Errorf(
+			"%w '%s', field of '%v' condition should refer to exactly one array", ErrIllegalField, e.Field, q,
+		)
+	}
+	return chain, nil
+}
+
+func (m *elemMatcher) describe() *Explanation {
+	return &Explanation{
+		Type:  ExplainType(m.q.String()),
+		Field: m.field,
+	}
+}
+
+func (m *elemMatcher) match(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	b, err := m.matchElements(ctx, v)
+	if err != nil {
+		if _, err := ctx.tolerate(err); err != nil {
+			return false, err
+		}
+		return m.q.missing(), nil
+	}
+	return b, nil
+}
+
+func (m *elemMatcher) matchElements(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	arr, err := resolveChain(v, m.chain)
+	if err != nil {
+		return false, err
+	}
+	if !arr.IsArray() {
+		return false, fmt.Errorf("%w, '%s' is not an array but %v", ErrTypeNotMatch, m.field, arr.ValueType())
+	}
+
+	elem := ctx.elem
+	defer func() { ctx.elem = elem }()
+
+	return rangeQuantified(ctx, arr.RangeArray, m.q, func(i int, subV *jsonvalue.V) (bool, error) {
+		ctx.elem = subV
+		return ctx.matchElement(m.sub, subV, m.field, i)
+	})
+}
+
+// ----------------
+// MARK: type - arraySlice
+
+// arraySlice 表示 Python 风格的数组切片, 如 [+0:3], [*-3:], [!::2]
+type arraySlice struct {
+	start, stop       int
+	hasStart, hasStop bool
+	step              int
+}
+
+// parseArraySlice 解析切片部分, 如 0:3, -3:, ::2
+func parseArraySlice(s string) (*arraySlice, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, false
+	}
+
+	sl := &arraySlice{step: 1}
+	parse := func(s string, dst *int) (bool, bool) {
+		if s = strings.TrimSpace(s); s == "" {
+			return false, true
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return false, false
+		}
+		*dst = int(n)
+		return true, true
+	}
+
+	var ok bool
+	if sl.hasStart, ok = parse(parts[0], &sl.start); !ok {
+		return nil, false
+	}
+	if sl.hasStop, ok = parse(parts[1], &sl.stop); !ok {
+		return nil, false
+	}
+	if len(parts) == 3 {
+		if _, ok = parse(parts[2], &sl.step); !ok || sl.step == 0 {
+			return nil, false
+		}
+	}
+	return sl, true
+}
+
+// bounds 按照 Python 的规则, 计算长度为 n 的数组的实际起止下标
+func (sl *arraySlice) bounds(n int) (start, stop int) {
+	normalize := func(i, lo, hi int) int {
+		if i < 0 {
+			i += n
+		}
+		if i < lo {
+			return lo
+		}
+		if i > hi {
+			return hi
+		}
+		return i
+	}
+
+	if sl.step > 0 {
+		start, stop = 0, n
+		if sl.hasStart {
+			start = normalize(sl.start, 0, n)
+		}
+		if sl.hasStop {
+			stop = normalize(sl.stop, 0, n)
+		}
+		return start, stop
+	}
+
+	start, stop = n-1, -1
+	if sl.hasStart {
+		start = normalize(sl.start, -1, n-1)
+	}
+	if sl.hasStop {
+		stop = normalize(sl.stop, -1, n-1)
+	}
+	return start, stop
+}
+
+// rangeFunc 返回遍历切片中元素的方法
+func (sl *arraySlice) rangeFunc(v *jsonvalue.V) rangeFunc {
+	return func(callback func(int, *jsonvalue.V) bool) {
+		arr := v.ForRangeArr()
+		start, stop := sl.bounds(len(arr))
+		for i := start; (sl.step > 0 && i < stop) || (sl.step < 0 && i > stop); i += sl.step {
+			if !callback(i, arr[i]) {
+				return
+			}
+		}
+	}
+}
+
+// ----------------
+// MARK: type - pathValue
+
+// pathValue 表示遍历 object 或递归查找时得到的一个值, 以及其相对路径
+type pathValue struct {
+	path string
+	v    *jsonvalue.V
+}
+
+func rangePathValues(values []pathValue) rangeFunc {
+	return func(callback func(int, *jsonvalue.V) bool) {
+		for i, pv := range values {
+			if !callback(i, pv.v) {
+				return
+			}
+		}
+	}
+}
+
+// objectValues 按照 key 的顺序返回 object 的所有值
+func objectValues(v *jsonvalue.V) []pathValue {
+	keys := make([]string, 0, v.Len())
+	v.RangeObjects(func(k string, _ *jsonvalue.V) bool {
+		keys = append(keys, k)
+		return true
+	})
+	sort.Strings(keys)
+
+	values := make([]pathValue, 0, len(keys))
+	for _, k := range keys {
+		child, _ := v.Get(k)
+		values = append(values, pathValue{path: formatKey(k), v: child})
+	}
+	return values
+}
+
+// recursiveValues 深度优先地在任意深度查找 key, 返回所有找到的值
+func recursiveValues(v *jsonvalue.V, key, prefix string, values []pathValue) []pathValue {
+	formatted := formatKey(key)
+	join := func(seg string) string {
+		if prefix == "" {
+			return seg
+		}
+		return prefix + "." + seg
+	}
+
+	switch {
+	case v.IsObject():
+		for _, child := range objectValues(v) {
+			if child.path == formatted {
+				values = append(values, pathValue{path: join(formatted), v: child.v})
+			}
+			values = recursiveValues(child.v, key, join(child.path), values)
+		}
+	case v.IsArray():
+		v.RangeArray(func(i int, child *jsonvalue.V) bool {
+			values = recursiveValues(child, key, join("["+strconv.Itoa(i)+"]"), values)
+			return true
+		})
+	}
+	return values
+}
@@ -3,8 +3,17 @@ package jsonengine
 import jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
 
 const (
-	ErrNotFound          = jsonvalue.ErrNotFound
-	ErrTypeNotMatch      = jsonvalue.ErrTypeNotMatch
-	ErrImportTargetValue = jsonvalue.Error("import target value error")
-	ErrIllegalOperator   = jsonvalue.Error("illegal operator")
+	ErrNotFound           = jsonvalue.ErrNotFound
+	ErrTypeNotMatch       = jsonvalue.ErrTypeNotMatch
+	ErrImportTargetValue  = jsonvalue.Error("import target value error")
+	ErrIllegalOperator    = jsonvalue.Error("illegal operator")
+	ErrIllegalField       = jsonvalue.Error("illegal field")
+	ErrIllegalTargetValue = jsonvalue.Error("illegal target value")
+	ErrSyntax             = jsonvalue.Error("syntax error")
+	ErrIllegalCondition   = jsonvalue.Error("illegal condition")
+	ErrUnknownKey         = jsonvalue.Error("unknown key")
+	ErrIllegalOption      = jsonvalue.Error("illegal option")
+	ErrIllegalRule        = jsonvalue.Error("illegal rule")
+	ErrTableOverlap       = jsonvalue.Error("decision table rows overlap")
+	ErrTableGap           = jsonvalue.Error("decision table has gaps")
 )
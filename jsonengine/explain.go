@@ -0,0 +1,259 @@
+package jsonengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - Explanation
+
+// ExplainType 表示 Explanation 节点的类型
+type ExplainType string
+
+const (
+	ExplainOR   ExplainType = "or"
+	ExplainAND  ExplainType = "and"
+	ExplainNOT  ExplainType = "not"
+	ExplainExpr ExplainType = "expr"
+	ExplainAny  ExplainType = "any"
+	ExplainAll  ExplainType = "all"
+	ExplainNone ExplainType = "none"
+)
+
+// Explanation 表示一次匹配的解释, 其树形结构与 Condition 一一对应
+type Explanation struct {
+	Type   ExplainType `json:"type"`
+	Result bool        `json:"result"`
+
+	// Error 表示该节点返回的错误
+	Error string `json:"error,omitempty"`
+	// Swallowed 表示被 OptWhenNotFound / OptWhenTypeMismatch 吞掉并转为 false 的错误
+	Swallowed string `json:"swallowed,omitempty"`
+	// Skipped 表示由于短路求值而没有被执行的子条件个数
+	Skipped int `json:"skipped,omitempty"`
+
+	// 以下仅 expr 类型有效
+	Field    string          `json:"field,omitempty"`
+	Operator string          `json:"op,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+	Ref      string          `json:"ref,omitempty"`
+	Resolved []Resolved      `json:"resolved,omitempty"`
+
+	// Path 表示数组元素条件中, 子条件所匹配的数组元素的路径
+	Path string `json:"path,omitempty"`
+
+	Children []*Explanation `json:"children,omitempty"`
+}
+
+// Resolved 表示 expr 中的 field 实际解析到的一个值。对于 [+] / [*], Path 中包含了实际访问的数组下标
+type Resolved struct {
+	Path   string          `json:"path"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Target json.RawMessage `json:"target,omitempty"` // 仅在使用引用时有效, 表示引用解析得到的值
+	Result bool            `json:"result"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// MatchExplain 与 Match 相同, 但同时返回匹配过程的解释。即便匹配出错, 只要规则编译成功, 也会返回
+// 已经完成部分的解释
+func MatchExplain(value any, cond Condition, opts ...Option) (*Explanation, error) {
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Explain(value)
+}
+
+// Explain 与 Match 相同, 但同时返回匹配过程的解释, 可以并发调用
+func (p *Program) Explain(value any) (*Explanation, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	holder := &Explanation{}
+	ctx := &matchContext{
+		opt:   &p.opt,
+		root:  v,
+		trace: holder,
+	}
+	_, err = ctx.matchChild(p.root, v)
+	return holder.Children[0], err
+}
+
+// String 以缩进的文本形式展示解释
+func (e *Explanation) String() string {
+	b := strings.Builder{}
+	e.writeTo(&b, 0)
+	return b.String()
+}
+
+func (e *Explanation) writeTo(b *strings.Builder, depth int) {
+	indent := strings.Repeat("    ", depth)
+	b.WriteString(indent)
+
+	if e.Path != "" {
+		fmt.Fprintf(b, "[%s] ", e.Path)
+	}
+
+	field := e.Field
+	if field == "" {
+		field = "(root)"
+	}
+
+	switch e.Type {
+	case ExplainAny, ExplainAll, ExplainNone:
+		fmt.Fprintf(b, "%s %s", strings.ToUpper(string(e.Type)), field)
+	case ExplainExpr:
+		if e.Ref != "" {
+			fmt.Fprintf(b, "%s %s ref(%s)", field, e.Operator, e.Ref)
+		} else {
+			fmt.Fprintf(b, "%s %s %s", field, e.Operator, e.Value)
+		}
+	default:
+		b.WriteString(strings.ToUpper(string(e.Type)))
+	}
+	fmt.Fprintf(b, " => %v", e.Result)
+
+	if e.Error != "" {
+		fmt.Fprintf(b, ", error: %s", e.Error)
+	}
+	if e.Swallowed != "" {
+		fmt.Fprintf(b, ", ignored error: %s", e.Swallowed)
+	}
+	if e.Skipped > 0 {
+		fmt.Fprintf(b, ", short-circuited, %d skipped", e.Skipped)
+	}
+	b.WriteByte('\n')
+
+	for _, r := range e.Resolved {
+		fmt.Fprintf(b, "%s  - %s", indent, r.Path)
+		if r.Value != nil {
+			fmt.Fprintf(b, " = %s", r.Value)
+		}
+		if r.Target != nil {
+			fmt.Fprintf(b, ", target %s", r.Target)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(b, ", error: %s", r.Error)
+		} else {
+			fmt.Fprintf(b, " => %v", r.Result)
+		}
+		b.WriteByte('\n')
+	}
+
+	for _, c := range e.Children {
+		c.writeTo(b, depth+1)
+	}
+}
+
+// ----------------
+// MARK: trace
+
+// matchChild 匹配子节点, 在 explain 模式下同时生成子节点的解释
+func (ctx *matchContext) matchChild(m matcher, v *jsonvalue.V) (bool, error) {
+	parent := ctx.trace
+	if parent == nil {
+		return m.match(ctx, v)
+	}
+
+	node := m.describe()
+	parent.Children = append(parent.Children, node)
+
+	ctx.trace = node
+	b, err := m.match(ctx, v)
+	ctx.trace = parent
+
+	node.Result = b
+	if err != nil {
+		node.Error = err.Error()
+	}
+	return b, err
+}
+
+// matchElement 以数组元素为根匹配子条件, 在 explain 模式下记录数组元素的路径
+func (ctx *matchContext) matchElement(m matcher, v *jsonvalue.V, field string, i int) (bool, error) {
+	parent := ctx.trace
+	if parent == nil {
+		return m.match(ctx, v)
+	}
+
+	if field != "" {
+		ctx.push(field)
+	}
+	ctx.pushIndex(i)
+	b, err := ctx.matchChild(m, v)
+	parent.Children[len(parent.Children)-1].Path = strings.Join(ctx.path, ".")
+	ctx.pop()
+	if field != "" {
+		ctx.pop()
+	}
+	return b, err
+}
+
+func (ctx *matchContext) skip(n int) {
+	if ctx.trace != nil {
+		ctx.trace.Skipped = n
+	}
+}
+
+func (ctx *matchContext) swallow(err error) {
+	if ctx.trace != nil {
+		ctx.trace.Swallowed = err.Error()
+	}
+}
+
+func (ctx *matchContext) push(seg string) {
+	if ctx.trace != nil {
+		ctx.path = append(ctx.path, seg)
+	}
+}
+
+func (ctx *matchContext) pushKey(k string) {
+	if ctx.trace != nil {
+		ctx.path = append(ctx.path, formatKey(k))
+	}
+}
+
+func (ctx *matchContext) pushIndex(i int) {
+	if ctx.trace != nil {
+		ctx.path = append(ctx.path, "["+strconv.Itoa(i)+"]")
+	}
+}
+
+func (ctx *matchContext) pop() {
+	if ctx.trace != nil {
+		ctx.path = ctx.path[:len(ctx.path)-1]
+	}
+}
+
+// resolve 记录 field 实际解析到的值, v 为 nil 表示没有解析到
+func (ctx *matchContext) resolve(v *jsonvalue.V, res bool, err error) {
+	if ctx.trace == nil {
+		return
+	}
+	r := Resolved{
+		Path:   strings.Join(ctx.path, "."),
+		Result: res,
+	}
+	if v != nil {
+		r.Value = v.MustMarshal()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	ctx.trace.Resolved = append(ctx.trace.Resolved, r)
+}
+
+// resolveTarget 记录引用解析得到的目标值
+func (ctx *matchContext) resolveTarget(ref *reference, target *jsonvalue.V) {
+	if ctx.trace == nil || ref == nil || len(ctx.trace.Resolved) == 0 {
+		return
+	}
+	ctx.trace.Resolved[len(ctx.trace.Resolved)-1].Target = target.MustMarshal()
+}
@@ -0,0 +1,223 @@
+package jsonengine
+
+import (
+	"strconv"
+	"strings"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - filter
+
+// filter 表示数组的过滤条件, 如 [?(@.qty>1)]。过滤条件以每一个数组元素为根进行匹配, 匹配出错的元素视为不满足
+type filter struct {
+	// raw 表示括号内的原始表达式, 用于还原路径
+	raw  string
+	cond Condition
+	// m 在编译时生成
+	m matcher
+}
+
+// compileFilters 编译路径中的所有过滤条件
+func compileFilters(chain []field, o *options) error {
+	for _, f := range chain {
+		if flt := f.Array.Filter; flt != nil {
+			m, err := compileCondition(&flt.cond, o)
+			if err != nil {
+				return err
+			}
+			flt.m = m
+		}
+	}
+	return nil
+}
+
+// rangeFunc 返回仅遍历满足过滤条件的元素的方法, 元素的下标保持不变
+func (flt *filter) rangeFunc(ctx *matchContext, rangeArray rangeFunc) rangeFunc {
+	return func(callback func(int, *jsonvalue.V) bool) {
+		trace, elem := ctx.trace, ctx.elem
+		rangeArray(func(i int, v *jsonvalue.V) bool {
+			// 过滤条件不记录在 explain 中
+			ctx.trace, ctx.elem = nil, v
+			b, err := flt.m.match(ctx, v)
+			ctx.trace, ctx.elem = trace, elem
+			if err != nil || !b {
+				return true
+			}
+			return callback(i, v)
+		})
+	}
+}
+
+// ----------------
+// MARK: parse
+
+// filterOperators 表示过滤表达式中的比较操作符, 较长的需要排在前面
+var filterOperators = []struct {
+	token string
+	op    string
+}{
+	{"==", "="}, {"!=", "!="}, {"<=", "<="}, {">=", ">="}, {"=~", "regex"}, {"<", "<"}, {">", ">"},
+}
+
+// filter 解析 [?(expr)] 形式的路径段, sc.pos 指向 ?
+func (sc *pathScanner) filter() (field, error) {
+	sc.pos++
+	sc.skipSpaces()
+	if !sc.consume("(") {
+		return field{}, sc.errorf(sc.pos, "expecting '(' after '?'")
+	}
+
+	begin := sc.pos
+	cond, err := sc.filterOr()
+	if err != nil {
+		return field{}, err
+	}
+	sc.skipSpaces()
+	end := sc.pos
+	if !sc.consume(")") {
+		return field{}, sc.errorf(sc.pos, "expecting ')' to close filter")
+	}
+	sc.skipSpaces()
+	if !sc.consume("]") {
+		return field{}, sc.errorf(sc.pos, "expecting ']' after filter")
+	}
+
+	flt := &filter{raw: strings.TrimSpace(sc.src[begin:end]), cond: cond}
+	return field{Array: arrayField{Quant: quantifier{kind: quantAny}, Filter: flt}}, nil
+}
+
+func (sc *pathScanner) filterOr() (Condition, error) {
+	var or OR
+	for {
+		c, err := sc.filterAnd()
+		if err != nil {
+			return Condition{}, err
+		}
+		or = append(or, c)
+		sc.skipSpaces()
+		if !sc.consume("||") {
+			break
+		}
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return Condition{OR: or}, nil
+}
+
+func (sc *pathScanner) filterAnd() (Condition, error) {
+	var and AND
+	for {
+		c, err := sc.filterUnary()
+		if err != nil {
+			return Condition{}, err
+		}
+		and = append(and, c)
+		sc.skipSpaces()
+		if !sc.consume("&&") {
+			break
+		}
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return Condition{AND: and}, nil
+}
+
+func (sc *pathScanner) filterUnary() (Condition, error) {
+	sc.skipSpaces()
+	switch {
+	case sc.consume("!"):
+		c, err := sc.filterUnary()
+		if err != nil {
+			return Condition{}, err
+		}
+		return Condition{NOT: &NOT{Condition: c}}, nil
+
+	case sc.consume("("):
+		c, err := sc.filterOr()
+		if err != nil {
+			return Condition{}, err
+		}
+		sc.skipSpaces()
+		if !sc.consume(")") {
+			return Condition{}, sc.errorf(sc.pos, "expecting ')'")
+		}
+		return c, nil
+
+	default:
+		return sc.filterCompare()
+	}
+}
+
+// filterCompare 解析 @.path op literal, 或者是仅有 @.path 表示字段存在
+func (sc *pathScanner) filterCompare() (Condition, error) {
+	if !sc.consume("@") {
+		if sc.eof() {
+			return Condition{}, sc.errorf(sc.pos, "unexpected end of filter, expecting '@'")
+		}
+		return Condition{}, sc.errorf(sc.pos, "unexpected '%c' in filter, expecting '@'", sc.peek())
+	}
+	p, err := sc.jsonPathSegments()
+	if err != nil {
+		return Condition{}, err
+	}
+
+	sc.skipSpaces()
+	for _, o := range filterOperators {
+		if !sc.consume(o.token) {
+			continue
+		}
+		v, err := sc.filterLiteral()
+		if err != nil {
+			return Condition{}, err
+		}
+		e := Expr{Field: p.String(), Syntax: SyntaxDot, Operator: o.op, Value: v}
+		if v == nil {
+			// 与 null 比较时使用 isnull
+			switch o.op {
+			case "=":
+				e.Operator = "isnull"
+			case "!=":
+				e.Operator = "!isnull"
+			default:
+				return Condition{}, sc.errorf(sc.pos, "null can only be compared with == or !=")
+			}
+		}
+		return Condition{Expr: e}, nil
+	}
+
+	return Condition{Expr: Expr{Field: p.String(), Syntax: SyntaxDot, Operator: "exists"}}, nil
+}
+
+// filterLiteral 解析过滤表达式中的字面量: 数字、字符串、true、false 或 null
+func (sc *pathScanner) filterLiteral() (any, error) {
+	sc.skipSpaces()
+	if sc.eof() {
+		return nil, sc.errorf(sc.pos, "unexpected end of filter, expecting a value")
+	}
+
+	if c := sc.peek(); c == '\'' || c == '"' {
+		return sc.quotedString()
+	}
+	for _, kw := range []struct {
+		s string
+		v any
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if sc.consume(kw.s) {
+			return kw.v, nil
+		}
+	}
+
+	start := sc.pos
+	for !sc.eof() && strings.IndexByte("+-.0123456789eE", sc.peek()) >= 0 {
+		sc.pos++
+	}
+	f, err := strconv.ParseFloat(sc.src[start:sc.pos], 64)
+	if err != nil {
+		return nil, sc.errorf(start, "invalid value in filter, expecting a number, string, true, false or null")
+	}
+	return f, nil
+}
@@ -0,0 +1,263 @@
+package jsonengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ----------------
+// MARK: Condition.String
+
+// 文本形式中各种节点的优先级, 数值越大优先级越高
+const (
+	precOR = iota + 1
+	precAND
+	precNOT
+	precLeaf
+)
+
+// String 将 Condition 输出为 Parse 所支持的文本形式, 只在必要时添加括号。
+//
+// 嵌套的同类节点会保留括号, 如 a = 1 AND (b = 2 AND c = 3), 以保证再次解析之后得到完全相同的结构。只有一个
+// 子条件的 OR 或 AND 会直接输出其子条件
+func (c Condition) String() string {
+	b := strings.Builder{}
+	writeCondition(&b, &c)
+	return b.String()
+}
+
+// precedence 返回节点在文本形式中的优先级
+func (c *Condition) precedence() int {
+	switch {
+	case len(c.OR) == 1:
+		return c.OR[0].precedence()
+	case len(c.OR) > 0:
+		return precOR
+	case len(c.AND) == 1:
+		return c.AND[0].precedence()
+	case len(c.AND) > 0:
+		return precAND
+	case c.NOT != nil:
+		return precNOT
+	default:
+		return precLeaf
+	}
+}
+
+func writeCondition(b *strings.Builder, c *Condition) {
+	writeList := func(list []Condition, sep string, prec int) {
+		for i := range list {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			writeChild(b, &list[i], list[i].precedence() <= prec)
+		}
+	}
+
+	switch {
+	case len(c.OR) > 0:
+		writeList(c.OR, " OR ", precOR)
+
+	case len(c.AND) > 0:
+		writeList(c.AND, " AND ", precAND)
+
+	case c.NOT != nil:
+		b.WriteString("NOT ")
+		writeChild(b, &c.NOT.Condition, c.NOT.precedence() < precNOT)
+
+	case c.Any != nil:
+		writeElem(b, c.Field, "ANY", c.Any)
+	case c.All != nil:
+		writeElem(b, c.Field, "ALL", c.All)
+	case c.None != nil:
+		writeElem(b, c.Field, "NONE", c.None)
+
+	default:
+		writeExpr(b, &c.Expr)
+	}
+}
+
+func writeChild(b *strings.Builder, c *Condition, paren bool) {
+	if paren {
+		b.WriteByte('(')
+	}
+	writeCondition(b, c)
+	if paren {
+		b.WriteByte(')')
+	}
+}
+
+func writeElem(b *strings.Builder, field, q string, sub *Condition) {
+	b.WriteString(textField(field))
+	b.WriteString(" " + q + " (")
+	writeCondition(b, sub)
+	b.WriteByte(')')
+}
+
+func writeExpr(b *strings.Builder, e *Expr) {
+	if e.Operator == "" && e.Field == "" {
+		// 空的 Condition
+		return
+	}
+	b.WriteString(textField(e.Field))
+	b.WriteByte(' ')
+	b.WriteString(e.Operator)
+
+	switch {
+	case e.Ref != "":
+		// 文本形式中的引用必须以 $ 或 @ 开头
+		if ref := strings.TrimSpace(e.Ref); strings.HasPrefix(ref, "$") || strings.HasPrefix(ref, "@") {
+			b.WriteString(" " + ref)
+		} else {
+			b.WriteString(" $" + ref)
+		}
+	case e.Value != nil || !omitValue(e.Operator):
+		b.WriteByte(' ')
+		if v, err := marshalValue(e.Value); err == nil {
+			b.Write(v)
+		} else {
+			// 无法使用 JSON 表示的值, 只能原样输出
+			b.WriteString(fmt.Sprint(e.Value))
+		}
+	}
+}
+
+// textField 返回 field 在文本形式中的写法, 空字符串表示当前值, 写作 @
+func textField(f string) string {
+	if f == "" {
+		return "@"
+	}
+	p := &textParser{src: f}
+	if p.scanPath() == f {
+		return f
+	}
+
+	// 包含空白或者操作符等字符的 key 需要使用引号括起来
+	path, err := ParsePath(f)
+	if err != nil {
+		return f
+	}
+	b := strings.Builder{}
+	for i, seg := range path {
+		if i > 0 || seg.Recursive {
+			b.WriteByte('.')
+		}
+		if seg.Recursive {
+			b.WriteByte('.')
+		}
+		if k := seg.Object; k != "" && !seg.Index && strings.IndexFunc(k, isTextSpecial) >= 0 {
+			b.WriteString(quoteKey(k))
+		} else {
+			b.WriteString(seg.String())
+		}
+	}
+	return b.String()
+}
+
+func isTextSpecial(c rune) bool {
+	return unicode.IsSpace(c) || strings.ContainsRune(textOperatorChars+"(),", c)
+}
+
+// omitValue 表示该操作符在值为 null 时可以省略值, 即 exists、isnull 等
+func omitValue(op string) bool {
+	o, err := parseOperator(op)
+	return err == nil && o.kind.isPresence()
+}
+
+// marshalValue 与 json.Marshal 相同, 但是不转义 HTML 字符
+func marshalValue(v any) ([]byte, error) {
+	buff := bytes.Buffer{}
+	enc := json.NewEncoder(&buff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buff.Bytes(), "\n"), nil
+}
+
+// ----------------
+// MARK: Condition.MarshalJSON
+
+// MarshalJSON 输出规范的 JSON 形式: 叶子条件输出为 SQL 风格的数组, 如 ["a","=",1], 其他节点输出为 object
+// 并且省略空的部分。输出的结果可以通过 UnmarshalJSON 还原为相同的 Condition
+func (c Condition) MarshalJSON() ([]byte, error) {
+	if c.isLeaf() {
+		if arr, ok := c.sqlStyle(); ok {
+			return marshalValue(arr)
+		}
+	}
+
+	b := bytes.Buffer{}
+	b.WriteByte('{')
+	add := func(key string, v any) error {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`"` + key + `":`)
+		j, err := marshalValue(v)
+		if err != nil {
+			return err
+		}
+		b.Write(j)
+		return nil
+	}
+
+	for _, kv := range []struct {
+		key  string
+		v    any
+		omit bool
+	}{
+		{"field", c.Field, c.Field == ""},
+		{"syntax", c.Syntax, c.Syntax == ""},
+		{"op", c.Operator, c.Operator == ""},
+		{"value", c.Value, c.Value == nil && (c.Operator == "" || c.Ref != "" || omitValue(c.Operator))},
+		{"ref", c.Ref, c.Ref == ""},
+		{"or", c.OR, len(c.OR) == 0},
+		{"and", c.AND, len(c.AND) == 0},
+		{"not", c.NOT, c.NOT == nil},
+		{"any", c.Any, c.Any == nil},
+		{"all", c.All, c.All == nil},
+		{"none", c.None, c.None == nil},
+	} {
+		if kv.omit {
+			continue
+		}
+		if err := add(kv.key, kv.v); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// isLeaf 表示 Condition 只包含一个 Expr
+func (c *Condition) isLeaf() bool {
+	return c.Operator != "" && len(c.OR) == 0 && len(c.AND) == 0 && c.NOT == nil &&
+		c.Any == nil && c.All == nil && c.None == nil
+}
+
+// sqlStyle 返回叶子条件的 SQL 风格数组, 无法使用 SQL 风格表示时返回 false
+func (c *Condition) sqlStyle() ([]any, bool) {
+	if c.Syntax != "" {
+		return nil, false
+	}
+
+	if _, ok := refObject(c.Value); ok {
+		// 字面量的 {"ref":"xxx"} 在 SQL 风格中会被当作引用, 只能使用 object 形式
+		return nil, false
+	}
+
+	switch {
+	case c.Ref != "":
+		return []any{c.Field, c.Operator, map[string]any{"ref": c.Ref}}, true
+
+	case c.Value == nil && omitValue(c.Operator):
+		return []any{c.Field, c.Operator}, true
+
+	default:
+		return []any{c.Field, c.Operator, c.Value}, true
+	}
+}
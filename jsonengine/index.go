@@ -0,0 +1,301 @@
+package jsonengine
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - RuleIndex
+
+// RuleIndex 表示为大量规则建立了索引的规则集, 用于在一个文档上找出所有命中的规则。与 Program 相同, RuleIndex
+// 是只读的, 可以在多个 goroutine 中并发使用。
+//
+// 编译时会为每一条规则找出一组必要条件: 只有其中至少一个成立时规则才可能命中。可以作为必要条件的是 field 只
+// 指向唯一一个值的 =、in 以及 startswith (包括 istartswith) 叶子条件, 在 and 中选择其中一个子条件, 在 or
+// 中需要所有子条件都有必要条件。匹配时每一个被索引的 field 只解析一次, 然后通过哈希表找出候选规则, 只有候选
+// 规则以及没有必要条件的规则才会被完整匹配。因此当大部分规则都带有这一类条件时, 匹配的耗时与规则总数无关,
+// 而只与候选规则的数量有关
+type RuleIndex struct {
+	rs RuleSet
+	// paths 为所有被索引的 field
+	paths []*indexPath
+	// always 为没有必要条件的规则, 每次都需要完整匹配
+	always []int
+}
+
+// indexPath 表示同一个 field 上的所有索引, 其中的 int 均为规则在 rs.sorted 中的下标
+type indexPath struct {
+	chain []field
+	// values 包括 = 以及 in 的目标值, key 由 indexKey 生成
+	values map[any][]int
+	// prefixes 和 iprefixes 分别为 startswith 和 istartswith 的目标值, 后者为小写
+	prefixes  prefixIndex
+	iprefixes prefixIndex
+}
+
+// prefixIndex 按照前缀索引规则, lens 为所有前缀的不同长度, 从小到大排列
+type prefixIndex struct {
+	rules map[string][]int
+	lens  []int
+}
+
+// NewRuleIndex 编译一组规则并建立索引, 规则的要求与 NewRuleSet 相同。被禁用的规则不会出现在匹配结果中
+func NewRuleIndex(rules []Rule, opts ...Option) (*RuleIndex, error) {
+	ri := &RuleIndex{}
+	if err := ri.rs.compile(rules, opts); err != nil {
+		return nil, err
+	}
+
+	paths := map[string]*indexPath{}
+	for i, r := range ri.rs.sorted {
+		leaves := indexGuard(r.prog.root)
+		if leaves == nil {
+			ri.always = append(ri.always, i)
+			continue
+		}
+		for _, e := range leaves {
+			k := chainKey(e.chain)
+			p := paths[k]
+			if p == nil {
+				p = &indexPath{chain: e.chain, values: map[any][]int{}}
+				paths[k] = p
+				ri.paths = append(ri.paths, p)
+			}
+			p.add(e, i)
+		}
+	}
+	return ri, nil
+}
+
+// Rules 返回所有规则, 包括被禁用的规则, 按照原始顺序排列
+func (ri *RuleIndex) Rules() []Rule {
+	return ri.rs.Rules()
+}
+
+// Match 返回所有命中的规则的 ID, 按照优先级排列, 没有规则命中时返回 nil。与 RuleSet.Evaluate 相同, 单条规则
+// 匹配出错时视为未命中, 只有 value 无法解析时才会返回错误
+func (ri *RuleIndex) Match(value any) ([]string, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, i := range ri.candidates(v) {
+		r := ri.rs.sorted[i]
+		if b, err := r.prog.Match(v); err == nil && b {
+			ids = append(ids, r.rule.ID)
+		}
+	}
+	return ids, nil
+}
+
+// candidates 返回需要完整匹配的规则, 已去重并按照优先级排列
+func (ri *RuleIndex) candidates(v *jsonvalue.V) []int {
+	res := append([]int(nil), ri.always...)
+	for _, p := range ri.paths {
+		res = p.lookup(v, res)
+	}
+	sort.Ints(res)
+
+	n := 0
+	for i, c := range res {
+		if i == 0 || c != res[n-1] {
+			res[n] = c
+			n++
+		}
+	}
+	return res[:n]
+}
+
+// ----------------
+// MARK: guard
+
+// indexGuard 返回 m 命中的必要条件, 即一组可以被索引的叶子条件, 只有其中至少一个成立时 m 才可能命中。返回
+// nil 表示 m 没有这样的必要条件
+func indexGuard(m matcher) []*exprMatcher {
+	switch m := m.(type) {
+	default:
+		return nil
+
+	case *exprMatcher:
+		if indexable(m) {
+			return []*exprMatcher{m}
+		}
+		return nil
+
+	case andMatcher:
+		// 任意一个子条件的必要条件都是 and 的必要条件, 选择候选规则最少的一个
+		var best []*exprMatcher
+		for _, sub := range m {
+			g := indexGuard(sub)
+			if g != nil && (best == nil || guardCost(g) < guardCost(best)) {
+				best = g
+			}
+		}
+		return best
+
+	case orMatcher:
+		var all []*exprMatcher
+		for _, sub := range m {
+			g := indexGuard(sub)
+			if g == nil {
+				return nil
+			}
+			all = append(all, g...)
+		}
+		return all
+	}
+}
+
+// guardCost 估算必要条件成立的可能性, 前缀条件比精确值更容易成立
+func guardCost(g []*exprMatcher) int {
+	cost := 0
+	for _, e := range g {
+		if e.op.kind == opPrefix {
+			cost += 2
+		} else {
+			cost++
+		}
+	}
+	return cost
+}
+
+// indexable 表示叶子条件是否可以被索引
+func indexable(e *exprMatcher) bool {
+	if e.ref != nil || !isSingle(e.chain) {
+		return false
+	}
+	for _, f := range e.chain {
+		if f.Array.Slice != nil || f.Array.Filter != nil {
+			return false
+		}
+	}
+
+	switch target := e.target.v; e.op.kind {
+	default:
+		return false
+	case opEqual:
+		_, ok := indexKey(target)
+		return ok
+	case opIn:
+		if e.op.not {
+			return false
+		}
+		ok := true
+		target.RangeArray(func(_ int, v *jsonvalue.V) bool {
+			_, ok = indexKey(v)
+			return ok
+		})
+		return ok
+	case opPrefix:
+		return !e.op.not
+	}
+}
+
+// indexKey 返回标量值在哈希表中的 key, 两个值 Equal 时 key 一定相同。数字使用 float64 表示, 因此 key 相同的
+// 数字不一定 Equal, 但这只会多出候选规则, 不影响结果
+func indexKey(v *jsonvalue.V) (any, bool) {
+	switch v.ValueType() {
+	default:
+		return nil, false
+	case jsonvalue.String:
+		return v.String(), true
+	case jsonvalue.Number:
+		return v.Float64(), true
+	case jsonvalue.Boolean:
+		return v.Bool(), true
+	case jsonvalue.Null:
+		return jsonvalue.Null, true
+	}
+}
+
+// chainKey 返回只指向唯一一个值的 field 的 key, 解析方式相同的 field 具有相同的 key
+func chainKey(chain []field) string {
+	b := strings.Builder{}
+	for _, f := range chain {
+		switch {
+		case f.Length:
+			b.WriteString("#")
+		case f.Object != "":
+			if f.Index {
+				// JSON Pointer 中的数字, 遇到数组时按照下标处理
+				b.WriteString("~")
+			}
+			b.WriteString(strconv.Quote(f.Object))
+		default:
+			b.WriteString("[" + strconv.Itoa(f.Array.At) + "]")
+		}
+	}
+	return b.String()
+}
+
+// ----------------
+// MARK: indexPath
+
+func (p *indexPath) add(e *exprMatcher, rule int) {
+	switch target := e.target.v; e.op.kind {
+	case opEqual:
+		k, _ := indexKey(target)
+		p.values[k] = append(p.values[k], rule)
+	case opIn:
+		target.RangeArray(func(_ int, v *jsonvalue.V) bool {
+			k, _ := indexKey(v)
+			p.values[k] = append(p.values[k], rule)
+			return true
+		})
+	case opPrefix:
+		if e.op.caseless {
+			p.iprefixes.add(e.target.lower, rule)
+		} else {
+			p.prefixes.add(target.String(), rule)
+		}
+	}
+}
+
+// lookup 解析 field, 并将可能命中的规则追加到 res 中。field 不存在时没有规则可以满足其中的条件
+func (p *indexPath) lookup(root *jsonvalue.V, res []int) []int {
+	v, err := resolveChain(root, p.chain)
+	if err != nil {
+		return res
+	}
+	if k, ok := indexKey(v); ok {
+		res = append(res, p.values[k]...)
+	}
+	if v.IsString() {
+		s := v.String()
+		res = p.prefixes.lookup(s, res)
+		if p.iprefixes.rules != nil {
+			res = p.iprefixes.lookup(strings.ToLower(s), res)
+		}
+	}
+	return res
+}
+
+func (idx *prefixIndex) add(prefix string, rule int) {
+	if idx.rules == nil {
+		idx.rules = map[string][]int{}
+	}
+	if _, exist := idx.rules[prefix]; !exist {
+		i := sort.SearchInts(idx.lens, len(prefix))
+		if i == len(idx.lens) || idx.lens[i] != len(prefix) {
+			idx.lens = append(idx.lens, 0)
+			copy(idx.lens[i+1:], idx.lens[i:])
+			idx.lens[i] = len(prefix)
+		}
+	}
+	idx.rules[prefix] = append(idx.rules[prefix], rule)
+}
+
+func (idx *prefixIndex) lookup(s string, res []int) []int {
+	for _, n := range idx.lens {
+		if n > len(s) {
+			break
+		}
+		res = append(res, idx.rules[s[:n]]...)
+	}
+	return res
+}
@@ -2,12 +2,20 @@ package jsonengine
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
 	"github.com/smartystreets/goconvey/convey"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -31,6 +39,1803 @@ func TestJSONEngine(t *testing.T) {
 	cv("Match with NOT", t, func() { testJSONEngineMatchNOT(t) })
 	cv("Match with multiple embedded conditions", t, func() { testJSONEngineMatchWithMultipleEmbedding(t) })
 	cv("SQL-style expr", t, func() { testSQLStyleExpr(t) })
+	cv("String operators", t, func() { testStringOperators(t) })
+	cv("Presence operators", t, func() { testPresenceOperators(t) })
+	cv("Set operators", t, func() { testSetOperators(t) })
+	cv("Length", t, func() { testLength(t) })
+	cv("Between", t, func() { testBetween(t) })
+	cv("Field references", t, func() { testReference(t) })
+	cv("Element conditions", t, func() { testElementCondition(t) })
+	cv("Array quantifiers", t, func() { testArrayQuantifiers(t) })
+	cv("Array slices", t, func() { testArraySlices(t) })
+	cv("Object wildcards and recursive descent", t, func() { testObjectWildcards(t) })
+	cv("Quoted and escaped keys", t, func() { testQuotedKeys(t) })
+	cv("JSON Pointer and JSONPath", t, func() { testFieldSyntax(t) })
+}
+
+func TestCompile(t *testing.T) {
+	cv("Compile illegal conditions", t, func() { testCompileIllegal(t) })
+	cv("Program concurrent matching", t, func() { testProgramConcurrent(t) })
+}
+
+func TestPath(t *testing.T) {
+	cv("round trip", t, func() { testPathRoundTrip(t) })
+	cv("illegal paths", t, func() { testPathIllegal(t) })
+	cv("syntax conversion", t, func() { testPathConvert(t) })
+}
+
+func testPathRoundTrip(t *testing.T) {
+	cases := []struct {
+		path   string
+		format string
+	}{
+		{path: "a.b.c", format: "a.b.c"},
+		{path: " a . b ", format: "a.b"},
+		{path: "a.[0].[-1]", format: "a.[0].[-1]"},
+		{path: "a.[ + ].[*].[!].[none].[one].[>=2]", format: "a.[+].[*].[!].[!].[=1].[>=2]"},
+		{path: "a.[+0:3].[*-5:].[!::2].[*8:2:-3]", format: "a.[+0:3].[*-5:].[!::2].[*8:2:-3]"},
+		{path: "a.{*}.{+}.b.#", format: "a.{*}.{+}.b.#"},
+		{path: "..price", format: "..price"},
+		{path: "order..price", format: "order..price"},
+		{path: `headers.'x-request.id'`, format: `headers."x-request.id"`},
+		{path: `headers.["x-request.id"]`, format: `headers."x-request.id"`},
+		{path: `headers.[ 'a.b' ]`, format: `headers."a.b"`},
+		{path: `a\.b.c`, format: `"a.b".c`},
+		{path: `" key "`, format: `" key "`},
+		{path: `a\ `, format: `"a "`},
+		{path: `'say "hi"'`, format: `"say \"hi\""`},
+		{path: `'back\\slash'`, format: `"back\\slash"`},
+		{path: `\#`, format: `"#"`},
+		{path: `'#'.#`, format: `"#".#`},
+		{path: `#tag`, format: `#tag`},
+		{path: `..'a.b'`, format: `.."a.b"`},
+		{path: `中文.键`, format: `中文.键`},
+		// 引号和括号仅在段的开头具有特殊含义
+		{path: `it's.a[b`, format: `"it's"."a[b"`},
+		{path: `a[0].b]`, format: `"a[0]"."b]"`},
+		{path: `say "hi".y{x}`, format: `"say \"hi\""."y{x}"`},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.path)
+		p, err := ParsePath(c.path)
+		so(err, isNil)
+		so(p.String(), eq, c.format)
+
+		again, err := ParsePath(p.String())
+		so(err, isNil)
+		so(again.String(), eq, c.format)
+		so(len(again), eq, len(p))
+	}
+
+	p, err := ParsePath("  ")
+	so(err, isNil)
+	so(p, isNil)
+	so(p.String(), eq, "")
+
+	// 之前版本中合法的 field
+	value := `{"it's":{"a[b":1},"a[0]":2,"b]":3}`
+	iterateTestCases(t, "keys with quotes and brackets", []testCase{
+		{value: value, cond: `["it's.a[b","=",1]`, expect: true},
+		{value: value, cond: `["a[0]","=",2]`, expect: true},
+		{value: value, cond: `["b]","=",3]`, expect: true},
+	})
+}
+
+func testPathIllegal(t *testing.T) {
+	cases := []struct {
+		path string
+		msg  string
+	}{
+		{path: "a.[0", msg: "unclosed '[' at position 2"},
+		{path: "a.[x]", msg: "invalid array segment '[x]' at position 2"},
+		{path: "a.{x}", msg: "invalid object segment '{x}' at position 2"},
+		{path: "a.'b", msg: "unterminated quoted string at position 2"},
+		{path: "a.'b'c", msg: "unexpected 'c', expecting '.' at position 5"},
+		{path: "a.''", msg: "empty key at position 2"},
+		{path: `a.["b"`, msg: "expecting ']' after quoted key at position 6"},
+		{path: `a\`, msg: "unfinished escape at position 1"},
+		{path: "a.", msg: "unexpected end of path, expecting a segment at position 2"},
+		{path: ".a", msg: "empty segment at position 0"},
+		{path: "a. .b", msg: "empty segment at position 3"},
+		{path: "a...b", msg: "empty segment at position 3"},
+		{path: "a..[0]", msg: "'..' should be followed by a key at position 3"},
+		{path: "a.#.b", msg: "'#' should be the last segment at position 2"},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.path)
+		_, err := ParsePath(c.path)
+		so(errors.Is(err, ErrIllegalField), eq, true)
+		so(err.Error(), convey.ShouldEndWith, c.msg)
+	}
+}
+
+func testPathConvert(t *testing.T) {
+	cases := []struct {
+		from     string
+		dot      string
+		pointer  string
+		jsonPath string
+	}{
+		{from: "/items/0/sku", dot: "items.[0].sku", pointer: "/items/0/sku", jsonPath: "$.items[0].sku"},
+		{from: "/a~1b/c~0d/01", dot: `a/b.c~d.01`, pointer: "/a~1b/c~0d/01", jsonPath: "$['a/b']['c~d']['01']"},
+		{from: "$.items[*].sku", dot: "items.[+].sku", jsonPath: "$.items[*].sku"},
+		{from: "$..price", dot: "..price", jsonPath: "$..price"},
+		{from: "$['x-request.id']", dot: `"x-request.id"`, pointer: "/x-request.id", jsonPath: "$['x-request.id']"},
+		{from: "$.prices.*.amount", dot: "prices.{+}.amount", jsonPath: "$.prices.*.amount"},
+		{from: "$.events[-3:]", dot: "events.[+-3:]", jsonPath: "$.events[-3:]"},
+		{from: "$.items[-1]", dot: "items.[-1]", jsonPath: "$.items[-1]"},
+		{from: "$.items[?(@.qty > 1)].sku", dot: "items.[?(@.qty > 1)].sku", jsonPath: "$.items[?(@.qty > 1)].sku"},
+		{from: "items.[?(@.qty>1)].sku", dot: "items.[?(@.qty>1)].sku", jsonPath: "$.items[?(@.qty>1)].sku"},
+		{from: "a.b", dot: "a.b", pointer: "/a/b", jsonPath: "$.a.b"},
+		{from: "$", dot: "", pointer: "", jsonPath: "$"},
+		{from: "..'a b'", dot: `..a b`, jsonPath: "$..['a b']"},
+		{from: "'$ref'", dot: `"$ref"`, pointer: "/$ref", jsonPath: "$['$ref']"},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.from)
+		dot, err := ConvertPath(c.from, SyntaxAuto, SyntaxDot)
+		so(err, isNil)
+		so(dot, eq, c.dot)
+
+		ptr, err := ConvertPath(c.from, SyntaxAuto, SyntaxPointer)
+		if c.pointer == "" && c.dot != "" {
+			so(errors.Is(err, ErrIllegalField), eq, true)
+		} else {
+			so(err, isNil)
+			so(ptr, eq, c.pointer)
+		}
+
+		jp, err := ConvertPath(c.from, SyntaxAuto, SyntaxJSONPath)
+		so(err, isNil)
+		so(jp, eq, c.jsonPath)
+
+		// 转换之后再次解析, 得到相同的路径
+		again, err := ConvertPath(jp, SyntaxJSONPath, SyntaxDot)
+		so(err, isNil)
+		so(again, eq, c.dot)
+	}
+
+	for _, f := range []string{"items.[*]", "items.[!]", "items.[=2]", "items.#", "items.{*}"} {
+		_, err := ConvertPath(f, SyntaxDot, SyntaxJSONPath)
+		so(errors.Is(err, ErrIllegalField), eq, true)
+	}
+
+	illegal := []struct {
+		path   string
+		syntax FieldSyntax
+	}{
+		{path: "/a/~2", syntax: SyntaxPointer},
+		{path: "/a//b", syntax: SyntaxPointer},
+		{path: "a/b", syntax: SyntaxPointer},
+		{path: "items[0]", syntax: SyntaxJSONPath},
+		{path: "$.items[0,1]", syntax: SyntaxJSONPath},
+		{path: "$.items['a','b']", syntax: SyntaxJSONPath},
+		{path: "$..*", syntax: SyntaxJSONPath},
+		{path: "$.items[?(@.qty>)]", syntax: SyntaxJSONPath},
+		{path: "$.items[?(@.qty>1]", syntax: SyntaxJSONPath},
+		{path: "$.items[?(qty>1)]", syntax: SyntaxJSONPath},
+		{path: "$.items[?(@.qty<null)]", syntax: SyntaxJSONPath},
+		{path: "$.a b", syntax: SyntaxJSONPath},
+		{path: "a", syntax: "xpath"},
+	}
+	for i, c := range illegal {
+		t.Log("No", i+1, c.path)
+		_, err := ParsePathSyntax(c.path, c.syntax)
+		so(errors.Is(err, ErrIllegalField), eq, true)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cv("same as JSON", t, func() { testParseSameAsJSON(t) })
+	cv("match", t, func() { testParseMatch(t) })
+	cv("syntax errors", t, func() { testParseErrors(t) })
+}
+
+func testParseSameAsJSON(t *testing.T) {
+	cases := []struct {
+		text string
+		json string
+	}{
+		{
+			text: `a = 1`,
+			json: `{"field":"a","op":"=","value":1}`,
+		}, {
+			text: `user.age >= 18 AND (country IN ["CN","US"] OR NOT vip = true) AND items[+].price > 100`,
+			json: `{"and":[
+				{"field":"user.age","op":">=","value":18},
+				{"or":[{"field":"country","op":"IN","value":["CN","US"]},{"not":{"field":"vip","op":"=","value":true}}]},
+				{"field":"items.[+].price","op":">","value":100}
+			]}`,
+		}, {
+			text: `a = 1 or b = 2 and c = 3`,
+			json: `{"or":[{"field":"a","op":"=","value":1},{"and":[{"field":"b","op":"=","value":2},{"field":"c","op":"=","value":3}]}]}`,
+		}, {
+			text: `(a = 1 || b = 2) && !c = 3`,
+			json: `{"and":[{"or":[{"field":"a","op":"=","value":1},{"field":"b","op":"=","value":2}]},{"not":{"field":"c","op":"=","value":3}}]}`,
+		}, {
+			text: `name exists AND deleted_at is null AND tags not in ['a', "b"] AND note !contains 'x'`,
+			json: `{"and":[
+				{"field":"name","op":"exists"},
+				{"field":"deleted_at","op":"isnull"},
+				{"field":"tags","op":"not in","value":["a","b"]},
+				{"field":"note","op":"!contains","value":"x"}
+			]}`,
+		}, {
+			text: `items len >= 2 AND title len<=10 AND x is not null AND y ≠ -1.5e3`,
+			json: `{"and":[
+				{"field":"items","op":"len >=","value":2},
+				{"field":"title","op":"len<=","value":10},
+				{"field":"x","op":"isnotnull"},
+				{"field":"y","op":"≠","value":-1.5e3}
+			]}`,
+		}, {
+			text: `order.date < $order.deadline AND items ANY (sku = "a" AND qty > @.min) AND tags NONE (@ = null)`,
+			json: `{"and":[
+				{"field":"order.date","op":"<","ref":"$order.deadline"},
+				{"field":"items","any":{"and":[{"field":"sku","op":"=","value":"a"},{"field":"qty","op":">","ref":"@.min"}]}},
+				{"field":"tags","none":{"op":"=","value":null}}
+			]}`,
+		}, {
+			text: `'x-request.id' =~ "^a\\d+\u0041" AND prices{*}.amount between [1, 10] AND $.items[?(@.qty > 1)].sku = 'b'`,
+			json: `{"and":[
+				{"field":"'x-request.id'","op":"=~","value":"^a\\d+A"},
+				{"field":"prices.{*}.amount","op":"between","value":[1,10]},
+				{"field":"$.items[?(@.qty > 1)].sku","op":"=","value":"b"}
+			]}`,
+		}, {
+			text: "a.[0] in [[1, 2], [], true, false, null]\n\tOR /a~1b/0 = 'c'",
+			json: `{"or":[{"field":"a.[0]","op":"in","value":[[1,2],[],true,false,null]},{"field":"/a~1b/0","op":"=","value":"c"}]}`,
+		}, {
+			text: `order.id = 1 AND android = 2 OR nota = 3`,
+			json: `{"or":[{"and":[{"field":"order.id","op":"=","value":1},{"field":"android","op":"=","value":2}]},{"field":"nota","op":"=","value":3}]}`,
+		}, {
+			// UTF-16 代理对与 JSON 相同, 合并为一个字符; 单独的代理项替换为 U+FFFD
+			text: `a = "\ud83d\ude00" AND b = '\ud83dx' AND c = "\ude00\u0041" AND d = "\ud83d\u0041"`,
+			json: `{"and":[
+				{"field":"a","op":"=","value":"\ud83d\ude00"},
+				{"field":"b","op":"=","value":"\ud83dx"},
+				{"field":"c","op":"=","value":"\ude00\u0041"},
+				{"field":"d","op":"=","value":"\ud83d\u0041"}
+			]}`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.text)
+		got, err := Parse(c.text)
+		so(err, isNil)
+
+		expect := Condition{}
+		err = json.Unmarshal([]byte(c.json), &expect)
+		so(err, isNil)
+		so(got, convey.ShouldResemble, expect)
+	}
+
+	got, err := Parse(`a = "\ud83d\ude00"`)
+	so(err, isNil)
+	so(got.Value, eq, "😀")
+	again, err := Parse(got.String())
+	so(err, isNil)
+	so(again, convey.ShouldResemble, got)
+}
+
+func testParseMatch(t *testing.T) {
+	value := jsonvalue.MustUnmarshalString(`{
+		"user":{"age":20,"name":"Alice"},"country":"JP","vip":false,
+		"items":[{"sku":"a","price":50},{"sku":"b","price":150}]
+	}`)
+
+	cases := []struct {
+		text   string
+		expect bool
+	}{
+		{`user.age >= 18 AND (country IN ["CN","US"] OR NOT vip = true) AND items[+].price > 100`, true},
+		{`user.age >= 18 AND country IN ["CN","US"] AND items[+].price > 100`, false},
+		{`user.name istartswith 'al' and items ALL (price < 200)`, true},
+		{`items ANY (sku = 'a' AND price > 100)`, false},
+		{`items[*].price > 10 AND NOT (user.age < 18 OR country = 'CN')`, true},
+	}
+	for i, c := range cases {
+		t.Log("No", i+1, c.text)
+		cond, err := Parse(c.text)
+		so(err, isNil)
+		b, err := Match(value, cond)
+		so(err, isNil)
+		so(b, eq, c.expect)
+	}
+}
+
+func testParseErrors(t *testing.T) {
+	cases := []struct {
+		text   string
+		line   int
+		column int
+		err    error
+	}{
+		{text: ``, line: 1, column: 1},
+		{text: `a = 1 AND`, line: 1, column: 10},
+		{text: `a = 1 AND (b = 2`, line: 1, column: 17},
+		{text: `a => 1`, line: 1, column: 3, err: ErrIllegalOperator},
+		{text: `a = 1 b = 2`, line: 1, column: 7},
+		{text: "a = 1 AND\n  b.[x] = 2", line: 2, column: 3, err: ErrIllegalField},
+		{text: `a = [1, 2`, line: 1, column: 10},
+		{text: `a = 'abc`, line: 1, column: 5},
+		{text: `a = "\q"`, line: 1, column: 6},
+		{text: `a in [1 2]`, line: 1, column: 9},
+		{text: `a = abc`, line: 1, column: 5},
+		{text: `中文 = 1 AND ( ≠ 1`, line: 1, column: 14},
+		{text: `a = $b.[+]`, line: 1, column: 5, err: ErrIllegalField},
+		{text: `a = 1)`, line: 1, column: 6},
+		{text: `items ANY (sku = 'a'`, line: 1, column: 21},
+		// 只有不需要目标值的操作符可以省略目标值
+		{text: `age >=`, line: 1, column: 7},
+		{text: `x = AND y = 1`, line: 1, column: 5},
+		{text: `(a in) OR b exists`, line: 1, column: 6},
+		{text: `a contains && b = 1`, line: 1, column: 12},
+		{text: `a type || b = 1`, line: 1, column: 8},
+	}
+	for i, c := range cases {
+		t.Log("No", i+1, c.text)
+		_, err := Parse(c.text)
+		so(errors.Is(err, ErrSyntax), eq, true)
+		if c.err != nil {
+			so(errors.Is(err, c.err), eq, true)
+		}
+
+		var pe *ParseError
+		so(errors.As(err, &pe), eq, true)
+		t.Log(pe)
+		so(pe.Line, eq, c.line)
+		so(pe.Column, eq, c.column)
+	}
+
+	_, err := Parse(`age >= AND b = 1`)
+	so(err, isErr)
+	so(err.Error(), convey.ShouldContainSubstring, "expecting a value")
+	for _, text := range []string{`a exists`, `a is null AND b empty`, `(a !exists) OR b isnotnull || c not empty`} {
+		_, err := Parse(text)
+		so(err, isNil)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cv("String", t, func() { testConditionString(t) })
+	cv("MarshalJSON", t, func() { testConditionMarshalJSON(t) })
+}
+
+func testConditionString(t *testing.T) {
+	cases := []struct {
+		text   string
+		expect string
+	}{
+		{
+			text:   `user.age >= 18 AND (country IN ["CN","US"] OR NOT vip = true) AND items[+].price > 100`,
+			expect: `user.age >= 18 AND (country IN ["CN","US"] OR NOT vip = true) AND items.[+].price > 100`,
+		},
+		{text: `((a = 1)) or (b = 2 and c = 3)`, expect: `a = 1 or b = 2 AND c = 3`},
+		{text: `a = 1 and (b = 2 and c = 3)`, expect: `a = 1 AND (b = 2 AND c = 3)`},
+		{text: `(a = 1 or b = 2) or c = 3`, expect: `(a = 1 OR b = 2) OR c = 3`},
+		{text: `not (a = 1 and b = 2) and not not c = 3`, expect: `NOT (a = 1 AND b = 2) AND NOT NOT c = 3`},
+		{text: `name exists && deleted_at is null`, expect: `name exists AND deleted_at isnull`},
+		{text: `a = null`, expect: `a = null`},
+		{text: `a = 'x<y' AND b in [1.5, "$x", {"k": [true]}]`, expect: `a = "x<y" AND b in [1.5,"$x",{"k":[true]}]`},
+		{text: `items ANY (sku = 'a' AND qty > @.min) OR tags NONE (@ = 'x')`, expect: `items ANY (sku = "a" AND qty > @.min) OR tags NONE (@ = "x")`},
+		{text: `$.items[?(@.qty > 1)].sku = 'b' AND date < $order.deadline`, expect: `$.items[?(@.qty > 1)].sku = "b" AND date < $order.deadline`},
+		{text: `'a b'.c len >= 2`, expect: `'a b'.c len >= 2`},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.text)
+		cond, err := Parse(c.text)
+		so(err, isNil)
+		s := cond.String()
+		so(strings.ReplaceAll(s, " OR ", " or "), eq, strings.ReplaceAll(c.expect, " OR ", " or "))
+
+		again, err := Parse(s)
+		so(err, isNil)
+		so(again, convey.ShouldResemble, cond)
+		so(again.String(), eq, s)
+	}
+
+	// 不是由 Parse 生成的 Condition
+	cond := Condition{OR: OR{
+		{Expr: Expr{Field: "a b", Operator: "=", Value: 1}},
+		{AND: AND{{Expr: Expr{Field: "c", Operator: ">", Ref: "d"}}}},
+	}}
+	so(cond.String(), eq, `"a b" = 1 OR c > $d`)
+	so(fmt.Sprint(Condition{}), eq, "")
+}
+
+func testConditionMarshalJSON(t *testing.T) {
+	cases := []struct {
+		text   string
+		expect string
+	}{
+		{text: `a = 1`, expect: `["a","=",1]`},
+		{text: `a exists`, expect: `["a","exists"]`},
+		{text: `a = null`, expect: `["a","=",null]`},
+		{text: `a = "$x" and b = '@@y' and c = '$'`, expect: `{"and":[["a","=","$x"],["b","=","@@y"],["c","=","$"]]}`},
+		{text: `a = $x or b = @.y`, expect: `{"or":[["a","=",{"ref":"$x"}],["b","=",{"ref":"@.y"}]]}`},
+		{text: `not (a < 1 or b > 2)`, expect: `{"not":{"or":[["a","<",1],["b",">",2]]}}`},
+		{text: `items all (qty between [1, 5]) and @ type "object"`, expect: `{"and":[{"field":"items","all":["qty","between",[1,5]]},["","type","object"]]}`},
+		{text: `a = '<&>'`, expect: `["a","=","<&>"]`},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.text)
+		cond, err := Parse(c.text)
+		so(err, isNil)
+		b, err := cond.MarshalJSON()
+		so(err, isNil)
+		so(string(b), eq, c.expect)
+
+		again := Condition{}
+		err = json.Unmarshal(b, &again)
+		so(err, isNil)
+		so(again, convey.ShouldResemble, cond)
+	}
+
+	// 无法使用 SQL 风格表示的叶子条件
+	for _, cond := range []Condition{
+		{Expr: Expr{Field: "/a/0", Syntax: SyntaxPointer, Operator: "=", Value: "x"}},
+		{Expr: Expr{Field: "a", Operator: "=", Value: map[string]any{"ref": "b"}}},
+	} {
+		b, err := json.Marshal(cond)
+		so(err, isNil)
+		so(string(b), convey.ShouldStartWith, "{")
+		again := Condition{}
+		err = json.Unmarshal(b, &again)
+		so(err, isNil)
+		so(again, convey.ShouldResemble, cond)
+	}
+
+	b, err := json.Marshal(Condition{})
+	so(err, isNil)
+	so(string(b), eq, "{}")
+}
+
+func TestYAML(t *testing.T) {
+	cv("same as JSON", t, func() { testYAMLSameAsJSON(t) })
+	cv("illegal YAML", t, func() { testYAMLIllegal(t) })
+	cv("LoadFile and LoadDir", t, func() { testLoadFile(t) })
+}
+
+func testYAMLSameAsJSON(t *testing.T) {
+	cases := []struct {
+		yaml string
+		json string
+	}{
+		{
+			yaml: `[a, "=", 1]`,
+			json: `["a","=",1]`,
+		}, {
+			yaml: `
+field: user.age
+op: ">="
+value: 18`,
+			json: `{"field":"user.age","op":">=","value":18}`,
+		}, {
+			yaml: `
+and:
+  - [user.age, ">=", 18]
+  - [user.email, exists]
+  - or:
+      - [country, in, [CN, US]]
+      - not: [vip, "=", true]
+  - field: items
+    any:
+      and:
+        - [sku, "=", A-1]
+        - {field: qty, op: ">", ref: "@.min"}
+  - {field: order.date, op: "<", ref: $order.deadline}
+  - [currency, "=", $USD]
+  - [shipping.date, ">=", {ref: $order.date}]`,
+			json: `{"and":[
+				["user.age",">=",18],
+				["user.email","exists"],
+				{"or":[["country","in",["CN","US"]],{"not":["vip","=",true]}]},
+				{"field":"items","any":{"and":[["sku","=","A-1"],{"field":"qty","op":">","ref":"@.min"}]}},
+				{"field":"order.date","op":"<","ref":"$order.deadline"},
+				["currency","=","$USD"],
+				{"field":"shipping.date","op":">=","ref":"$order.date"}
+			]}`,
+		}, {
+			yaml: `
+or:
+  - field: /items/0/sku
+    syntax: pointer
+    op: "="
+    value: a
+  - [created, ">=", 2024-01-02]
+  - [price, between, [0x10, 1.5e2]]
+  - [meta, "=", {k: [1, null, ~], n: 1_000}]
+  - field: deleted_at
+    op: isnull`,
+			json: `{"or":[
+				{"field":"/items/0/sku","syntax":"pointer","op":"=","value":"a"},
+				["created",">=","2024-01-02"],
+				["price","between",[16,1.5e2]],
+				["meta","=",{"k":[1,null,null],"n":1000}],
+				{"field":"deleted_at","op":"isnull"}
+			]}`,
+		}, {
+			yaml: `
+defs:
+  - &adult [user.age, ">=", 18]
+and:
+  - *adult
+  - [name, exists]`,
+			json: `{"and":[["user.age",">=",18],["name","exists"]]}`,
+		}, {
+			yaml: `[id, in, [9007199254740993, 18446744073709551615, -9007199254740993]]`,
+			json: `["id","in",[9007199254740993,18446744073709551615,-9007199254740993]]`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.yaml)
+		got := Condition{}
+		err := yaml.Unmarshal([]byte(c.yaml), &got)
+		so(err, isNil)
+
+		expect := Condition{}
+		err = json.Unmarshal([]byte(c.json), &expect)
+		so(err, isNil)
+		so(got, convey.ShouldResemble, expect)
+	}
+}
+
+func testYAMLIllegal(t *testing.T) {
+	for _, s := range []string{
+		`[a]`,
+		`[a, "=", 1, 2]`,
+		`[[a], "=", 1]`,
+		`[a, {op: "="}, 1]`,
+		`[a, "=", .inf]`,
+		`{and: [[a, "=", .nan]]}`,
+		`{or: 1}`,
+	} {
+		t.Log(s)
+		c := Condition{}
+		err := yaml.Unmarshal([]byte(s), &c)
+		so(err, isErr)
+	}
+}
+
+func testLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(p), 0o755)
+		so(err, isNil)
+		err = os.WriteFile(p, []byte(content), 0o644)
+		so(err, isNil)
+	}
+	write("adult.json", `["user.age",">=",18]`)
+	write("orders/vip.yaml", "and:\n  - [vip, \"=\", true]\n  - [amount, \">\", 100]\n")
+	write("orders/NEW.YML", "[status, \"=\", new]")
+	write("README.md", "# not a rule")
+
+	expect := map[string]Condition{
+		"adult.json": {Expr: Expr{Field: "user.age", Operator: ">=", Value: int64(18)}},
+		"orders/vip.yaml": {AND: AND{
+			{Expr: Expr{Field: "vip", Operator: "=", Value: true}},
+			{Expr: Expr{Field: "amount", Operator: ">", Value: int64(100)}},
+		}},
+		"orders/NEW.YML": {Expr: Expr{Field: "status", Operator: "=", Value: "new"}},
+	}
+
+	c, err := LoadFile(filepath.Join(dir, "orders", "vip.yaml"))
+	so(err, isNil)
+	so(c, convey.ShouldResemble, expect["orders/vip.yaml"])
+
+	all, err := LoadDir(dir)
+	so(err, isNil)
+	so(all, convey.ShouldResemble, expect)
+
+	prog, err := Compile(all["orders/vip.yaml"])
+	so(err, isNil)
+	ok, err := prog.Match(jsonvalue.MustUnmarshalString(`{"vip":true,"amount":150}`))
+	so(err, isNil)
+	so(ok, eq, true)
+
+	_, err = LoadFile(filepath.Join(dir, "README.md"))
+	so(err, isErr)
+	_, err = LoadFile(filepath.Join(dir, "missing.json"))
+	so(err, isErr)
+
+	write("broken/bad.yaml", "[a]")
+	_, err = LoadDir(dir)
+	so(err, isErr)
+	so(err.Error(), convey.ShouldContainSubstring, "bad.yaml")
+}
+
+func TestValidate(t *testing.T) {
+	cv("Validate", t, func() { testValidate(t) })
+	cv("strict decode", t, func() { testUnmarshalStrict(t) })
+	cv("illegal options", t, func() { testIllegalOptions(t) })
+}
+
+func testValidate(t *testing.T) {
+	cases := []struct {
+		cond   string
+		expect []string
+	}{
+		{
+			cond: `{"and":[["a","=",1],["b","exists"],{"not":{"or":[["c","=>",1],["d","in",[1]]]}}]}`,
+			expect: []string{
+				`and[2].not.or[0]: illegal operator (=>)`,
+			},
+		}, {
+			cond: `{"or":[["a","=",1]],"and":[["b","~",1],["c.[x]","=",1]],"field":"x","op":"="}`,
+			expect: []string{
+				`illegal condition, only one of or, and, not, any, all and none can be given, but got or, and`,
+				`and[0]: illegal operator (~)`,
+				`and[1]: illegal field 'c.[x]', invalid array segment '[x]' at position 2`,
+				`illegal condition, field, op should not be given in 'or' condition`,
+			},
+		}, {
+			cond: `{"field":"items.[*]","op":"=","any":{"field":"qty"},"all":["sku","=",1]}`,
+			expect: []string{
+				`illegal condition, only one of or, and, not, any, all and none can be given, but got any, all`,
+				`illegal field 'items.[*]', field of 'any' condition should refer to exactly one array`,
+				`any: illegal operator, missing op`,
+				`illegal condition, op should not be given in 'any' condition`,
+			},
+		}, {
+			cond:   `{}`,
+			expect: []string{`illegal operator, missing op`},
+		}, {
+			cond:   `{"not":{"field":"a","op":"=","value":1,"ref":"b"}}`,
+			expect: []string{`not: illegal target value, value and ref should not be both given`},
+		},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.cond)
+		cond := Condition{}
+		err := json.Unmarshal([]byte(c.cond), &cond)
+		so(err, isNil)
+
+		err = cond.Validate()
+		so(err, isErr)
+
+		var errs ValidationErrors
+		so(errors.As(err, &errs), eq, true)
+		got := make([]string, 0, len(errs))
+		for _, e := range errs {
+			got = append(got, e.Error())
+		}
+		so(got, convey.ShouldResemble, c.expect)
+	}
+
+	// 错误类型
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`{"and":[["a","=",1],{"or":[["b","=>",2],["c","=",3]]}]}`), &cond)
+	so(err, isNil)
+	err = cond.Validate()
+	so(errors.Is(err, ErrIllegalOperator), eq, true)
+	so(errors.Is(err, ErrIllegalField), eq, false)
+	var first *ValidationError
+	so(errors.As(err, &first), eq, true)
+	so(first.Path, eq, "and[1].or[0]")
+
+	// 合法的规则
+	for _, s := range []string{
+		`a = 1 AND (b > 2 OR NOT c IN [1, 2]) AND items ANY (qty > @.min)`,
+		`created > '2024-01-01' AND items[+].sku exists`,
+	} {
+		cond, err := Parse(s)
+		so(err, isNil)
+		so(cond.Validate(OptDateTimeFormat(time.DateOnly)), isNil)
+	}
+}
+
+func testUnmarshalStrict(t *testing.T) {
+	c, err := UnmarshalStrict([]byte(`{"and":[["a","=",1],{"field":"b","op":"exists"}]}`))
+	so(err, isNil)
+	so(c.String(), eq, `a = 1 AND b exists`)
+
+	cases := []struct {
+		cond   string
+		expect []string
+	}{
+		{
+			cond: `{"and":[["a","=",1],{"field":"b","opp":"exists"},{"not":{"or":[["c"],"d",{"feild":"e"}]}}]}`,
+			expect: []string{
+				`and[1]: unknown key 'opp'`,
+				`and[2].not.or[0]: illegal condition, SQL style expr should have length 2 or 3, but got 1`,
+				`and[2].not.or[1]: illegal condition, expecting an object or an array but got string`,
+				`and[2].not.or[2]: unknown key 'feild'`,
+			},
+		}, {
+			cond: `{"or":{"field":"a"},"op":1,"and":[[1,"=",1]]}`,
+			expect: []string{
+				`and[0]: illegal condition, field of SQL style expr should be a string`,
+				`op: illegal condition, should be a string`,
+				`or: illegal condition, should be an array`,
+			},
+		}, {
+			cond: `{"and":[["a","=>",1],["b","exists"]],"field":"x"}`,
+			expect: []string{
+				`and[0]: illegal operator (=>)`,
+				`illegal condition, field should not be given in 'and' condition`,
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Log("No", i+1, c.cond)
+		_, err := UnmarshalStrict([]byte(c.cond))
+		so(err, isErr)
+		errs, ok := err.(ValidationErrors)
+		so(ok, eq, true)
+		got := make([]string, 0, len(errs))
+		for _, e := range errs {
+			got = append(got, e.Error())
+		}
+		so(got, convey.ShouldResemble, c.expect)
+	}
+
+	_, err = UnmarshalStrict([]byte(`{"and":[`))
+	so(err, isErr)
+
+	// YAML
+	c, err = UnmarshalYAMLStrict([]byte("and:\n  - [a, \"=\", 1]\n  - field: items\n    any: [qty, \">\", 1]\n"))
+	so(err, isNil)
+	so(c.String(), eq, `a = 1 AND items ANY (qty > 1)`)
+
+	_, err = UnmarshalYAMLStrict([]byte("and:\n  - [a, \"=\", 1]\n  - fields: b\n    op: exists\n"))
+	so(err, isErr)
+	so(err.Error(), eq, `and[1]: unknown key 'fields'`)
+	so(errors.Is(err, ErrUnknownKey), eq, true)
+}
+
+func testIllegalOptions(t *testing.T) {
+	cond, err := Parse(`a > '2024-01-01'`)
+	so(err, isNil)
+
+	for _, f := range []string{"abc", "time", ".000"} {
+		t.Log(f)
+		_, err = Compile(cond, OptDateTimeFormat(f))
+		so(errors.Is(err, ErrIllegalOption), eq, true)
+
+		err = cond.Validate(OptDateTimeFormat(f))
+		so(errors.Is(err, ErrIllegalOption), eq, true)
+	}
+
+	for _, f := range []string{time.DateOnly, time.RFC3339, "01/02", "Jan 2006", ""} {
+		t.Log(f)
+		_, err = Compile(Condition{Expr: Expr{Field: "a", Operator: "exists"}}, OptDateTimeFormat(f))
+		so(err, isNil)
+	}
+}
+
+func TestRuleSet(t *testing.T) {
+	cv("match", t, func() { testRuleSetMatch(t) })
+	cv("serialization", t, func() { testRuleSetSerialization(t) })
+	cv("illegal rule set", t, func() { testRuleSetIllegal(t) })
+}
+
+const testRuleSetJSON = `{"rules":[
+	{"id":"default","priority":-1,"condition":["","exists"],"payload":{"queue":"normal"}},
+	{"id":"vip","priority":10,"condition":["user.vip","=",true],"payload":{"queue":"vip"}},
+	{"id":"big-order","priority":10,"condition":["order.amount",">=",1000],"payload":{"queue":"vip","review":true}},
+	{"id":"cn","condition":["user.country","=","CN"],"payload":"cn"},
+	{"id":"blocked","priority":100,"disabled":true,"condition":["user.blocked","=",true]}
+]}`
+
+func testRuleSetMatch(t *testing.T) {
+	rs, err := UnmarshalRuleSet([]byte(testRuleSetJSON), OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+
+	ids := func(res []Result) []string {
+		s := make([]string, 0, len(res))
+		for _, r := range res {
+			s = append(s, r.ID)
+		}
+		return s
+	}
+
+	doc := jsonvalue.MustUnmarshalString(`{"user":{"vip":false,"country":"CN","blocked":true},"order":{"amount":2000}}`)
+	r, ok, err := rs.FirstMatch(doc)
+	so(err, isNil)
+	so(ok, eq, true)
+	so(r.ID, eq, "big-order")
+	so(r.Priority, eq, 10)
+	so(r.Payload, convey.ShouldResemble, map[string]any{"queue": "vip", "review": true})
+
+	all, err := rs.AllMatches(doc)
+	so(err, isNil)
+	so(ids(all), convey.ShouldResemble, []string{"big-order", "cn", "default"})
+
+	// 相同优先级按照原始顺序
+	all, err = rs.AllMatches(map[string]any{"user": map[string]any{"vip": true}, "order": map[string]any{"amount": 1000}})
+	so(err, isNil)
+	so(ids(all), convey.ShouldResemble, []string{"vip", "big-order", "default"})
+
+	results := rs.Evaluate(jsonvalue.MustUnmarshalString(`{"user":{"country":"US"}}`))
+	so(ids(results), convey.ShouldResemble, []string{"vip", "big-order", "cn", "default"})
+	so(results[0].Matched, eq, false)
+	so(results[3].Matched, eq, true)
+	so(results[3].Payload, convey.ShouldResemble, map[string]any{"queue": "normal"})
+
+	// 默认参数下找不到字段会返回错误
+	rs, err = UnmarshalRuleSet([]byte(testRuleSetJSON))
+	so(err, isNil)
+	_, _, err = rs.FirstMatch(jsonvalue.MustUnmarshalString(`{"user":{"vip":false}}`))
+	so(errors.Is(err, ErrNotFound), eq, true)
+	so(err.Error(), convey.ShouldContainSubstring, "big-order")
+	_, err = rs.AllMatches(jsonvalue.MustUnmarshalString(`{"user":{"vip":false}}`))
+	so(errors.Is(err, ErrNotFound), eq, true)
+
+	results = rs.Evaluate(jsonvalue.MustUnmarshalString(`{"user":{"vip":false,"country":"CN"}}`))
+	so(len(results), eq, 4)
+	so(results[0].Err, isNil)
+	so(errors.Is(results[1].Err, ErrNotFound), eq, true)
+	so(results[1].Matched, eq, false)
+	so(results[2].Matched, eq, true)
+
+	_, ok, err = rs.FirstMatch(jsonvalue.MustUnmarshalString(`{"user":{"vip":false,"country":"US"},"order":{"amount":1}}`))
+	so(err, isNil)
+	so(ok, eq, true)
+
+	rs, err = NewRuleSet([]Rule{{ID: "a", Condition: Condition{Expr: Expr{Field: "a", Operator: "=", Value: 1}}}})
+	so(err, isNil)
+	_, ok, err = rs.FirstMatch(jsonvalue.MustUnmarshalString(`{"a":2}`))
+	so(err, isNil)
+	so(ok, eq, false)
+	all, err = rs.AllMatches(jsonvalue.MustUnmarshalString(`{"a":2}`))
+	so(err, isNil)
+	so(len(all), eq, 0)
+
+	// 编译之后修改原始的 Payload 或者返回的 Payload, 都不影响 RuleSet
+	rules := []Rule{{ID: "a", Condition: Condition{Expr: Expr{Field: "a", Operator: "=", Value: 1}},
+		Payload: map[string]any{"queue": []any{"vip"}}}}
+	rs, err = NewRuleSet(rules)
+	so(err, isNil)
+	rules[0].Payload.(map[string]any)["queue"].([]any)[0] = "changed"
+	r, _, err = rs.FirstMatch(map[string]any{"a": 1})
+	so(err, isNil)
+	r.Payload.(map[string]any)["queue"] = "changed"
+	rs.Rules()[0].Payload.(map[string]any)["queue"] = "changed"
+	all, err = rs.AllMatches(map[string]any{"a": 1})
+	so(err, isNil)
+	so(all[0].Payload, convey.ShouldResemble, map[string]any{"queue": []any{"vip"}})
+	so(rs.Rules()[0].Payload, convey.ShouldResemble, map[string]any{"queue": []any{"vip"}})
+
+	// 无法解析的值
+	_, _, err = rs.FirstMatch(make(chan int))
+	so(err, isErr)
+	results = rs.Evaluate(make(chan int))
+	so(results[0].Err, isErr)
+}
+
+func testRuleSetSerialization(t *testing.T) {
+	rs := &RuleSet{}
+	err := json.Unmarshal([]byte(testRuleSetJSON), rs)
+	so(err, isNil)
+	so(len(rs.Rules()), eq, 5)
+	so(rs.Rules()[4].Disabled, eq, true)
+
+	b, err := json.Marshal(rs)
+	so(err, isNil)
+	again, err := UnmarshalRuleSet(b)
+	so(err, isNil)
+	so(again.Rules(), convey.ShouldResemble, rs.Rules())
+
+	y, err := yaml.Marshal(rs)
+	so(err, isNil)
+	t.Log(string(y))
+	fromYAML := &RuleSet{}
+	err = yaml.Unmarshal(y, fromYAML)
+	so(err, isNil)
+	so(fromYAML.Rules(), convey.ShouldResemble, rs.Rules())
+
+	// 规则数组
+	fromYAML, err = UnmarshalRuleSetYAML([]byte(`
+- id: a
+  priority: 2
+  condition: [n, ">", 1]
+  payload: {score: 5}
+- id: b
+  condition:
+    or:
+      - [n, "<", 0]
+      - [n, "=", 1]
+`))
+	so(err, isNil)
+	r, ok, err := fromYAML.FirstMatch(jsonvalue.MustUnmarshalString(`{"n":2}`))
+	so(err, isNil)
+	so(ok, eq, true)
+	so(r.Payload, convey.ShouldResemble, map[string]any{"score": int64(5)})
+
+	fromJSON, err := UnmarshalRuleSet([]byte(`[{"id":"a","priority":2,"condition":["n",">",1],"payload":{"score":5}},
+		{"id":"b","condition":{"or":[["n","<",0],["n","=",1]]}}]`))
+	so(err, isNil)
+	so(fromJSON.Rules(), convey.ShouldResemble, fromYAML.Rules())
+
+	// 大于 2^53 的整数不能丢失精度
+	big, err := UnmarshalRuleSetYAML([]byte(`
+- id: big
+  condition: [user.id, "=", 9007199254740993]
+  payload: {route: 18446744073709551615}
+`))
+	so(err, isNil)
+	_, ok, err = big.FirstMatch(jsonvalue.MustUnmarshalString(`{"user":{"id":9007199254740992}}`))
+	so(err, isNil)
+	so(ok, eq, false)
+	r, ok, err = big.FirstMatch(jsonvalue.MustUnmarshalString(`{"user":{"id":9007199254740993}}`))
+	so(err, isNil)
+	so(ok, eq, true)
+	so(r.Payload, convey.ShouldResemble, map[string]any{"route": uint64(18446744073709551615)})
+}
+
+func testRuleSetIllegal(t *testing.T) {
+	for _, s := range []string{
+		`{"rules":[{"condition":["a","=",1]}]}`,
+		`[{"id":"a","condition":["a","=",1]},{"id":"a","condition":["b","=",1]}]`,
+		`[{"id":"a","condition":["a","=>",1]}]`,
+		`[{"id":"a","condition":["a"]}]`,
+		`{"rules":1}`,
+	} {
+		t.Log(s)
+		_, err := UnmarshalRuleSet([]byte(s))
+		so(err, isErr)
+	}
+
+	_, err := NewRuleSet([]Rule{{ID: "a", Condition: Condition{Expr: Expr{Field: "a", Operator: ">", Value: "x"}}}})
+	so(errors.Is(err, ErrIllegalTargetValue), eq, true)
+	_, err = NewRuleSet([]Rule{{ID: "a"}, {ID: "a"}})
+	so(err, isErr)
+	_, err = NewRuleSet([]Rule{{Condition: Condition{Expr: Expr{Operator: "exists"}}}})
+	so(errors.Is(err, ErrIllegalRule), eq, true)
+
+	_, err = UnmarshalRuleSetYAML([]byte("- id: a\n  condition: [a, '=>', 1]\n"))
+	so(errors.Is(err, ErrIllegalOperator), eq, true)
+	_, err = UnmarshalRuleSetYAML([]byte("rules: ["))
+	so(err, isErr)
+}
+
+func TestDecide(t *testing.T) {
+	cv("Decide", t, func() { testDecide(t) })
+	cv("message template", t, func() { testMessageTemplate(t) })
+}
+
+func testDecide(t *testing.T) {
+	rs, err := UnmarshalRuleSetYAML([]byte(`
+rules:
+  - id: blocked
+    priority: 100
+    condition: [user.blocked, "=", true]
+    then:
+      result: reject
+      tags: [risk, blocked]
+      score: -100
+      message: user ${user.name} is blocked
+  - id: big-order
+    priority: 10
+    condition: [order.amount, ">=", 1000]
+    then:
+      result: {approve: true, review: true}
+      tags: [review]
+      score: 20
+      message: order amount ${order.amount} needs review
+    else:
+      score: 5
+  - id: new-user
+    condition: [user.days, "<", 7]
+    then:
+      tags: [risk, new]
+      score: -10
+  - id: default
+    priority: -1
+    condition: ["", exists]
+    then:
+      result: {approve: true}
+`), OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+
+	d, err := rs.Decide(jsonvalue.MustUnmarshalString(`{"user":{"name":"Bob","days":3},"order":{"amount":1500}}`))
+	so(err, isNil)
+	so(d.Result, convey.ShouldResemble, map[string]any{"approve": true, "review": true})
+	so(d.Tags, convey.ShouldResemble, []string{"review", "risk", "new"})
+	so(d.Score, eq, float64(10))
+	so(d.Messages, convey.ShouldResemble, []string{"order amount 1500 needs review"})
+	so(len(d.Outcomes), eq, 3)
+	so(d.Outcomes[0].ID, eq, "big-order")
+	so(d.Outcomes[0].Matched, eq, true)
+	so(d.Outcomes[2].ID, eq, "default")
+
+	d, err = rs.Decide(jsonvalue.MustUnmarshalString(`{"user":{"name":"Eve","blocked":true,"days":30},"order":{"amount":10}}`))
+	so(err, isNil)
+	so(d.Result, eq, "reject")
+	so(d.Tags, convey.ShouldResemble, []string{"risk", "blocked"})
+	so(d.Score, eq, float64(-95))
+	so(d.Messages, convey.ShouldResemble, []string{"user Eve is blocked"})
+	so(d.Outcomes[1].ID, eq, "big-order")
+	so(d.Outcomes[1].Matched, eq, false)
+	so(d.Outcomes[1].Score, eq, float64(5))
+
+	b, err := json.Marshal(d)
+	so(err, isNil)
+	so(string(b), convey.ShouldStartWith, `{"result":"reject","tags":["risk","blocked"],"score":-95,`)
+
+	// 编译后直接使用的函数
+	rules := []Rule{{
+		ID:        "adult",
+		Condition: Condition{Expr: Expr{Field: "age", Operator: ">=", Value: 18}},
+		Then:      &Action{Result: "adult"},
+		Else:      &Action{Result: "minor", Message: "age is ${age}"},
+	}}
+	d, err = Decide(map[string]any{"age": 12}, rules)
+	so(err, isNil)
+	so(d.Result, eq, "minor")
+	so(d.Messages, convey.ShouldResemble, []string{"age is 12"})
+
+	_, err = Decide(map[string]any{"name": "x"}, rules)
+	so(errors.Is(err, ErrNotFound), eq, true)
+	_, err = Decide(map[string]any{"age": 12}, []Rule{{ID: "a", Then: &Action{Message: "${a"}}})
+	so(err, isErr)
+
+	// 没有动作时结果为空
+	d, err = Decide(map[string]any{"age": 20}, []Rule{{ID: "a", Condition: rules[0].Condition}})
+	so(err, isNil)
+	so(d.Result, isNil)
+	so(len(d.Outcomes), eq, 0)
+
+	// 编译之后修改原始的 Action 或者决策结果, 都不影响之后的决策
+	act := &Action{Result: map[string]any{"level": "vip"}, Tags: []string{"vip", "gold"}, Score: 1}
+	rs, err = NewRuleSet([]Rule{{ID: "vip", Condition: rules[0].Condition, Then: act}})
+	so(err, isNil)
+	act.Tags[0] = "changed"
+	act.Result.(map[string]any)["level"] = "changed"
+	act.Score = 100
+	d, err = rs.Decide(map[string]any{"age": 20})
+	so(err, isNil)
+	d.Tags[1] = "changed"
+	d.Outcomes[0].Tags[1] = "changed"
+	d.Result.(map[string]any)["level"] = "changed"
+	d.Outcomes[0].Result.(map[string]any)["extra"] = true
+	d, err = rs.Decide(map[string]any{"age": 20})
+	so(err, isNil)
+	so(d.Result, convey.ShouldResemble, map[string]any{"level": "vip"})
+	so(d.Tags, convey.ShouldResemble, []string{"vip", "gold"})
+	so(d.Outcomes[0].Tags, convey.ShouldResemble, []string{"vip", "gold"})
+	so(d.Score, eq, float64(1))
+
+	// JSON 形式与 YAML 形式相同
+	fromJSON, err := UnmarshalRuleSet([]byte(`[{"id":"a","condition":["x","=",1],
+		"then":{"result":{"n":1},"tags":["t"],"score":1.5,"message":"x=${x}"},"else":{"result":2}}]`))
+	so(err, isNil)
+	fromYAML, err := UnmarshalRuleSetYAML([]byte(`
+- id: a
+  condition: [x, "=", 1]
+  then: {result: {n: 1}, tags: [t], score: 1.5, message: "x=${x}"}
+  else: {result: 2}
+`))
+	so(err, isNil)
+	so(fromYAML.Rules(), convey.ShouldResemble, fromJSON.Rules())
+}
+
+func testMessageTemplate(t *testing.T) {
+	doc := jsonvalue.MustUnmarshalString(`{
+		"user":{"name":"Alice","tags":["a","b"]},"items":[{"sku":"x<1>"}],"headers":{"x-id":"7"},"n":null
+	}`)
+	cases := []struct {
+		tpl    string
+		expect string
+	}{
+		{tpl: `hello`, expect: `hello`},
+		{tpl: `hi ${user.name}!`, expect: `hi Alice!`},
+		{tpl: `${user.name}${user.name}`, expect: `AliceAlice`},
+		{tpl: `tags=${user.tags}, first=${user.tags.[0]}, len=${user.tags.#}`, expect: `tags=["a","b"], first=a, len=2`},
+		{tpl: `${ /items/0/sku } ${$.headers['x-id']} ${'headers'.'x-id'}`, expect: `x<1> 7 7`},
+		{tpl: `${user}`, expect: `{"name":"Alice","tags":["a","b"]}`},
+		{tpl: `[${missing.field}] [${n}]`, expect: `[] [null]`},
+		{tpl: `$${user.name} costs $5 ${user.name}`, expect: `${user.name} costs $5 Alice`},
+	}
+	for i, c := range cases {
+		t.Log("No", i+1, c.tpl)
+		tpl, err := parseTemplate(c.tpl)
+		so(err, isNil)
+		so(tpl.render(doc), eq, c.expect)
+	}
+
+	for _, s := range []string{`${a`, `${a.[+]}`, `${a.[x]}`, `${..a}`, `${$.a[*]}`} {
+		t.Log(s)
+		_, err := parseTemplate(s)
+		so(err, isErr)
+	}
+}
+
+func TestDecisionTable(t *testing.T) {
+	cv("hit policies", t, func() { testDecisionTableHitPolicies(t) })
+	cv("overlaps and gaps", t, func() { testDecisionTableCompleteness(t) })
+	cv("JSON, YAML and CSV", t, func() { testDecisionTableFormats(t) })
+	cv("illegal tables", t, func() { testDecisionTableIllegal(t) })
+}
+
+const testDiscountTable = `{
+	"hit_policy": "unique",
+	"inputs": ["customer.age", "customer.tier"],
+	"rows": [
+		{"when": ["< 18", "-"], "output": 0.1},
+		{"when": [">= 18", "gold"], "output": 0.2},
+		{"when": [[">=", 18], "in ['silver', 'bronze']"], "output": 0.05},
+		{"id": "others", "when": [">= 18", "not in [\"gold\", \"silver\", \"bronze\"]"], "output": 0}
+	]
+}`
+
+func testDecisionTableHitPolicies(t *testing.T) {
+	dt, err := UnmarshalDecisionTable([]byte(testDiscountTable))
+	so(err, isNil)
+
+	eval := func(dt *DecisionTable, doc string) TableResult {
+		res, err := dt.Evaluate(jsonvalue.MustUnmarshalString(doc))
+		so(err, isNil)
+		return res
+	}
+	res := eval(dt, `{"customer":{"age":12,"tier":"gold"}}`)
+	so(res.Output, eq, 0.1)
+	so(res.Matched, convey.ShouldResemble, []int{0})
+	so(eval(dt, `{"customer":{"age":30,"tier":"gold"}}`).Output, eq, 0.2)
+	so(eval(dt, `{"customer":{"age":18,"tier":"bronze"}}`).Output, eq, 0.05)
+	so(eval(dt, `{"customer":{"age":40,"tier":"none"}}`).Output, eq, float64(0))
+
+	// 无法分析的单元格在匹配时才能发现重叠
+	dt, err = NewDecisionTable(TableDefinition{
+		Inputs: []string{"name"},
+		Rows: []TableRow{
+			{When: []any{"startswith 'a'"}, Output: "a"},
+			{When: []any{"endswith 'z'"}, Output: "z"},
+		},
+		Default: "-",
+	})
+	so(err, isNil)
+	so(eval(dt, `{"name":"abc"}`).Output, eq, "a")
+	so(eval(dt, `{"name":"xyz"}`).Output, eq, "z")
+	so(eval(dt, `{"name":"xyw"}`).Output, eq, "-")
+	so(eval(dt, `{"name":"xyw"}`).Matched, convey.ShouldResemble, []int{})
+	_, err = dt.Evaluate(map[string]any{"name": "abz"})
+	so(errors.Is(err, ErrTableOverlap), eq, true)
+
+	// SQL 风格的单元格可以引用其他字段
+	dt, err = NewDecisionTable(TableDefinition{
+		HitPolicy: "first",
+		Inputs:    []string{"used"},
+		Rows: []TableRow{
+			{When: []any{[]any{">=", map[string]any{"ref": "$limit"}}}, Output: "over"},
+			{When: []any{"-"}, Output: "ok"},
+		},
+	})
+	so(err, isNil)
+	so(eval(dt, `{"used":5,"limit":5}`).Output, eq, "over")
+	so(eval(dt, `{"used":4,"limit":5}`).Output, eq, "ok")
+
+	// 编译之后修改定义或者返回的输出, 都不影响之后的匹配
+	def := TableDefinition{
+		HitPolicy: "collect",
+		Inputs:    []string{"n"},
+		Rows: []TableRow{
+			{When: []any{[]any{">", 1}}, Output: map[string]any{"level": "high"}},
+		},
+		Default: map[string]any{"level": "none"},
+	}
+	dt, err = NewDecisionTable(def)
+	so(err, isNil)
+	def.Rows[0].When[0].([]any)[1] = 100
+	def.Rows[0].Output.(map[string]any)["level"] = "changed"
+	def.Default.(map[string]any)["level"] = "changed"
+	eval(dt, `{"n":2}`).Output.([]any)[0].(map[string]any)["level"] = "changed"
+	dt.Definition().Rows[0].Output.(map[string]any)["level"] = "changed"
+	so(eval(dt, `{"n":2}`).Output, convey.ShouldResemble, []any{map[string]any{"level": "high"}})
+	so(eval(dt, `{"n":0}`).Output, convey.ShouldResemble, map[string]any{"level": "none"})
+	eval(dt, `{"n":0}`).Output.(map[string]any)["level"] = "changed"
+	so(eval(dt, `{"n":0}`).Output, convey.ShouldResemble, map[string]any{"level": "none"})
+	so(dt.Definition().Rows[0].When, convey.ShouldResemble, []any{[]any{">", 1}})
+	_, err = dt.Evaluate(map[string]any{"age": 1})
+	so(errors.Is(err, ErrNotFound), eq, true)
+
+	rows := []TableRow{
+		{When: []any{">= 90", "-"}, Output: "A"},
+		{When: []any{">= 60", "-"}, Output: "B"},
+		{When: []any{"-", true}, Output: "B"},
+		{When: []any{"-", "-"}, Output: "C"},
+	}
+	inputs := []string{"score", "bonus"}
+	newTable := func(policy HitPolicy, agg Aggregation, rows []TableRow, order ...any) *DecisionTable {
+		dt, err := NewDecisionTable(TableDefinition{
+			HitPolicy: policy, Aggregation: agg, Inputs: inputs, Rows: rows, OutputOrder: order,
+		})
+		so(err, isNil)
+		return dt
+	}
+
+	dt = newTable("first", "", rows)
+	so(eval(dt, `{"score":95,"bonus":true}`).Output, eq, "A")
+	so(eval(dt, `{"score":95,"bonus":true}`).Matched, convey.ShouldResemble, []int{0})
+	so(eval(dt, `{"score":10,"bonus":true}`).Output, eq, "B")
+	so(eval(dt, `{"score":10,"bonus":false}`).Output, eq, "C")
+
+	dt = newTable("P", "", []TableRow{rows[3], rows[1], rows[0]}, "A", "B", "C")
+	so(eval(dt, `{"score":95,"bonus":false}`).Output, eq, "A")
+	so(eval(dt, `{"score":95,"bonus":false}`).Matched, convey.ShouldResemble, []int{0, 1, 2})
+	so(eval(dt, `{"score":70,"bonus":false}`).Output, eq, "B")
+
+	dt = newTable("any", "", []TableRow{rows[1], rows[2]})
+	so(eval(dt, `{"score":70,"bonus":true}`).Output, eq, "B")
+	so(eval(dt, `{"score":70,"bonus":true}`).Matched, convey.ShouldResemble, []int{0, 1})
+	so(eval(dt, `{"score":0,"bonus":false}`).Output, isNil)
+
+	// 输出按照 JSON 语义比较, 1 与 1.0 相同
+	numbers := []TableRow{
+		{When: []any{">= 60", "-"}, Output: 2.0},
+		{When: []any{"-", true}, Output: 1},
+	}
+	dt = newTable("P", "", numbers, 1, 2)
+	so(eval(dt, `{"score":70,"bonus":true}`).Output, eq, 1)
+	so(eval(dt, `{"score":70,"bonus":false}`).Output, eq, 2.0)
+	dt = newTable("any", "", []TableRow{numbers[1], {When: []any{">= 60", "-"}, Output: 1.0}})
+	so(eval(dt, `{"score":70,"bonus":true}`).Output, eq, 1)
+
+	dt = newTable("collect", "", rows)
+	so(eval(dt, `{"score":95,"bonus":false}`).Output, convey.ShouldResemble, []any{"A", "B", "C"})
+	so(eval(dt, `{"score":10,"bonus":false}`).Output, convey.ShouldResemble, []any{"C"})
+
+	points := []TableRow{
+		{When: []any{">= 90", "-"}, Output: 10},
+		{When: []any{">= 60", "-"}, Output: 5},
+		{When: []any{"-", true}, Output: 3.5},
+	}
+	for _, c := range []struct {
+		policy HitPolicy
+		agg    Aggregation
+		expect any
+	}{
+		{"collect", "sum", 18.5},
+		{"C+", "", 18.5},
+		{"C<", "", 3.5},
+		{"collect", "max", float64(10)},
+		{"c#", "count", float64(3)},
+	} {
+		t.Log(c.policy, c.agg)
+		dt := newTable(c.policy, c.agg, points)
+		so(eval(dt, `{"score":95,"bonus":true}`).Output, eq, c.expect)
+	}
+	so(eval(newTable("C#", "", points), `{"score":0,"bonus":false}`).Output, eq, float64(0))
+	so(eval(newTable("C+", "", points), `{"score":0,"bonus":false}`).Output, isNil)
+}
+
+func testDecisionTableCompleteness(t *testing.T) {
+	cases := []struct {
+		table  string
+		expect []string
+	}{
+		{
+			table: `{"inputs":["age","tier"],"rows":[
+				{"when":[">= 18","-"],"output":1},
+				{"when":["between [60, 100]","gold"],"output":2},
+				{"when":["< 18","-"],"output":3},
+				{"when":["= 17","in ['gold', 'silver']"],"output":4}
+			]}`,
+			expect: []string{
+				`rows[1]: decision table rows overlap with rows[0]`,
+				`rows[3]: decision table rows overlap with rows[2]`,
+			},
+		}, {
+			table: `{"inputs":["age"],"rows":[{"when":["< 18"]},{"when":["> 18"]}]}`,
+			expect: []string{
+				`decision table has gaps, no row matches age = 18`,
+			},
+		}, {
+			table: `{"inputs":["age","tier"],"rows":[
+				{"when":["< 18","-"]},
+				{"when":[">= 18","gold"]},
+				{"when":["between '(18, 60]'","silver"]}
+			]}`,
+			expect: []string{
+				`decision table has gaps, no row matches age = 18, tier not in ["gold"]`,
+				`decision table has gaps, no row matches 18 < age < 60, tier not in ["gold", "silver"]`,
+				`decision table has gaps, no row matches age = 60, tier not in ["gold", "silver"]`,
+				`decision table has gaps, no row matches age > 60, tier not in ["gold"]`,
+			},
+		}, {
+			table: `{"hit_policy":"any","inputs":["n"],"rows":[
+				{"when":["in [1, 2, 3]"],"output":"a"},
+				{"when":["!= 2"],"output":"a"},
+				{"when":["<> 5"],"output":"b"}
+			]}`,
+			expect: []string{
+				`rows[2]: decision table rows overlap with rows[0]`,
+				`rows[2]: decision table rows overlap with rows[1]`,
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Log("No", i+1, c.table)
+		_, err := UnmarshalDecisionTable([]byte(c.table))
+		so(err, isErr)
+		errs, ok := err.(ValidationErrors)
+		so(ok, eq, true)
+		got := make([]string, 0, len(errs))
+		for _, e := range errs {
+			got = append(got, e.Error())
+		}
+		so(got, convey.ShouldResemble, c.expect)
+	}
+
+	_, err := UnmarshalDecisionTable([]byte(`{"inputs":["age"],"rows":[{"when":["< 18"]},{"when":["> 18"]}]}`))
+	so(errors.Is(err, ErrTableGap), eq, true)
+	so(errors.Is(err, ErrTableOverlap), eq, false)
+
+	// 完整且不重叠的表格
+	for _, s := range []string{
+		testDiscountTable,
+		`{"inputs":["vip","n"],"rows":[
+			{"when":[true,"<= 0"]},{"when":[true,"> 0"]},{"when":[false,"not in [1, 2]"]},{"when":[false,"in [1, 2]"]}
+		]}`,
+		`{"inputs":["n"],"rows":[{"when":["between '[0, 10)'"]},{"when":["between '[10, 20]'"]},{"when":["not between '[0, 20]'"]}]}`,
+		`{"inputs":["n"],"rows":[{"when":["< 18"]}],"default":0}`,
+		`{"inputs":["n"],"rows":[{"when":["-"]}]}`,
+		`{"hit_policy":"first","inputs":["n"],"rows":[{"when":["< 18"]},{"when":["-"]}]}`,
+		`{"hit_policy":"any","inputs":["n"],"rows":[{"when":["< 18"],"output":1},{"when":["< 20"],"output":1}]}`,
+		// 无法分析的单元格不报告问题
+		`{"inputs":["s"],"rows":[{"when":["contains 'a'"]},{"when":["contains 'b'"]}]}`,
+	} {
+		t.Log(s)
+		_, err := UnmarshalDecisionTable([]byte(s))
+		so(err, isNil)
+	}
+}
+
+func testDecisionTableFormats(t *testing.T) {
+	fromJSON, err := UnmarshalDecisionTable([]byte(testDiscountTable))
+	so(err, isNil)
+
+	fromYAML, err := UnmarshalDecisionTableYAML([]byte(`
+hit_policy: U
+inputs: [customer.age, customer.tier]
+rows:
+  - when: ["< 18", "-"]
+    output: 0.1
+  - when: [">= 18", gold]
+    output: 0.2
+  - when: [[">=", 18], "in ['silver', 'bronze']"]
+    output: 0.05
+  - id: others
+    when: [">= 18", "not in [\"gold\", \"silver\", \"bronze\"]"]
+    output: 0
+`))
+	so(err, isNil)
+	def := fromYAML.Definition()
+	def.HitPolicy = HitUnique
+	so(def, convey.ShouldResemble, fromJSON.Definition())
+
+	csvText := "U, customer.age, customer.tier, discount\n" +
+		"1, < 18, -, 0.1\n" +
+		"2, >= 18, gold, 0.2\n" +
+		"3, >= 18, \"in ['silver', 'bronze']\", 0.05\n" +
+		"\n" +
+		"others, >= 18, \"not in [\"\"gold\"\", \"\"silver\"\", \"\"bronze\"\"]\", 0\n"
+	fromCSV, err := ReadDecisionTableCSV(strings.NewReader(csvText))
+	so(err, isNil)
+	for _, doc := range []string{
+		`{"customer":{"age":12,"tier":"gold"}}`,
+		`{"customer":{"age":30,"tier":"gold"}}`,
+		`{"customer":{"age":18,"tier":"bronze"}}`,
+		`{"customer":{"age":40,"tier":"none"}}`,
+	} {
+		expect, err := fromJSON.Evaluate(jsonvalue.MustUnmarshalString(doc))
+		so(err, isNil)
+		got, err := fromCSV.Evaluate(jsonvalue.MustUnmarshalString(doc))
+		so(err, isNil)
+		so(got, convey.ShouldResemble, expect)
+	}
+
+	// 输出为 CSV 之后再读取
+	buff := &strings.Builder{}
+	err = fromJSON.WriteCSV(buff)
+	so(err, isNil)
+	t.Log(buff.String())
+	again, err := ReadDecisionTableCSV(strings.NewReader(buff.String()))
+	so(err, isNil)
+	so(again.Definition().Rows[3].Output, eq, float64(0))
+	so(again.Definition().Rows[3].ID, eq, "others")
+	res, err := again.Evaluate(map[string]any{"customer": map[string]any{"age": 30, "tier": "silver"}})
+	so(err, isNil)
+	so(res.Output, eq, 0.05)
+
+	outputs, err := NewDecisionTable(TableDefinition{
+		HitPolicy: "C", Inputs: []string{"a"},
+		Rows: []TableRow{
+			{When: []any{1}, Output: "plain"},
+			{When: []any{"= 'x y'"}, Output: "true"},
+			{When: []any{"$b"}, Output: map[string]any{"k": "v"}},
+		},
+	})
+	so(err, isNil)
+	buff.Reset()
+	err = outputs.WriteCSV(buff)
+	so(err, isNil)
+	so(buff.String(), eq, "collect,a,output\n,= 1,plain\n,\"= \"\"x y\"\"\",\"\"\"true\"\"\"\n,= $b,\"{\"\"k\"\":\"\"v\"\"}\"\n")
+	again, err = ReadDecisionTableCSV(strings.NewReader(buff.String()))
+	so(err, isNil)
+	so(again.Definition().Rows, convey.ShouldResemble, []TableRow{
+		{When: []any{"= 1"}, Output: "plain"},
+		{When: []any{`= "x y"`}, Output: "true"},
+		{When: []any{"= $b"}, Output: map[string]any{"k": "v"}},
+	})
+
+	// 大于 2^53 的整数不能丢失精度
+	const big = 9007199254740993
+	bigJSON, err := UnmarshalDecisionTable([]byte(`{"hit_policy":"first","inputs":["id"],
+		"rows":[{"when":[9007199254740993],"output":18446744073709551615},{"when":[null],"output":0}]}`))
+	so(err, isNil)
+	bigYAML, err := UnmarshalDecisionTableYAML([]byte(`
+hit_policy: first
+inputs: [id]
+rows:
+  - {when: [9007199254740993], output: 18446744073709551615}
+  - {when: [~], output: 0}
+`))
+	so(err, isNil)
+	so(bigYAML.Definition(), convey.ShouldResemble, bigJSON.Definition())
+	bigCSV, err := ReadDecisionTableCSV(strings.NewReader("F,id,output\n,9007199254740993,18446744073709551615\n,-,0\n"))
+	so(err, isNil)
+	for _, dt := range []*DecisionTable{bigJSON, bigYAML, bigCSV} {
+		res, err := dt.Evaluate(jsonvalue.MustUnmarshalString(`{"id":9007199254740993}`))
+		so(err, isNil)
+		so(res.Output, eq, uint64(18446744073709551615))
+		res, err = dt.Evaluate(jsonvalue.MustUnmarshalString(`{"id":9007199254740992}`))
+		so(err, isNil)
+		so(res.Output, eq, int64(0))
+	}
+	so(bigJSON.Definition().Rows[0].When[0], eq, int64(big))
+
+	// 编译后的决策表也可以直接序列化
+	b, err := json.Marshal(fromJSON)
+	so(err, isNil)
+	dt := &DecisionTable{}
+	err = json.Unmarshal(b, dt)
+	so(err, isNil)
+	so(dt.Definition(), convey.ShouldResemble, fromJSON.Definition())
+
+	y, err := yaml.Marshal(fromJSON)
+	so(err, isNil)
+	dt = &DecisionTable{}
+	err = yaml.Unmarshal(y, dt)
+	so(err, isNil)
+	so(dt.Definition(), convey.ShouldResemble, fromJSON.Definition())
+}
+
+func testDecisionTableIllegal(t *testing.T) {
+	for _, s := range []string{
+		`{"hit_policy":"random","inputs":["a"],"rows":[]}`,
+		`{"hit_policy":"first","aggregation":"sum","inputs":["a"],"rows":[]}`,
+		`{"hit_policy":"C+","aggregation":"max","inputs":["a"],"rows":[]}`,
+		`{"hit_policy":"collect","aggregation":"avg","inputs":["a"],"rows":[]}`,
+		`{"inputs":[],"rows":[]}`,
+		`{"inputs":["a"],"rows":[]}`,
+		`{"inputs":["a"],"rows":[{"when":[1,2]}]}`,
+		`{"inputs":["a"],"rows":[{"when":[">= abc"]}]}`,
+		`{"inputs":["a"],"rows":[{"when":["> 'x'"]}]}`,
+		`{"inputs":["a"],"rows":[{"when":["> 1 and < 2"]}]}`,
+		`{"inputs":["a"],"rows":[{"when":["between (1, 2]"]}]}`,
+		`{"inputs":["a"],"rows":[{"when":[[">", 1, 2]]}]}`,
+		`{"inputs":["a"],"rows":[{"when":[[1]]}]}`,
+		`{"inputs":["a.[x]"],"rows":[{"when":[1]}]}`,
+		`{"hit_policy":"priority","inputs":["a"],"rows":[{"when":[1],"output":"x"}]}`,
+		`{"hit_policy":"priority","inputs":["a"],"rows":[{"when":[1],"output":"x"}],"output_order":["y"]}`,
+		`{"hit_policy":"C<","inputs":["a"],"rows":[{"when":[1],"output":"x"}]}`,
+		`{"inputs":["a"],"rows":[{"when":["in [1,2]"]},{"when":["= 2"]}]`,
+	} {
+		t.Log(s)
+		_, err := UnmarshalDecisionTable([]byte(s))
+		so(err, isErr)
+	}
+
+	_, err := NewDecisionTable(TableDefinition{Inputs: []string{"a"}}, OptDateTimeFormat("abc"))
+	so(errors.Is(err, ErrIllegalOption), eq, true)
+
+	for _, s := range []string{
+		"",
+		"U,a\n1,2\n",
+		"U,a,out\n1,> 'x',2\n",
+		"U,a,out\n1,2\n",
+		"U,a,out\n",
+	} {
+		t.Log(s)
+		_, err := ReadDecisionTableCSV(strings.NewReader(s))
+		so(err, isErr)
+	}
+	_, err = ReadDecisionTableCSV(strings.NewReader("U,a,out\n"))
+	so(err.Error(), convey.ShouldEndWith, "decision table has no rows")
+	_, err = UnmarshalDecisionTableYAML([]byte("inputs: ["))
+	so(err, isErr)
+}
+
+func TestRuleIndex(t *testing.T) {
+	cv("match", t, func() { testRuleIndexMatch(t) })
+	cv("candidates", t, func() { testRuleIndexCandidates(t) })
+	cv("illegal rules", t, func() { testRuleIndexIllegal(t) })
+}
+
+const testRuleIndexJSON = `[
+	{"id":"cn","condition":["country","=","CN"]},
+	{"id":"na","condition":["country","in",["US","CA"]]},
+	{"id":"admin","condition":["email","istartswith","ADMIN@"]},
+	{"id":"gold","priority":5,"condition":{"and":[["amount",">",100],["tier","=","gold"]]}},
+	{"id":"hot","condition":{"or":[["tags.[0]","=","hot"],["sku","startswith","A-"]]}},
+	{"id":"big","condition":["amount",">",1000]},
+	{"id":"not-cn","condition":{"not":["country","=","CN"]}},
+	{"id":"cn-or-big","condition":{"or":[["country","=","CN"],["amount",">",5000]]}},
+	{"id":"two-items","condition":["items","len =",2]},
+	{"id":"pointer","condition":{"field":"/items/0/sku","syntax":"pointer","op":"=","value":"A-1"}},
+	{"id":"one","priority":-1,"condition":["amount","=",1]},
+	{"id":"vip","condition":["vip","=",true]},
+	{"id":"no-note","condition":["note","=",null]},
+	{"id":"empty-in","condition":["country","in",[]]},
+	{"id":"disabled","priority":100,"disabled":true,"condition":["country","=","CN"]}
+]`
+
+func testRuleIndexMatch(t *testing.T) {
+	rules := []Rule{}
+	so(json.Unmarshal([]byte(testRuleIndexJSON), &rules), isNil)
+
+	ri, err := NewRuleIndex(rules, OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+	so(len(ri.Rules()), eq, len(rules))
+
+	rs, err := NewRuleSet(rules, OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+
+	docs := []string{
+		`{"country":"CN","amount":1.0,"vip":true,"note":null}`,
+		`{"country":"US","amount":200,"tier":"gold","email":"Admin@example.com"}`,
+		`{"country":"CA","amount":6000,"tags":["hot","new"],"items":[{"sku":"A-1"},{"sku":"B-2"}]}`,
+		`{"country":"JP","sku":"A-2","email":"user@example.com","items":{"0":{"sku":"A-1"}}}`,
+		`{"country":1,"amount":"1","tier":"GOLD","vip":"true","note":"n"}`,
+		`{"email":"admin","items":[],"tags":"hot"}`,
+		`{}`,
+		`[]`,
+		`"CN"`,
+	}
+	for _, s := range docs {
+		t.Log(s)
+		doc := jsonvalue.MustUnmarshalString(s)
+
+		// 结果与逐条匹配完全相同
+		var expected []string
+		for _, r := range rs.Evaluate(doc) {
+			if r.Matched {
+				expected = append(expected, r.ID)
+			}
+		}
+		got, err := ri.Match(doc)
+		so(err, isNil)
+		so(got, convey.ShouldResemble, expected)
+	}
+
+	got, err := ri.Match(jsonvalue.MustUnmarshalString(docs[0]))
+	so(err, isNil)
+	so(got, convey.ShouldResemble, []string{"cn", "cn-or-big", "vip", "no-note", "one"})
+
+	got, err = ri.Match(jsonvalue.MustUnmarshalString(docs[2]))
+	so(err, isNil)
+	so(got, convey.ShouldResemble, []string{"na", "hot", "big", "not-cn", "cn-or-big", "two-items", "pointer"})
+
+	// 匹配出错的规则视为未命中
+	ri, err = NewRuleIndex(rules)
+	so(err, isNil)
+	got, err = ri.Match(jsonvalue.MustUnmarshalString(`{"country":"CN"}`))
+	so(err, isNil)
+	so(got, convey.ShouldResemble, []string{"cn", "cn-or-big"})
+
+	_, err = ri.Match(make(chan int))
+	so(err, isErr)
+}
+
+func testRuleIndexCandidates(t *testing.T) {
+	rules := []Rule{}
+	so(json.Unmarshal([]byte(testRuleIndexJSON), &rules), isNil)
+	ri, err := NewRuleIndex(rules)
+	so(err, isNil)
+
+	ids := func(idx []int) []string {
+		var s []string
+		for _, i := range idx {
+			s = append(s, ri.rs.sorted[i].rule.ID)
+		}
+		return s
+	}
+
+	// 只有 big、not-cn 和 cn-or-big 没有必要条件
+	so(ids(ri.always), convey.ShouldResemble, []string{"big", "not-cn", "cn-or-big"})
+	so(len(ri.paths), eq, 10)
+
+	doc := jsonvalue.MustUnmarshalString(`{"country":"JP","amount":2000}`)
+	so(ids(ri.candidates(doc)), convey.ShouldResemble, []string{"big", "not-cn", "cn-or-big"})
+
+	doc = jsonvalue.MustUnmarshalString(`{"country":"CN","tier":"gold","email":"admin@a.com","sku":"A-","tags":["hot"]}`)
+	so(ids(ri.candidates(doc)), convey.ShouldResemble, []string{
+		"gold", "cn", "admin", "hot", "big", "not-cn", "cn-or-big",
+	})
+}
+
+func testRuleIndexIllegal(t *testing.T) {
+	_, err := NewRuleIndex([]Rule{{ID: "a", Condition: Condition{Expr: Expr{Field: "a", Operator: "=="}}}, {ID: "a"}})
+	so(err, isErr)
+	so(errors.Is(err, ErrIllegalRule), eq, true)
+
+	_, err = NewRuleIndex([]Rule{{ID: "a", Condition: Condition{Expr: Expr{Field: "a", Operator: "=>"}}}})
+	so(err, isErr)
+	so(errors.Is(err, ErrIllegalOperator), eq, true)
+}
+
+func TestBatch(t *testing.T) {
+	cv("array", t, func() { testBatchArray(t) })
+	cv("NDJSON", t, func() { testBatchNDJSON(t) })
+}
+
+func testBatchArray(t *testing.T) {
+	cond := Condition{Expr: Expr{Field: "age", Operator: ">=", Value: 18}}
+	opt := OptWhenNotFound(ReturnFalse)
+
+	raw := []any{
+		map[string]any{"name": "a", "age": 17},
+		map[string]any{"name": "b", "age": 18},
+		map[string]any{"name": "c"},
+		map[string]any{"name": "d", "age": 30},
+	}
+
+	res, err := Filter(raw, cond, opt)
+	so(err, isNil)
+	so(res, convey.ShouldResemble, []any{raw[1], raw[3]})
+
+	matched, unmatched, err := Partition(raw, cond, opt)
+	so(err, isNil)
+	so(matched, convey.ShouldResemble, []any{raw[1], raw[3]})
+	so(unmatched, convey.ShouldResemble, []any{raw[0], raw[2]})
+
+	n, err := Count(raw, cond, opt)
+	so(err, isNil)
+	so(n, eq, 2)
+
+	elem, i, err := FindFirst(raw, cond, opt)
+	so(err, isNil)
+	so(i, eq, 1)
+	so(elem, convey.ShouldResemble, raw[1])
+
+	// *jsonvalue.V 返回的元素也是 *jsonvalue.V
+	arr := jsonvalue.MustUnmarshalString(`[{"age":10},{"age":20},{"age":30}]`)
+	res, err = Filter(arr, cond)
+	so(err, isNil)
+	so(len(res), eq, 2)
+	so(res[0], eq, arr.MustGet(1))
+	so(res[1].(*jsonvalue.V).MustMarshalString(), eq, `{"age":30}`)
+
+	// 其他类型的切片
+	typed := []map[string]int{{"age": 1}, {"age": 99}}
+	elem, i, err = FindFirst(typed, cond)
+	so(err, isNil)
+	so(i, eq, 1)
+	so(elem, convey.ShouldResemble, map[string]int{"age": 99})
+
+	elem, i, err = FindFirst([]any{}, cond)
+	so(err, isNil)
+	so(i, eq, -1)
+	so(elem, isNil)
+
+	res, err = Filter([0]int{}, cond)
+	so(err, isNil)
+	so(res, isNil)
+
+	// 出错
+	_, err = Filter(raw, cond)
+	so(err, isErr)
+	so(errors.Is(err, ErrNotFound), eq, true)
+	so(err.Error(), convey.ShouldStartWith, "match element 2 error")
+
+	// FindFirst 找到之后不再匹配后面的元素
+	_, i, err = FindFirst(raw[1:], cond)
+	so(err, isNil)
+	so(i, eq, 0)
+
+	for _, v := range []any{nil, "[1]", jsonvalue.NewObject(), map[string]any{}} {
+		_, err = Count(v, cond)
+		so(err, isErr)
+		so(errors.Is(err, ErrTypeNotMatch), eq, true)
+	}
+
+	_, err = Count(raw, Condition{Expr: Expr{Field: "age", Operator: "=>"}})
+	so(err, isErr)
+}
+
+func testBatchNDJSON(t *testing.T) {
+	cond := Condition{Expr: Expr{Field: "level", Operator: "in", Value: []any{"warn", "error"}}}
+
+	in := strings.Join([]string{
+		`{"level":"info","msg":"a"}`,
+		`{"level":"warn", "msg":"b"}`,
+		``,
+		`  {"level":"error","msg":"c"}  ` + "\r",
+		`{"level":"debug","msg":"d"}`,
+		`{"level":"error","msg":"e"}`,
+	}, "\n")
+
+	out := &strings.Builder{}
+	n, err := FilterReader(strings.NewReader(in), out, cond)
+	so(err, isNil)
+	so(n, eq, 3)
+	so(out.String(), eq, `{"level":"warn", "msg":"b"}`+"\n"+
+		`  {"level":"error","msg":"c"}  `+"\n"+
+		`{"level":"error","msg":"e"}`+"\n")
+
+	out.Reset()
+	n, err = FilterReader(strings.NewReader(""), out, cond)
+	so(err, isNil)
+	so(n, eq, 0)
+	so(out.String(), eq, "")
+
+	// 多个 worker 时输出的顺序与输入相同
+	lines := make([]string, 0, 5000)
+	expected := strings.Builder{}
+	for i := 0; i < cap(lines); i++ {
+		level := []string{"info", "warn", "error", "debug"}[i%4]
+		s := fmt.Sprintf(`{"level":"%s","seq":%d}`, level, i)
+		lines = append(lines, s)
+		if level == "warn" || level == "error" {
+			expected.WriteString(s + "\n")
+		}
+	}
+	in = strings.Join(lines, "\n") + "\n"
+
+	for _, workers := range []int{1, 4, 0} {
+		out.Reset()
+		n, err = FilterReader(strings.NewReader(in), out, cond, OptWorkers(workers))
+		so(err, isNil)
+		so(n, eq, 2500)
+		so(out.String(), eq, expected.String())
+	}
+
+	// 出错时停止, 之前满足条件的行已经写入
+	lines[1000] = `{"level":`
+	in = strings.Join(lines, "\n")
+	for _, workers := range []int{1, 4} {
+		out.Reset()
+		n, err = FilterReader(strings.NewReader(in), out, cond, OptWorkers(workers))
+		so(err, isErr)
+		so(err.Error(), convey.ShouldStartWith, "line 1001: ")
+		so(n, eq, 500)
+		so(strings.Count(out.String(), "\n"), eq, 500)
+	}
+
+	lines[1000] = `{"msg":"no level"}`
+	in = strings.Join(lines, "\n")
+	_, err = FilterReader(strings.NewReader(in), io.Discard, cond, OptWorkers(4))
+	so(err, isErr)
+	so(errors.Is(err, ErrNotFound), eq, true)
+	so(err.Error(), convey.ShouldStartWith, "line 1001: match error")
+
+	// 出错后不再继续读取剩余的输入
+	r := strings.NewReader(strings.Repeat(in+"\n", 10))
+	_, err = FilterReader(r, io.Discard, cond, OptWorkers(4))
+	so(err, isErr)
+	so(r.Len(), convey.ShouldBeGreaterThan, 0)
+
+	n, err = FilterReader(strings.NewReader(in), io.Discard, cond, OptWorkers(4), OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+	so(n, eq, 2500)
+
+	_, err = FilterReader(strings.NewReader(in), io.Discard, Condition{Expr: Expr{Field: "a", Operator: "=>"}})
+	so(err, isErr)
+}
+
+func TestExplain(t *testing.T) {
+	cv("Explain", t, func() { testExplain(t) })
 }
 
 type testCase struct {
@@ -363,5 +2168,978 @@ func testSQLStyleExpr(t *testing.T) {
 			opts:   []Option{OptDateTimeFormat("01-02")},
 			expect: true,
 		},
+		// ≶ 和 ≷ 表示小于或者大于, 即不相等, 同一时刻的不同写法也是相等的
+		{
+			value:  `{"time":"2024-01-01T08:00:00+08:00"}`,
+			cond:   `["time","≶","2024-01-01T00:00:00Z"]`,
+			opts:   []Option{OptDateTimeFormat(time.RFC3339)},
+			expect: false,
+		},
+		{
+			value:  `{"time":"2024-01-01"}`,
+			cond:   `["time","≷","2024-01-01"]`,
+			opts:   []Option{OptDateTimeFormat(time.DateOnly)},
+			expect: false,
+		},
+		{
+			value:  `{"time":"2024-01-01"}`,
+			cond:   `["time","≶","2024-01-02"]`,
+			opts:   []Option{OptDateTimeFormat(time.DateOnly)},
+			expect: true,
+		},
+	})
+
+	// 大于 2^53 的整数不能丢失精度
+	iterateTestCases(t, "large integer", []testCase{
+		{
+			value:  `{"id":9007199254740992}`,
+			cond:   `["id","=",9007199254740993]`,
+			expect: false,
+		},
+		{
+			value:  `{"id":9007199254740993}`,
+			cond:   `["id","=",9007199254740993]`,
+			expect: true,
+		},
+		{
+			value:  `{"id":9007199254740992}`,
+			cond:   `{"field":"id","op":"=","value":9007199254740993}`,
+			expect: false,
+		},
+		{
+			value:  `{"id":9007199254740992}`,
+			cond:   `["id","in",[9007199254740993,18446744073709551615]]`,
+			expect: false,
+		},
+		{
+			value:  `{"id":18446744073709551615}`,
+			cond:   `["id","in",[9007199254740993,18446744073709551615]]`,
+			expect: true,
+		},
+	})
+
+	c := Condition{}
+	err := json.Unmarshal([]byte(`["id","=",9007199254740993]`), &c)
+	so(err, isNil)
+	so(c.Value, eq, int64(9007199254740993))
+	so(c.String(), eq, "id = 9007199254740993")
+	b, err := json.Marshal(c)
+	so(err, isNil)
+	so(string(b), eq, `["id","=",9007199254740993]`)
+
+	parsed, err := Parse("id = 9007199254740993")
+	so(err, isNil)
+	so(parsed, convey.ShouldResemble, c)
+
+	// 与之前的版本不兼容: 整数解析为 int64, 与 float64 的文档按照数值比较, 但是已经丢失了精度的 float64 不再相等
+	err = json.Unmarshal([]byte(`["age",">=",18]`), &c)
+	so(err, isNil)
+	so(c.Value, eq, int64(18))
+	var doc any
+	err = json.Unmarshal([]byte(`{"age":18,"id":9007199254740993}`), &doc)
+	so(err, isNil)
+	matched, err := Match(doc, c)
+	so(err, isNil)
+	so(matched, eq, true)
+	matched, err = Match(doc, Condition{Expr: Expr{Field: "id", Operator: "=", Value: int64(9007199254740993)}})
+	so(err, isNil)
+	so(matched, eq, false)
+	matched, err = Match(doc, Condition{Expr: Expr{Field: "id", Operator: "=", Value: float64(9007199254740993)}})
+	so(err, isNil)
+	so(matched, eq, true)
+}
+
+func testStringOperators(t *testing.T) {
+	value := `{"email":"Alice@Example.com","path":"/api/v2/users","arr":[{"name":"foo_bar"},{"name":"50%"}],"int":1}`
+
+	iterateTestCases(t, "contains, prefix and suffix", []testCase{
+		{value: value, cond: `["email","contains","@Example"]`, expect: true},
+		{value: value, cond: `["email","contains","@example"]`, expect: false},
+		{value: value, cond: `["email","icontains","@example"]`, expect: true},
+		{value: value, cond: `["email","not contains","@example"]`, expect: true},
+		{value: value, cond: `["email","!icontains","@example"]`, expect: false},
+		{value: value, cond: `["email","endswith","@Example.com"]`, expect: true},
+		{value: value, cond: `["email","suffix","@example.com"]`, expect: false},
+		{value: value, cond: `["email","iendswith","@example.COM"]`, expect: true},
+		{value: value, cond: `["email","notendswith","@example.com"]`, expect: true},
+		{value: value, cond: `["path","startswith","/api/"]`, expect: true},
+		{value: value, cond: `["path","prefix","/API/"]`, expect: false},
+		{value: value, cond: `["path","iprefix","/API/"]`, expect: true},
+		{value: value, cond: `["path","not iprefix","/API/"]`, expect: false},
+		{value: value, cond: `["int","contains","1"]`, shouldErr: true},
+		{value: value, cond: `["int","contains","1"]`, opts: []Option{OptWhenTypeMismatch(ReturnFalse)}, expect: false},
+	})
+
+	iterateTestCases(t, "regex", []testCase{
+		{value: value, cond: `["path","regex","^/api/v[0-9]+/"]`, expect: true},
+		{value: value, cond: `["path","=~","^/API/v[0-9]+/"]`, expect: false},
+		{value: value, cond: `["path","iregex","^/API/v[0-9]+/"]`, expect: true},
+		{value: value, cond: `["path","~*","^/API/v[0-9]+/"]`, expect: true},
+		{value: value, cond: `["path","!~","^/api/v[0-9]+/"]`, expect: false},
+		{value: value, cond: `["path","not regex","^/api/v[a-z]+/"]`, expect: true},
+		{value: value, cond: `{"field":"arr.[*].name","op":"regex","value":"^[a-z_0-9%]+$"}`, expect: true},
+	})
+
+	iterateTestCases(t, "like and glob", []testCase{
+		{value: value, cond: `["email","like","%@Example.com"]`, expect: true},
+		{value: value, cond: `["email","like","%@example.com"]`, expect: false},
+		{value: value, cond: `["email","ilike","%@example.com"]`, expect: true},
+		{value: value, cond: `["email","like","Alic_@%"]`, expect: true},
+		{value: value, cond: `["email","like","Alic_"]`, expect: false},
+		{value: value, cond: `["email","not like","Bob%"]`, expect: true},
+		{value: value, cond: `["arr.[+].name","like","foo\\_bar"]`, expect: true},
+		{value: value, cond: `["arr.[+].name","like","foo\\_"]`, expect: false},
+		{value: value, cond: `["arr.[+].name","like","%\\%"]`, expect: true},
+		{value: value, cond: `["path","glob","/api/*/users"]`, expect: true},
+		{value: value, cond: `["path","glob","/api/v?/users"]`, expect: true},
+		{value: value, cond: `["path","glob","/api/v?"]`, expect: false},
+		{value: value, cond: `["path","iglob","/API/*"]`, expect: true},
+		{value: value, cond: `["path","!glob","/API/*"]`, expect: true},
+	})
+}
+
+func testPresenceOperators(t *testing.T) {
+	value := `{"str":"","null":null,"obj":{},"arr":[],"items":[{"sku":"A","qty":1},{"sku":null,"qty":2},{"qty":3}]}`
+
+	iterateTestCases(t, "exists", []testCase{
+		{value: value, cond: `["str","exists"]`, expect: true},
+		{value: value, cond: `["null","exists"]`, expect: true},
+		{value: value, cond: `["none","exists"]`, expect: false},
+		{value: value, cond: `["none.sub.[1]","exists"]`, expect: false},
+		{value: value, cond: `["none","exists",false]`, expect: true},
+		{value: value, cond: `["none","notexists"]`, expect: true},
+		{value: value, cond: `["str","not exists"]`, expect: false},
+		{value: value, cond: `["str.sub","exists"]`, expect: false},
+		{value: value, cond: `["arr.[0]","exists"]`, expect: false},
+		{value: value, cond: `["items.[-1]","exists"]`, expect: true},
+		{value: value, cond: `["items.[*].sku","exists"]`, expect: false},
+		{value: value, cond: `["items.[+].sku","notexists"]`, expect: true},
+		{value: value, cond: `["items.[*].qty","exists"]`, expect: true},
+		{value: value, cond: `{"field":"items.[*].qty","op":"exists","value":true}`, expect: true},
+	})
+
+	iterateTestCases(t, "isnull", []testCase{
+		{value: value, cond: `["null","isnull"]`, expect: true},
+		{value: value, cond: `["str","isnull"]`, expect: false},
+		{value: value, cond: `["none","isnull"]`, expect: false},
+		{value: value, cond: `["none","notnull"]`, expect: true},
+		{value: value, cond: `["items.[+].sku","isnull"]`, expect: true},
+		{value: value, cond: `["items.[0].sku","notnull"]`, expect: true},
+	})
+
+	iterateTestCases(t, "empty", []testCase{
+		{value: value, cond: `["str","empty"]`, expect: true},
+		{value: value, cond: `["null","empty"]`, expect: true},
+		{value: value, cond: `["obj","empty"]`, expect: true},
+		{value: value, cond: `["arr","empty"]`, expect: true},
+		{value: value, cond: `["none","empty"]`, expect: true},
+		{value: value, cond: `["items","empty"]`, expect: false},
+		{value: value, cond: `["items","notempty"]`, expect: true},
+		{value: value, cond: `["items.[0].qty","empty"]`, expect: false},
+		{value: value, cond: `["items.[*].sku","notempty"]`, expect: false},
+	})
+
+	iterateTestCases(t, "type", []testCase{
+		{value: value, cond: `["str","type","string"]`, expect: true},
+		{value: value, cond: `["str","type","number"]`, expect: false},
+		{value: value, cond: `["null","type","null"]`, expect: true},
+		{value: value, cond: `["obj","type","OBJECT"]`, expect: true},
+		{value: value, cond: `["arr","type",["object","array"]]`, expect: true},
+		{value: value, cond: `["none","type","string"]`, expect: false},
+		{value: value, cond: `["items.[*].qty","type","number"]`, expect: true},
+		{value: value, cond: `["items.[*].sku","type","string"]`, expect: false},
+		{value: value, cond: `["items.[+].sku","not type","string"]`, expect: true},
+	})
+}
+
+func testSetOperators(t *testing.T) {
+	value := `{"roles":["admin","dev",1],"empty":[],"role":"admin","groups":[{"tags":["a","b"]},{"tags":["c"]}]}`
+
+	iterateTestCases(t, "in and nin", []testCase{
+		{value: value, cond: `["role","in",["admin","owner"]]`, expect: true},
+		{value: value, cond: `["role","nin",["admin","owner"]]`, expect: false},
+		{value: value, cond: `["role","not in",["owner"]]`, expect: true},
+		{value: value, cond: `["role","not_in",["owner"]]`, expect: true},
+		{value: value, cond: `["roles.[*]","!in",["owner"]]`, expect: true},
+	})
+
+	iterateTestCases(t, "set", []testCase{
+		{value: value, cond: `["roles","containsany",["owner","admin"]]`, expect: true},
+		{value: value, cond: `["roles","contains_any",["owner"]]`, expect: false},
+		{value: value, cond: `["roles","not containsany",["owner"]]`, expect: true},
+		{value: value, cond: `["roles","disjoint",["owner"]]`, expect: true},
+		{value: value, cond: `["roles","disjoint",["owner", 1]]`, expect: false},
+		{value: value, cond: `["roles","containsall",["dev","admin"]]`, expect: true},
+		{value: value, cond: `["roles","contains_all",["dev","owner"]]`, expect: false},
+		{value: value, cond: `["roles","supersetof",[1,"dev"]]`, expect: true},
+		{value: value, cond: `["roles","subsetof",[1,"dev","admin","owner"]]`, expect: true},
+		{value: value, cond: `["roles","subset_of",["dev","admin"]]`, expect: false},
+		{value: value, cond: `["roles","seteq",["dev",1,"admin","admin"]]`, expect: true},
+		{value: value, cond: `["roles","seteq",["dev",1]]`, expect: false},
+		{value: value, cond: `["empty","subsetof",["a"]]`, expect: true},
+		{value: value, cond: `["empty","containsany",["a"]]`, expect: false},
+		{value: value, cond: `["roles","containsall",[]]`, expect: true},
+		{value: value, cond: `["groups.[+].tags","containsall",["a","b"]]`, expect: true},
+		{value: value, cond: `["groups.[*].tags","containsany",["a","c"]]`, expect: true},
+		{value: value, cond: `["role","containsany",["admin"]]`, shouldErr: true},
+		{value: value, cond: `["role","containsany",["admin"]]`, opts: []Option{OptWhenTypeMismatch(ReturnFalse)}, expect: false},
+	})
+}
+
+func testLength(t *testing.T) {
+	value := `{"name":"你好, world","obj":{"a":1,"b":2},"items":[{"tags":["a","b"]},{"tags":["c"]},{"tags":[]}],"int":1}`
+
+	iterateTestCases(t, "# suffix", []testCase{
+		{value: value, cond: `["name.#","=",9]`, expect: true},
+		{value: value, cond: `["name.#","<=",64]`, expect: true},
+		{value: value, cond: `["obj.#","=",2]`, expect: true},
+		{value: value, cond: `["items.#",">=",3]`, expect: true},
+		{value: value, cond: `["items.#",">",3]`, expect: false},
+		{value: value, cond: `["items.[+].tags.#","=",2]`, expect: true},
+		{value: value, cond: `["items.[*].tags.#",">=",1]`, expect: false},
+		{value: value, cond: `["items.[*].tags.#","in",[0,1,2]]`, expect: true},
+		{value: value, cond: `["int.#","=",1]`, shouldErr: true},
+		{value: value, cond: `["none.#","=",1]`, shouldErr: true},
+		{value: value, cond: `["none.#","=",1]`, opts: []Option{OptWhenNotFound(ReturnFalse)}, expect: false},
+	})
+
+	iterateTestCases(t, "len operators", []testCase{
+		{value: value, cond: `["name","len=",9]`, expect: true},
+		{value: value, cond: `["name","len <=",8]`, expect: false},
+		{value: value, cond: `["items","len>=",3]`, expect: true},
+		{value: value, cond: `["items","len_gt",3]`, expect: false},
+		{value: value, cond: `["items.[+].tags","len=",0]`, expect: true},
+		{value: value, cond: `["items.[*].tags","len in",[1,2]]`, expect: false},
+		{value: value, cond: `["items.[*].tags","len not in",[3]]`, expect: true},
+		{value: value, cond: `["obj","len!=",2]`, expect: false},
+	})
+}
+
+func testBetween(t *testing.T) {
+	value := `{"int":10,"items":[{"price":5,"qty":1},{"price":25,"qty":2}],"time":"2024-01-31","name":"abc"}`
+
+	iterateTestCases(t, "numbers", []testCase{
+		{value: value, cond: `["int","between",[10,20]]`, expect: true},
+		{value: value, cond: `["int","between",[1,10]]`, expect: true},
+		{value: value, cond: `["int","range",[11,20]]`, expect: false},
+		{value: value, cond: `["int","between","(10,20]"]`, expect: false},
+		{value: value, cond: `["int","between","[10, 20)"]`, expect: true},
+		{value: value, cond: `["int","between","[1,10)"]`, expect: false},
+		{value: value, cond: `["int","between","(-1.5,10.5)"]`, expect: true},
+		{value: value, cond: `["int","not between",[11,20]]`, expect: true},
+		{value: value, cond: `["items.[+].price","between",[10,20]]`, expect: false},
+		{value: value, cond: `{"and":[["items.[+].price",">=",10],["items.[+].price","<=",20]]}`, expect: true},
+		{value: value, cond: `["items.[*].price","between",[5,25]]`, expect: true},
+		{value: value, cond: `["name","len between",[1,3]]`, expect: true},
+		{value: value, cond: `["name","between",[1,3]]`, shouldErr: true},
+	})
+
+	opts := []Option{OptDateTimeFormat(time.DateOnly)}
+	iterateTestCases(t, "time", []testCase{
+		{value: value, cond: `["time","between",["2024-01-01","2024-01-31"]]`, opts: opts, expect: true},
+		{value: value, cond: `["time","between","[2024-01-01,2024-01-31)"]`, opts: opts, expect: false},
+		{value: value, cond: `["time","between","(2024-01-31,2024-02-29]"]`, opts: opts, expect: false},
+		{value: value, cond: `["time","between","[2024-01-31,2024-02-29]"]`, opts: opts, expect: true},
+	})
+
+	// 时间格式中包含逗号时, 使用两侧都是合法时间的那一个逗号分隔
+	comma := []Option{OptDateTimeFormat("Jan 2, 2006")}
+	iterateTestCases(t, "time format with comma", []testCase{
+		{value: `{"d":"Jan 15, 2024"}`, cond: `["d","between","[Jan 1, 2024, Feb 1, 2024)"]`, opts: comma, expect: true},
+		{value: `{"d":"Feb 1, 2024"}`, cond: `["d","between","[Jan 1, 2024, Feb 1, 2024)"]`, opts: comma, expect: false},
+		{value: `{"d":"Feb 1, 2024"}`, cond: `["d","between","[Jan 1, 2024,Feb 1, 2024]"]`, opts: comma, expect: true},
+	})
+}
+
+func testReference(t *testing.T) {
+	value := `{
+		"order":{"date":"2024-01-02","total":100,"currency":"USD"},
+		"shipping":{"date":"2024-01-03"},
+		"discount":20,
+		"currencies":["USD","EUR"],
+		"items":[{"qty":1,"max":2,"price":10},{"qty":5,"max":3,"price":10}]
+	}`
+	opts := []Option{OptDateTimeFormat(time.DateOnly)}
+
+	iterateTestCases(t, "root reference", []testCase{
+		{value: value, cond: `{"field":"shipping.date","op":">=","ref":"order.date"}`, opts: opts, expect: true},
+		{value: value, cond: `{"field":"shipping.date","op":"<","ref":"$order.date"}`, opts: opts, expect: false},
+		{value: value, cond: `{"field":"discount","op":"<","ref":"$order.total"}`, expect: true},
+		{value: value, cond: `{"field":"discount","op":"<","ref":"$.order.total"}`, expect: true},
+		{value: value, cond: `{"field":"order.currency","op":"in","ref":"$currencies"}`, expect: true},
+		{value: value, cond: `{"field":"currencies.#","op":"<","ref":"$items.[0].max"}`, expect: false},
+		{value: value, cond: `{"field":"items.[-1].price","op":"=","ref":"$items.[0].price"}`, expect: true},
+		{value: value, cond: `["discount","<",{"ref":"$order.total"}]`, expect: true},
+		{value: value, cond: `["order.currency","=",{"ref":"currencies.[0]"}]`, expect: true},
+		{value: value, cond: `["order.currency","=",{"ref":"$none"}]`, shouldErr: true},
+		// 其他 object 均为字面量
+		{value: value, cond: `["order",">=",{"ref":"$order","x":1}]`, shouldErr: true},
+		// SQL 风格中的字符串总是字面量, 不会被当作引用
+		{value: value, cond: `["order.currency","=","$USD"]`, expect: false},
+		{value: value, cond: `["order.currency","=","$order.currency"]`, expect: false},
+		{value: value, cond: `{"not":["order.currency","=","@.currency"]}`, expect: true},
+		{value: value, cond: `{"field":"discount","op":"<","ref":"$none"}`, shouldErr: true},
+		{value: value, cond: `{"field":"discount","op":"<","ref":"$none"}`, opts: []Option{OptWhenNotFound(ReturnFalse)}, expect: false},
+		{value: value, cond: `{"field":"discount","op":"in","ref":"$order.total"}`, shouldErr: true},
+		{value: value, cond: `{"field":"discount","op":"in","ref":"$order.total"}`, opts: []Option{OptWhenTypeMismatch(ReturnFalse)}, expect: false},
 	})
+
+	iterateTestCases(t, "element reference", []testCase{
+		{value: value, cond: `{"field":"items.[*].qty","op":"<=","ref":"@.max"}`, expect: false},
+		{value: value, cond: `{"field":"items.[+].qty","op":">","ref":"@.max"}`, expect: true},
+		{value: value, cond: `{"field":"items.[0].qty","op":"<=","ref":"@.max"}`, expect: true},
+		{value: value, cond: `["items.[+].qty",">","@.max"]`, shouldErr: true},
+		{value: value, cond: `["items.[+].qty",">",{"ref":"@.max"}]`, expect: true},
+		{value: value, cond: `{"field":"items.[*].price","op":"<","ref":"$discount"}`, expect: true},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`{"field":"items.[+].qty","op":">","ref":"@.max"}`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	t.Log(e.String())
+	so(e.Ref, eq, "@.max")
+	so(string(e.Resolved[1].Target), eq, "3")
+}
+
+func testElementCondition(t *testing.T) {
+	value := `{
+		"items":[{"sku":"A","qty":1},{"sku":"B","qty":10},{"sku":"A","qty":3,"max":2}],
+		"orders":[{"items":[{"sku":"A"}]},{"items":[{"sku":"B"},{"sku":"A"}]}],
+		"empty":[],
+		"str":"abc"
+	}`
+
+	iterateTestCases(t, "any", []testCase{
+		{value: value, cond: `{"and":[["items.[+].sku","=","A"],["items.[+].qty",">",5]]}`, expect: true},
+		{value: value, cond: `{"field":"items","any":{"and":[["sku","=","A"],["qty",">",5]]}}`, expect: false},
+		{value: value, cond: `{"field":"items","any":{"and":[["sku","=","B"],["qty",">",5]]}}`, expect: true},
+		{value: value, cond: `{"field":"items","any":{"or":[["sku","=","C"],{"not":["qty","<",10]}]}}`, expect: true},
+		{value: value, cond: `{"field":"items","any":{"field":"qty","op":">","ref":"@.max"}}`, expect: true},
+		{value: value, cond: `{"field":"items","any":["qty",">",5]}`, opts: []Option{OptWhenNotFound(ReturnFalse)}, expect: true},
+		{value: value, cond: `{"field":"empty","any":["qty",">",5]}`, expect: false},
+	})
+
+	iterateTestCases(t, "all and none", []testCase{
+		{value: value, cond: `{"field":"items","all":["sku","in",["A","B"]]}`, expect: true},
+		{value: value, cond: `{"field":"items","all":{"and":[["sku","=","A"],["qty","<",5]]}}`, expect: false},
+		{value: value, cond: `{"field":"items","none":{"and":[["sku","=","A"],["qty",">",5]]}}`, expect: true},
+		{value: value, cond: `{"field":"items","none":["sku","=","B"]}`, expect: false},
+		{value: value, cond: `{"field":"empty","all":["qty",">",5]}`, expect: true},
+		{value: value, cond: `{"field":"empty","none":["qty",">",5]}`, expect: true},
+		{value: value, cond: `{"field":"orders","all":{"field":"items","any":["sku","=","A"]}}`, expect: true},
+		{value: value, cond: `{"field":"orders","all":{"field":"items","any":["sku","=","B"]}}`, expect: false},
+		{value: value, cond: `{"field":"orders.[0].items","all":["sku","=","A"]}`, expect: true},
+	})
+
+	iterateTestCases(t, "errors", []testCase{
+		{value: value, cond: `{"field":"none","any":["qty",">",5]}`, shouldErr: true},
+		{value: value, cond: `{"field":"none","any":["qty",">",5]}`, opts: []Option{OptWhenNotFound(ReturnFalse)}, expect: false},
+		{value: value, cond: `{"field":"str","any":["qty",">",5]}`, shouldErr: true},
+		{value: value, cond: `{"field":"str","any":["qty",">",5]}`, opts: []Option{OptWhenTypeMismatch(ReturnFalse)}, expect: false},
+		{value: value, cond: `{"not":{"field":"str","any":["qty",">",5]}}`, opts: []Option{OptWhenTypeMismatch(ReturnFalse)}, expect: true},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`{"field":"orders","all":{"field":"items","any":["sku","=","A"]}}`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	t.Log(e.String())
+	so(e.Type, eq, ExplainAll)
+	so(len(e.Children), eq, 2)
+	so(e.Children[1].Path, eq, "orders.[1]")
+	so(e.Children[1].Children[1].Path, eq, "orders.[1].items.[1]")
+	so(e.Children[1].Children[1].Resolved[0].Path, eq, "orders.[1].items.[1].sku")
+}
+
+func testArrayQuantifiers(t *testing.T) {
+	value := `{
+		"items":[{"flagged":true,"qty":1},{"flagged":false,"qty":2},{"flagged":true,"qty":3}],
+		"groups":[{"tags":["a","b"]},{"tags":["b"]},{"tags":[]}]
+	}`
+
+	iterateTestCases(t, "none", []testCase{
+		{value: value, cond: `["items.[!].qty",">",3]`, expect: true},
+		{value: value, cond: `["items.[none].qty",">",2]`, expect: false},
+		{value: value, cond: `["groups.[!].tags.[+]","=","c"]`, expect: true},
+		{value: value, cond: `["groups.[*].tags.[!]","=","a"]`, expect: false},
+	})
+
+	iterateTestCases(t, "count", []testCase{
+		{value: value, cond: `["items.[>=2].flagged","=",true]`, expect: true},
+		{value: value, cond: `["items.[>=3].flagged","=",true]`, expect: false},
+		{value: value, cond: `["items.[=2].flagged","=",true]`, expect: true},
+		{value: value, cond: `["items.[=1].flagged","=",true]`, expect: false},
+		{value: value, cond: `["items.[one].flagged","=",false]`, expect: true},
+		{value: value, cond: `["items.[<=1].flagged","=",false]`, expect: true},
+		{value: value, cond: `["items.[<1].qty",">",2]`, expect: false},
+		{value: value, cond: `["items.[>1].qty",">",1]`, expect: true},
+		{value: value, cond: `["items.[= 0].qty",">",3]`, expect: true},
+		{value: value, cond: `["groups.[>=2].tags.[+]","=","b"]`, expect: true},
+		{value: value, cond: `["groups.[one].tags.[+]","=","a"]`, expect: true},
+		{value: value, cond: `["groups.[one].tags.#","=",0]`, expect: true},
+		{value: value, cond: `["items.[>=2].none","=",1]`, shouldErr: true},
+		{value: value, cond: `["items.[>=2].none","=",1]`, opts: []Option{OptWhenNotFound(ReturnFalse)}, expect: false},
+	})
+
+	// 出错的元素: none、[=0] 以及 none 组合条件的结果总是与 NOT any 相同
+	missing := `{"items":[{"sku":"B"},{}]}`
+	mismatched := `{"items":[{"sku":"B"},{"sku":1}]}`
+	notFound := []Option{OptWhenNotFound(ReturnFalse)}
+	typeMismatch := []Option{OptWhenTypeMismatch(ReturnFalse)}
+	iterateTestCases(t, "missing or mismatched elements", []testCase{
+		{value: missing, cond: `["items.[!].sku","=","A"]`, opts: notFound, expect: true},
+		{value: missing, cond: `{"not":["items.[+].sku","=","A"]}`, opts: notFound, expect: true},
+		{value: missing, cond: `["items.[=0].sku","=","A"]`, opts: notFound, expect: true},
+		{value: missing, cond: `{"field":"items","none":["sku","=","A"]}`, opts: notFound, expect: true},
+		{value: missing, cond: `["items.[+].sku","=","A"]`, opts: notFound, expect: false},
+		{value: missing, cond: `["items.[*].sku","=","B"]`, opts: notFound, expect: false},
+		{value: missing, cond: `["items.[=1].sku","=","B"]`, opts: notFound, expect: true},
+
+		{value: missing, cond: `["items.[!].sku","=","A"]`, shouldErr: true},
+		{value: missing, cond: `{"not":["items.[+].sku","=","A"]}`, shouldErr: true},
+		{value: missing, cond: `["items.[=0].sku","=","A"]`, shouldErr: true},
+		{value: missing, cond: `{"field":"items","none":["sku","=","A"]}`, shouldErr: true},
+		{value: missing, cond: `["items.[=1].sku","=","B"]`, shouldErr: true},
+
+		// 其他元素已经可以确定结果时忽略错误
+		{value: missing, cond: `["items.[!].sku","=","B"]`, expect: false},
+		{value: missing, cond: `["items.[=0].sku","=","B"]`, expect: false},
+		{value: missing, cond: `["items.[<=2].sku","=","B"]`, expect: true},
+		{value: missing, cond: `["items.[*].sku","=","A"]`, expect: false},
+		{value: missing, cond: `{"field":"items","all":["sku","=","A"]}`, expect: false},
+
+		// [*] 与之前的版本相同, 按顺序遇到出错的元素时返回错误
+		{value: `{"items":[{"x":1},{"q":0}]}`, cond: `["items.[*].q",">",0]`, shouldErr: true},
+		{value: `{"items":[{"x":1},{"q":0}]}`, cond: `["items.[*].q",">",0]`, opts: notFound, expect: false},
+		{value: `{"items":[{"x":1},{"q":0}]}`, cond: `{"field":"items","all":["q",">",0]}`, shouldErr: true},
+		{value: `{"items":[{"q":0},{"x":1}]}`, cond: `["items.[*].q",">",0]`, expect: false},
+
+		{value: mismatched, cond: `["items.[!].sku","=","A"]`, opts: typeMismatch, expect: true},
+		{value: mismatched, cond: `{"not":["items.[+].sku","=","A"]}`, opts: typeMismatch, expect: true},
+		{value: mismatched, cond: `["items.[=0].sku","=","A"]`, opts: typeMismatch, expect: true},
+		{value: mismatched, cond: `{"field":"items","none":["sku","=","A"]}`, opts: typeMismatch, expect: true},
+		{value: mismatched, cond: `["items.[!].sku","=","A"]`, shouldErr: true},
+		{value: mismatched, cond: `["items.[=0].sku","=","A"]`, shouldErr: true},
+
+		// 数组本身不存在
+		{value: missing, cond: `["none.[!].sku","=","A"]`, opts: notFound, expect: true},
+		{value: missing, cond: `{"not":["none.[+].sku","=","A"]}`, opts: notFound, expect: true},
+		{value: missing, cond: `["none.[=0].sku","=","A"]`, opts: notFound, expect: true},
+		{value: missing, cond: `["none.[>=1].sku","=","A"]`, opts: notFound, expect: false},
+		{value: missing, cond: `{"field":"none","none":["sku","=","A"]}`, opts: notFound, expect: true},
+		{value: missing, cond: `{"field":"none","any":["sku","=","A"]}`, opts: notFound, expect: false},
+		{value: missing, cond: `["items.[0].sku.[!]","=","A"]`, opts: typeMismatch, expect: true},
+		{value: missing, cond: `["none.[!].sku","=","A"]`, shouldErr: true},
+	})
+}
+
+func testArraySlices(t *testing.T) {
+	value := `{"events":[0,1,2,3,4,5,6,7,8,9],"empty":[]}`
+
+	iterateTestCases(t, "slices", []testCase{
+		{value: value, cond: `["events.[+0:3]","=",2]`, expect: true},
+		{value: value, cond: `["events.[+0:3]","=",3]`, expect: false},
+		{value: value, cond: `["events.[*0:3]","<",3]`, expect: true},
+		{value: value, cond: `["events.[*-5:]",">=",5]`, expect: true},
+		{value: value, cond: `["events.[*-6:]",">=",5]`, expect: false},
+		{value: value, cond: `["events.[+:-8]","=",2]`, expect: false},
+		{value: value, cond: `["events.[*::2]","in",[0,2,4,6,8]]`, expect: true},
+		{value: value, cond: `["events.[*1::2]","in",[1,3,5,7,9]]`, expect: true},
+		{value: value, cond: `["events.[!::2]","=",5]`, expect: true},
+		{value: value, cond: `["events.[*::-3]","in",[9,6,3,0]]`, expect: true},
+		{value: value, cond: `["events.[*8:2:-3]","in",[8,5]]`, expect: true},
+		{value: value, cond: `["events.[+-100:100]","=",9]`, expect: true},
+		{value: value, cond: `["events.[+5:2]","=",3]`, expect: false},
+		{value: value, cond: `["events.[*5:2]","=",3]`, expect: true},
+		{value: value, cond: `["empty.[*:3]","=",3]`, expect: true},
+		{value: value, cond: `["empty.[+::-1]","=",3]`, expect: false},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`["events.[*-3:]",">",7]`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	so(e.Result, eq, false)
+	so(len(e.Resolved), eq, 1)
+	so(e.Resolved[0].Path, eq, "events.[7]")
+}
+
+func testObjectWildcards(t *testing.T) {
+	value := `{
+		"prices":{"cn":{"amount":100},"us":{"amount":15},"jp":{"amount":1600}},
+		"titles":{"en":"Hello","fr":"Bonjour"},
+		"empty":{},
+		"order":{"price":10,"items":[{"sku":"a","price":3},{"sku":"b","detail":{"price":30}}]}
+	}`
+
+	iterateTestCases(t, "object values", []testCase{
+		{value: value, cond: `["prices.{+}.amount",">",1000]`, expect: true},
+		{value: value, cond: `["prices.{*}.amount",">",10]`, expect: true},
+		{value: value, cond: `["prices.{*}.amount",">",20]`, expect: false},
+		{value: value, cond: `["prices.{!}.amount","<",10]`, expect: true},
+		{value: value, cond: `["prices.{one}.amount","<",50]`, expect: true},
+		{value: value, cond: `["prices.{>=2}.amount",">",50]`, expect: true},
+		{value: value, cond: `["titles.{+}","prefix","Bon"]`, expect: true},
+		{value: value, cond: `["titles.{*}","prefix","Bon"]`, expect: false},
+		{value: value, cond: `["empty.{*}","=",1]`, expect: true},
+		{value: value, cond: `["empty.{+}","=",1]`, expect: false},
+		{value: value, cond: `["prices.{+}.currency","=","CNY"]`, expect: false, shouldErr: true},
+		{value: value, cond: `["prices.{+}.currency","=","CNY"]`, expect: false, opts: []Option{OptWhenNotFound(ReturnFalse)}},
+		{value: value, cond: `["titles.en.{+}","=",1]`, expect: false, shouldErr: true},
+	})
+
+	iterateTestCases(t, "recursive descent", []testCase{
+		{value: value, cond: `["..price","=",30]`, expect: true},
+		{value: value, cond: `["..price",">",100]`, expect: false},
+		{value: value, cond: `["order..price","=",3]`, expect: true},
+		{value: value, cond: `["order..sku","=","b"]`, expect: true},
+		{value: value, cond: `["..amount","=",1600]`, expect: true},
+		{value: value, cond: `["..prices.cn.amount","=",100]`, expect: true},
+		{value: value, cond: `["..tax","=",1]`, expect: false, shouldErr: true},
+		{value: value, cond: `["..tax","=",1]`, expect: false, opts: []Option{OptWhenNotFound(ReturnFalse)}},
+		{value: value, cond: `["..tax","exists",false]`, expect: true},
+		{value: value, cond: `["..detail","exists",true]`, expect: true},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`["order..price",">",10]`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	so(e.Result, eq, true)
+	so(len(e.Resolved), eq, 2)
+	so(e.Resolved[0].Path, eq, "order.items.[0].price")
+	so(e.Resolved[1].Path, eq, "order.items.[1].detail.price")
+
+	cond = Condition{}
+	err = json.Unmarshal([]byte(`["prices.{*}.amount","<",1000]`), &cond)
+	so(err, isNil)
+	e, err = MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	so(e.Result, eq, false)
+	so(e.Resolved[len(e.Resolved)-1].Path, eq, "prices.jp.amount")
+}
+
+func testQuotedKeys(t *testing.T) {
+	value := `{"headers":{"x-request.id":"abc"," key ":1,"#":2,"a":{"b":3},"say \"hi\"":4,"list":[5]}}`
+
+	iterateTestCases(t, "quoted keys", []testCase{
+		{value: value, cond: `["headers.'x-request.id'","=","abc"]`, expect: true},
+		{value: value, cond: `["headers.[\"x-request.id\"]","=","abc"]`, expect: true},
+		{value: value, cond: `["headers.x-request\\.id","=","abc"]`, expect: true},
+		{value: value, cond: `["headers.x-request.id","=","abc"]`, expect: false, shouldErr: true},
+		{value: value, cond: `["headers.' key '","=",1]`, expect: true},
+		{value: value, cond: `["headers. key ","=",1]`, expect: false, shouldErr: true},
+		{value: value, cond: `["headers.'#'","=",2]`, expect: true},
+		{value: value, cond: `["headers.#","=",6]`, expect: true},
+		{value: value, cond: `["headers.'say \"hi\"'","=",4]`, expect: true},
+		{value: value, cond: `["..'x-request.id'","=","abc"]`, expect: true},
+		{value: value, cond: `["headers.'list'.[0]","=",5]`, expect: true},
+		{value: value, cond: `{"field":"headers.'x-request.id'","op":"=","ref":"$headers.'x-request.id'"}`, expect: true},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`["headers.{+}","=","abc"]`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	so(e.Result, eq, true)
+	paths := []string{}
+	for _, r := range e.Resolved {
+		paths = append(paths, r.Path)
+	}
+	so(paths, convey.ShouldContain, `headers." key "`)
+	so(paths, convey.ShouldContain, `headers."x-request.id"`)
+	so(paths, convey.ShouldContain, `headers."#"`)
+}
+
+func testFieldSyntax(t *testing.T) {
+	value := `{
+		"items":[{"sku":"a","qty":1,"price":3},{"sku":"b","qty":2,"price":30,"tags":["x"]},{"sku":"c","qty":5,"price":8}],
+		"a/b":{"c~d":1},
+		"map":{"0":"zero","1":"one"},
+		"order":{"price":100}
+	}`
+
+	iterateTestCases(t, "JSON Pointer", []testCase{
+		{value: value, cond: `["/items/0/sku","=","a"]`, expect: true},
+		{value: value, cond: `["/items/2/qty",">",3]`, expect: true},
+		{value: value, cond: `["/a~1b/c~0d","=",1]`, expect: true},
+		{value: value, cond: `["/map/0","=","zero"]`, expect: true},
+		{value: value, cond: `["/items/3/sku","=","a"]`, expect: false, shouldErr: true},
+		{value: value, cond: `["/items/-/sku","exists",false]`, expect: true},
+		{value: value, cond: `{"field":"/items/1","syntax":"pointer","op":"type","value":"object"}`, expect: true},
+		{value: value, cond: `{"field":"/items","any":["sku","=","c"]}`, expect: true},
+	})
+
+	iterateTestCases(t, "JSONPath", []testCase{
+		{value: value, cond: `["$.items[*].sku","=","b"]`, expect: true},
+		{value: value, cond: `["$.items[*].sku","=","d"]`, expect: false},
+		{value: value, cond: `["$..price","=",100]`, expect: true},
+		{value: value, cond: `["$.items[-1].sku","=","c"]`, expect: true},
+		{value: value, cond: `["$.items[:2].qty","=",5]`, expect: false},
+		{value: value, cond: `["$['a/b']['c~d']","=",1]`, expect: true},
+		{value: value, cond: `["$.map.*","=","one"]`, expect: true},
+		{value: value, cond: `["$.items[?(@.qty>1)].sku","=","a"]`, expect: false},
+		{value: value, cond: `["$.items[?(@.qty>1)].sku","=","b"]`, expect: true},
+		{value: value, cond: `["$.items[?(@.qty>1 && @.price<10)].sku","=","c"]`, expect: true},
+		{value: value, cond: `["$.items[?(@.qty>1 && @.price<10)].sku","=","b"]`, expect: false},
+		{value: value, cond: `["$.items[?(@.sku=='a' || @.tags)].qty","=",2]`, expect: true},
+		{value: value, cond: `["$.items[?(!(@.sku=='a') && @.sku=~'^[bc]$')].qty","=",1]`, expect: false},
+		{value: value, cond: `["$.items[?(@.tags[0]==\"x\")].sku","=","b"]`, expect: true},
+		{value: value, cond: `["$.items[?(@.tags!=null)].sku","=","b"]`, expect: true},
+		{value: value, cond: `["$.items[?(@.qty>100)].sku","exists"]`, expect: false},
+		{value: value, cond: `{"field":"$.items[?(@.qty>1)].tags","syntax":"jsonpath","op":"len>=","value":1}`, expect: true},
+		{value: value, cond: `["$.items[?(@.qty>1)].color","=","red"]`, expect: false, shouldErr: true},
+		{value: value, cond: `["$.items[?(@.qty>1)].color","=","red"]`, expect: false, opts: []Option{OptWhenNotFound(ReturnFalse)}},
+		{value: value, cond: `{"field":"items.[?(@.qty>=2)].price","op":">","value":20}`, expect: true},
+		{value: value, cond: `{"field":"$tag","syntax":"dot","op":"exists","value":false}`, expect: true},
+	})
+
+	// 与之前的版本不兼容: 以 / 或者 $. 开头的 field 会被自动识别为 JSON Pointer 或 JSONPath,
+	// 原来的 key 需要指定 syntax 为 dot 或者使用引号
+	legacy := `{"/api":{"v":1},"$":{"x":2}}`
+	iterateTestCases(t, "legacy keys", []testCase{
+		{value: legacy, cond: `["/api.v","=",1]`, shouldErr: true},
+		{value: legacy, cond: `{"field":"/api.v","syntax":"dot","op":"=","value":1}`, expect: true},
+		{value: legacy, cond: `["'/api'.v","=",1]`, expect: true},
+		{value: legacy, cond: `["$.x","=",2]`, shouldErr: true},
+		{value: legacy, cond: `{"field":"$.x","syntax":"dot","op":"=","value":2}`, expect: true},
+		{value: legacy, cond: `["'$'.x","=",2]`, expect: true},
+	})
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`["$.items[?(@.qty>1)].price","<",5]`), &cond)
+	so(err, isNil)
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	so(e.Result, eq, false)
+	so(len(e.Resolved), eq, 2)
+	so(e.Resolved[0].Path, eq, "items.[1].price")
+	so(e.Resolved[1].Path, eq, "items.[2].price")
+}
+
+func testCompileIllegal(t *testing.T) {
+	cases := []struct {
+		cond string
+		err  error
+		opts []Option
+	}{
+		{cond: `["int","=>",1]`, err: ErrIllegalOperator},
+		{cond: `{"or":[["int","=",1],{"not":["int","~",1]}]}`, err: ErrIllegalOperator},
+		{cond: `["array.[x].int","=",1]`, err: ErrIllegalField},
+		{cond: `["array...int","=",1]`, err: ErrIllegalField},
+		{cond: `["int","in",1]`, err: ErrIllegalTargetValue},
+		{cond: `["int",">","1"]`, err: ErrIllegalTargetValue},
+		{cond: `["int",">",true]`, err: ErrIllegalTargetValue},
+		{cond: `["time",">","2024-13-01"]`, err: ErrIllegalTargetValue, opts: []Option{OptDateTimeFormat(time.DateOnly)}},
+		{cond: `["str","contains",1]`, err: ErrIllegalTargetValue},
+		{cond: `["str","regex","(abc"]`, err: ErrIllegalTargetValue},
+		{cond: `["int","not >",1]`, err: ErrIllegalOperator},
+		{cond: `["int","ieq",1]`, err: ErrIllegalOperator},
+		{cond: `["int","exists",1]`, err: ErrIllegalTargetValue},
+		{cond: `["int","type","integer"]`, err: ErrIllegalTargetValue},
+		{cond: `["int","type",[]]`, err: ErrIllegalTargetValue},
+		{cond: `["int","type"]`, err: ErrIllegalTargetValue},
+		{cond: `["arr","containsany","a"]`, err: ErrIllegalTargetValue},
+		{cond: `["arr","not =",1]`, err: ErrIllegalOperator},
+		{cond: `["arr","len contains",1]`, err: ErrIllegalOperator},
+		{cond: `["arr","len=","1"]`, err: ErrIllegalTargetValue},
+		{cond: `["arr.#.a","=",1]`, err: ErrIllegalField},
+		{cond: `["int","between",[1]]`, err: ErrIllegalTargetValue},
+		{cond: `{"field":"int","op":"=","value":1,"ref":"a"}`, err: ErrIllegalTargetValue},
+		{cond: `{"field":"int","op":"exists","ref":"$a"}`, err: ErrIllegalTargetValue},
+		{cond: `{"field":"int","op":"=","ref":"$a.[+].b"}`, err: ErrIllegalField},
+		{cond: `{"field":"a.[*].b","any":["c","=",1]}`, err: ErrIllegalField},
+		{cond: `["a.[>=-1].b","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[>=x].b","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[~1].b","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[0:3]","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[+0:3:0]","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[+0:x]","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[+0:1:2:3]","=",1]`, err: ErrIllegalField},
+		{cond: `{"field":"a.[+0:1]","any":["c","=",1]}`, err: ErrIllegalField},
+		{cond: `["a..","=",1]`, err: ErrIllegalField},
+		{cond: `[".a","=",1]`, err: ErrIllegalField},
+		{cond: `["a..[0]","=",1]`, err: ErrIllegalField},
+		{cond: `["a..{*}","=",1]`, err: ErrIllegalField},
+		{cond: `["a.{x}","=",1]`, err: ErrIllegalField},
+		{cond: `["a.[0","=",1]`, err: ErrIllegalField},
+		{cond: `["a.'b","=",1]`, err: ErrIllegalField},
+		{cond: `["/a/~2","=",1]`, err: ErrIllegalField},
+		{cond: `["$.a[0,1]","=",1]`, err: ErrIllegalField},
+		{cond: `{"field":"a","syntax":"xpath","op":"=","value":1}`, err: ErrIllegalField},
+		{cond: `["$.a[?(@.b=~1)]","=",1]`, err: ErrIllegalTargetValue},
+		{cond: `{"field":"$.a[*]","any":["c","=",1]}`, err: ErrIllegalField},
+		{cond: `["...a","=",1]`, err: ErrIllegalField},
+		{cond: `{"field":"a","all":["c","=>",1]}`, err: ErrIllegalOperator},
+		{cond: `["int","between",[2,1]]`, err: ErrIllegalTargetValue},
+		{cond: `["int","between","[1,2,3]"]`, err: ErrIllegalTargetValue},
+		{cond: `["int","between","{1,2}"]`, err: ErrIllegalTargetValue},
+		{cond: `["int","between","[Jan 1, 2024]"]`, err: ErrIllegalTargetValue, opts: []Option{OptDateTimeFormat("Jan 2, 2006")}},
+		{cond: `["int","between","[Jan 1, 2024, 2024, 1]"]`, err: ErrIllegalTargetValue, opts: []Option{OptDateTimeFormat("Jan 2, 2006")}},
+		{cond: `["int","between",["a","b"]]`, err: ErrIllegalTargetValue},
+		{cond: `["int","between",[1,"2024-01-01"]]`, err: ErrIllegalTargetValue, opts: []Option{OptDateTimeFormat(time.DateOnly)}},
+	}
+
+	for i, c := range cases {
+		t.Log("No", i+1, c.cond)
+		cond := Condition{}
+		err := json.Unmarshal([]byte(c.cond), &cond)
+		so(err, isNil)
+
+		p, err := Compile(cond, c.opts...)
+		so(errors.Is(err, c.err), eq, true)
+		so(p, isNil)
+	}
+
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`["time",">","2024-01-01"]`), &cond)
+	so(err, isNil)
+	p, err := Compile(cond, OptDateTimeFormat(time.DateOnly))
+	so(err, isNil)
+	so(p, convey.ShouldNotBeNil)
+}
+
+func testProgramConcurrent(t *testing.T) {
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`{"and":[["array.[+].int",">",10],{"not":["int",">",100000]},["str","in",["a","b"]]]}`), &cond)
+	so(err, isNil)
+
+	p, err := Compile(cond)
+	so(err, isNil)
+
+	values := []string{
+		`{"int":123,"str":"a","array":[{"int":1},{"int":22}]}`,
+		`{"int":123456,"str":"a","array":[{"int":1},{"int":22}]}`,
+		`{"int":123,"str":"c","array":[{"int":1},{"int":22}]}`,
+		`{"int":123,"str":"b","array":[{"int":1},{"int":2}]}`,
+	}
+	expects := []bool{true, false, false, false}
+
+	wg := sync.WaitGroup{}
+	errCount := 0
+	lock := sync.Mutex{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			idx := i % len(values)
+			b, err := p.Match(jsonvalue.MustUnmarshalString(values[idx]))
+			if err != nil || b != expects[idx] {
+				lock.Lock()
+				errCount++
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	so(errCount, eq, 0)
+}
+
+func testExplain(t *testing.T) {
+	value := `{"int":123456,"array":[{"int":1},{"int":22},{"int":33}]}`
+	cond := Condition{}
+	err := json.Unmarshal([]byte(`{"and":[["array.[+].int",">",10],{"not":["int",">",100000]},["str","=","a"]]}`), &cond)
+	so(err, isNil)
+
+	e, err := MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(err, isNil)
+	t.Log(e.String())
+
+	so(e.Type, eq, ExplainAND)
+	so(e.Result, eq, false)
+	so(e.Skipped, eq, 1)
+	so(len(e.Children), eq, 2)
+
+	anyNode := e.Children[0]
+	so(anyNode.Result, eq, true)
+	so(len(anyNode.Resolved), eq, 2)
+	so(anyNode.Resolved[0].Path, eq, "array.[0].int")
+	so(anyNode.Resolved[0].Result, eq, false)
+	so(anyNode.Resolved[1].Path, eq, "array.[1].int")
+	so(string(anyNode.Resolved[1].Value), eq, "22")
+	so(anyNode.Resolved[1].Result, eq, true)
+
+	not := e.Children[1]
+	so(not.Type, eq, ExplainNOT)
+	so(not.Result, eq, false)
+	so(not.Children[0].Result, eq, true)
+
+	// 被吞掉的错误
+	err = json.Unmarshal([]byte(`{"or":[["str","=","a"],["array.[-1].int","=",33]]}`), &cond)
+	so(err, isNil)
+	e, err = MatchExplain(jsonvalue.MustUnmarshalString(value), cond, OptWhenNotFound(ReturnFalse))
+	so(err, isNil)
+	t.Log(e.String())
+	so(e.Result, eq, true)
+	so(e.Children[0].Swallowed, convey.ShouldNotBeEmpty)
+	so(e.Children[0].Resolved[0].Path, eq, "str")
+	so(e.Children[1].Resolved[0].Path, eq, "array.[2].int")
+
+	b, err := json.Marshal(e)
+	so(err, isNil)
+	t.Log(string(b))
+
+	// 未被吞掉的错误
+	e, err = MatchExplain(jsonvalue.MustUnmarshalString(value), cond)
+	so(errors.Is(err, ErrNotFound), eq, true)
+	so(e.Error, convey.ShouldNotBeEmpty)
+	so(e.Children[0].Error, convey.ShouldNotBeEmpty)
+	so(e.Skipped, eq, 1)
+}
+
+// ----------------
+// MARK: benchmarks
+
+// benchDocument 生成一个又宽又深的 JSON 文档
+func benchDocument() map[string]any {
+	doc := map[string]any{}
+	for i := 0; i < 200; i++ {
+		doc[fmt.Sprintf("key_%03d", i)] = i
+	}
+	items := make([]any, 0, 500)
+	for i := 0; i < 500; i++ {
+		items = append(items, map[string]any{
+			"sku":   fmt.Sprintf("SKU-%04d", i),
+			"price": float64(i) * 1.5,
+			"attr":  map[string]any{"lv_a": map[string]any{"lv_b": map[string]any{"int": i}}},
+		})
+	}
+	doc["items"] = items
+	return doc
+}
+
+// benchCondition 生成一个拥有 30 个叶子节点的规则
+func benchCondition() Condition {
+	cond := Condition{}
+	for i := 0; i < 10; i++ {
+		cond.AND = append(cond.AND,
+			Condition{Expr: Expr{Field: fmt.Sprintf("key_%03d", i*10), Operator: ">=", Value: 0}},
+			Condition{Expr: Expr{Field: "items.[+].attr.lv_a.lv_b.int", Operator: "=", Value: 499 - i}},
+			Condition{Expr: Expr{Field: "items.[*].price", Operator: "<", Value: 10000}},
+		)
+	}
+	return cond
+}
+
+func BenchmarkMatch(b *testing.B) {
+	doc := benchDocument()
+	cond := benchCondition()
+
+	p, err := Compile(cond)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// 模拟每个叶子节点都导入一次文档的旧逻辑
+	leaves := make([]*Program, 0, len(cond.AND))
+	for _, c := range cond.AND {
+		leaf, err := Compile(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		leaves = append(leaves, leaf)
+	}
+
+	b.Run("import-per-leaf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, leaf := range leaves {
+				if ok, err := leaf.Match(doc); err != nil || !ok {
+					b.Fatal(ok, err)
+				}
+			}
+		}
+	})
+
+	b.Run("import-once", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if ok, err := p.Match(doc); err != nil || !ok {
+				b.Fatal(ok, err)
+			}
+		}
+	})
+
+	parsed, err := jsonvalue.Import(doc)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("parsed-value", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if ok, err := p.Match(parsed); err != nil || !ok {
+				b.Fatal(ok, err)
+			}
+		}
+	})
+}
+
+// benchRules 生成 n 条路由规则, 每条规则由一个精确值或前缀条件以及一个数值比较组成
+func benchRules(n int) []Rule {
+	rules := make([]Rule, 0, n)
+	for i := 0; i < n; i++ {
+		var guard Condition
+		switch i % 3 {
+		case 0:
+			guard = Condition{Expr: Expr{Field: "route.service", Operator: "=", Value: fmt.Sprintf("svc-%d", i)}}
+		case 1:
+			guard = Condition{Expr: Expr{Field: "route.region", Operator: "in", Value: []any{
+				fmt.Sprintf("region-%d", i), fmt.Sprintf("region-%d", i+1),
+			}}}
+		default:
+			guard = Condition{Expr: Expr{Field: "route.path", Operator: "startswith", Value: fmt.Sprintf("/api/v%d/", i)}}
+		}
+		rules = append(rules, Rule{
+			ID: strconv.Itoa(i),
+			Condition: Condition{AND: []Condition{
+				{Expr: Expr{Field: "request.size", Operator: "<", Value: 1 << 20}},
+				guard,
+			}},
+		})
+	}
+	return rules
+}
+
+func BenchmarkRuleIndex(b *testing.B) {
+	doc := jsonvalue.MustUnmarshalString(`{
+		"route":{"service":"svc-42","region":"region-43","path":"/api/v44/users"},
+		"request":{"size":1024}
+	}`)
+
+	for _, n := range []int{100, 1000, 10000} {
+		rules := benchRules(n)
+
+		rs, err := NewRuleSet(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("ruleset-%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if res, err := rs.AllMatches(doc); err != nil || len(res) != 3 {
+					b.Fatal(res, err)
+				}
+			}
+		})
+
+		ri, err := NewRuleIndex(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("index-%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if ids, err := ri.Match(doc); err != nil || len(ids) != 3 {
+					b.Fatal(ids, err)
+				}
+			}
+		})
+	}
 }
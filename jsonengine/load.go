@@ -0,0 +1,78 @@
+package jsonengine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: LoadFile
+
+// LoadFile 从文件中读取一个 Condition, 按照扩展名选择格式: .json 为 JSON, .yaml 和 .yml 为 YAML,
+// 扩展名不区分大小写, 其他扩展名返回错误
+func LoadFile(path string) (Condition, error) {
+	unmarshal, ok := unmarshalerByExt(path)
+	if !ok {
+		return Condition{}, fmt.Errorf("unsupported file extension '%s' of '%s', expecting .json, .yaml or .yml",
+			filepath.Ext(path), path)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Condition{}, err
+	}
+	c := Condition{}
+	if err := unmarshal(b, &c); err != nil {
+		return Condition{}, fmt.Errorf("load '%s' error (%w)", path, err)
+	}
+	return c, nil
+}
+
+// LoadDir 递归读取目录下所有的 .json、.yaml 和 .yml 文件, 其他文件会被忽略。返回值的 key 为相对于 dir 的
+// 文件路径, 使用 / 分隔, 如 "orders/vip.yaml"。任意一个文件读取失败时返回错误
+func LoadDir(dir string) (map[string]Condition, error) {
+	res := map[string]Condition{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := unmarshalerByExt(path); !ok {
+			return nil
+		}
+
+		c, err := LoadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		res[filepath.ToSlash(rel)] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func unmarshalerByExt(path string) (func([]byte, any) error, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return json.Unmarshal, true
+	case ".yaml", ".yml":
+		return yaml.Unmarshal, true
+	default:
+		return nil, false
+	}
+}
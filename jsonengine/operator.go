@@ -0,0 +1,856 @@
+package jsonengine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - operator
+
+// opKind 表示操作符的种类
+type opKind uint8
+
+const (
+	opUnknown opKind = iota
+	opEqual
+	opNotEqual
+	opLessOrGreater
+	opLess
+	opLessOrEqual
+	opGreater
+	opGreaterOrEqual
+	opIn
+	opContains
+	opPrefix
+	opSuffix
+	opRegex
+	opLike
+	opGlob
+	opExists
+	opIsNull
+	opEmpty
+	opType
+	opContainsAll
+	opContainsAny
+	opSubsetOf
+	opSetEqual
+	opBetween
+)
+
+// operatorAliases 操作符的所有别名, key 均为小写
+var operatorAliases = map[string]opKind{
+	"=": opEqual, "==": opEqual, "===": opEqual, "eq": opEqual,
+
+	"≹": opNotEqual, "≸": opNotEqual, "≠": opNotEqual, "<>": opNotEqual, "!=": opNotEqual, "ne": opNotEqual,
+
+	"≶": opLessOrGreater, "≷": opLessOrGreater,
+
+	"<": opLess, "≱": opLess, "lt": opLess,
+
+	"<=": opLessOrEqual, "≤": opLessOrEqual, "≦": opLessOrEqual, "≯": opLessOrEqual, "le": opLessOrEqual,
+
+	">": opGreater, "≰": opGreater, "gt": opGreater,
+
+	">=": opGreaterOrEqual, "≥": opGreaterOrEqual, "≧": opGreaterOrEqual, "≮": opGreaterOrEqual, "ge": opGreaterOrEqual,
+
+	"in": opIn,
+
+	"contains": opContains,
+
+	"startswith": opPrefix, "prefix": opPrefix,
+
+	"endswith": opSuffix, "suffix": opSuffix,
+
+	"regex": opRegex, "=~": opRegex,
+
+	"like": opLike,
+
+	"glob": opGlob,
+
+	"exists": opExists,
+
+	"isnull": opIsNull,
+
+	"empty": opEmpty, "isempty": opEmpty,
+
+	"type": opType, "typeof": opType,
+
+	"containsall": opContainsAll, "supersetof": opContainsAll,
+
+	"containsany": opContainsAny,
+
+	"subsetof": opSubsetOf,
+
+	"seteq": opSetEqual, "setequal": opSetEqual,
+
+	"between": opBetween, "range": opBetween,
+}
+
+// operatorSpecialAliases 本身就带有修饰的操作符别名
+var operatorSpecialAliases = map[string]operator{
+	"!~":  {kind: opRegex, not: true},
+	"~*":  {kind: opRegex, caseless: true},
+	"!~*": {kind: opRegex, not: true, caseless: true},
+
+	"notnull":   {kind: opIsNull, not: true},
+	"isnotnull": {kind: opIsNull, not: true},
+
+	"nin": {kind: opIn, not: true},
+
+	"disjoint": {kind: opContainsAny, not: true},
+}
+
+var opKindNames = map[opKind]string{
+	opEqual:          "=",
+	opNotEqual:       "!=",
+	opLessOrGreater:  "≶",
+	opLess:           "<",
+	opLessOrEqual:    "<=",
+	opGreater:        ">",
+	opGreaterOrEqual: ">=",
+	opIn:             "in",
+	opContains:       "contains",
+	opPrefix:         "startswith",
+	opSuffix:         "endswith",
+	opRegex:          "regex",
+	opLike:           "like",
+	opGlob:           "glob",
+	opExists:         "exists",
+	opIsNull:         "isnull",
+	opEmpty:          "empty",
+	opType:           "type",
+	opContainsAll:    "containsall",
+	opContainsAny:    "containsany",
+	opSubsetOf:       "subsetof",
+	opSetEqual:       "seteq",
+	opBetween:        "between",
+}
+
+func (k opKind) String() string {
+	if s, exist := opKindNames[k]; exist {
+		return s
+	}
+	return fmt.Sprintf("operator(%d)", uint8(k))
+}
+
+// isNumeric 表示该操作符只能用于数字或者是时间字符串
+func (k opKind) isNumeric() bool {
+	switch k {
+	default:
+		return false
+	case opLessOrGreater, opLess, opLessOrEqual, opGreater, opGreaterOrEqual:
+		return true
+	}
+}
+
+// isString 表示该操作符只能用于字符串
+func (k opKind) isString() bool {
+	switch k {
+	default:
+		return false
+	case opContains, opPrefix, opSuffix, opRegex, opLike, opGlob:
+		return true
+	}
+}
+
+// isPresence 表示该操作符检查值是否存在或其类型, 在 field 不存在时也不会返回 ErrNotFound
+func (k opKind) isPresence() bool {
+	switch k {
+	default:
+		return false
+	case opExists, opIsNull, opEmpty, opType:
+		return true
+	}
+}
+
+// isSet 表示该操作符要求值与目标值均为数组, 并按照集合语义比较
+func (k opKind) isSet() bool {
+	switch k {
+	default:
+		return false
+	case opContainsAll, opContainsAny, opSubsetOf, opSetEqual:
+		return true
+	}
+}
+
+// lengthCompatible 表示该操作符可以使用 "len" 前缀比较长度
+func (k opKind) lengthCompatible() bool {
+	return k == opEqual || k == opNotEqual || k == opIn || k == opBetween || k.isNumeric()
+}
+
+// negatable 表示该操作符可以使用 "not" 或 "!" 前缀取反
+func (k opKind) negatable() bool {
+	return k == opIn || k == opBetween || k.isString() || k.isPresence() || k.isSet()
+}
+
+// operator 表示编译后的操作符
+type operator struct {
+	kind opKind
+	// not 表示对结果取反
+	not bool
+	// caseless 表示字符串比较时忽略大小写
+	caseless bool
+	// length 表示比较的是值的长度, 等价于在 field 后加上 #
+	length bool
+}
+
+// parseOperator 解析操作符, 忽略大小写、前后空格以及下划线。
+//
+// 字符串操作符支持 "i" 前缀表示忽略大小写, 如 icontains; 字符串、存在性、集合操作符以及 in 支持
+// "not"、"not " 或 "!" 前缀表示取反, 如 "not like", "!contains", "notistartswith", "not in"
+func parseOperator(s string) (operator, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	name = strings.ReplaceAll(name, "_", "")
+	if k, exist := operatorAliases[name]; exist {
+		return operator{kind: k}, nil
+	}
+	if op, exist := operatorSpecialAliases[name]; exist {
+		return op, nil
+	}
+
+	// 长度比较, 如 len>=, len in
+	if strings.HasPrefix(name, "len") {
+		op, err := parseOperator(name[3:])
+		if err != nil || op.length || !op.kind.lengthCompatible() {
+			return operator{}, fmt.Errorf("%w (%s)", ErrIllegalOperator, s)
+		}
+		op.length = true
+		return op, nil
+	}
+
+	op := operator{}
+	switch {
+	case strings.HasPrefix(name, "!"):
+		op.not, name = true, strings.TrimSpace(name[1:])
+	case strings.HasPrefix(name, "not"):
+		op.not, name = true, strings.TrimSpace(name[3:])
+	}
+
+	if k, exist := operatorAliases[name]; exist {
+		op.kind = k
+	} else if strings.HasPrefix(name, "i") {
+		op.kind, op.caseless = operatorAliases[name[1:]], true
+	}
+
+	if op.kind == opUnknown || (op.not && !op.kind.negatable()) || (op.caseless && !op.kind.isString()) {
+		return operator{}, fmt.Errorf("%w (%s)", ErrIllegalOperator, s)
+	}
+	return op, nil
+}
+
+func (op operator) String() string {
+	s := op.kind.String()
+	if op.caseless {
+		s = "i" + s
+	}
+	if op.not {
+		s = "not " + s
+	}
+	if op.length {
+		if r := s[0]; r >= 'a' && r <= 'z' {
+			s = "len " + s
+		} else {
+			s = "len" + s
+		}
+	}
+	return s
+}
+
+// ----------------
+// MARK: type - operand
+
+// operand 表示比较的目标值, 以及针对操作符预处理好的数据
+type operand struct {
+	v *jsonvalue.V
+
+	// lower 表示忽略大小写的字符串操作符所使用的小写目标值
+	lower string
+	// re 表示 regex、like、glob 操作符编译后的正则表达式
+	re *regexp.Regexp
+	// types 表示 type 操作符允许的类型, 按位表示 jsonvalue.ValueType
+	types uint16
+	// lo, hi 表示 between 操作符的上下界, loOpen, hiOpen 表示是否为开区间
+	lo, hi         *jsonvalue.V
+	loOpen, hiOpen bool
+}
+
+// valueTypeNames type 操作符可用的类型名称
+var valueTypeNames = map[string]jsonvalue.ValueType{
+	"string":  jsonvalue.String,
+	"number":  jsonvalue.Number,
+	"bool":    jsonvalue.Boolean,
+	"boolean": jsonvalue.Boolean,
+	"null":    jsonvalue.Null,
+	"object":  jsonvalue.Object,
+	"array":   jsonvalue.Array,
+}
+
+// newOperand 检查目标值是否适用于该操作符, 并进行预处理
+func newOperand(op operator, target *jsonvalue.V, timeFmt string) (*operand, error) {
+	o := &operand{v: target}
+
+	switch {
+	default:
+		return o, nil
+
+	case op.length && op.kind == opBetween:
+		if err := o.compileBetween(op, ""); err != nil {
+			return nil, err
+		}
+		return o, nil
+
+	case op.length && !target.IsNumber() && !(op.kind == opIn && target.IsArray()):
+		return nil, fmt.Errorf(
+			"%w, operator '%v' requires a number but got %v",
+			ErrIllegalTargetValue, op, target.ValueType(),
+		)
+
+	case op.kind == opIn, op.kind.isSet():
+		if !target.IsArray() {
+			return nil, fmt.Errorf(
+				"%w, operator '%v' requires an array but got %v",
+				ErrIllegalTargetValue, op, target.ValueType(),
+			)
+		}
+		return o, nil
+
+	case op.kind.isNumeric():
+		if err := checkNumericTarget(op, target, timeFmt); err != nil {
+			return nil, err
+		}
+		return o, nil
+
+	case op.kind == opBetween && !op.length:
+		if err := o.compileBetween(op, timeFmt); err != nil {
+			return nil, err
+		}
+		return o, nil
+
+	case op.kind.isString():
+		if !target.IsString() {
+			return nil, fmt.Errorf(
+				"%w, operator '%v' requires a string but got %v",
+				ErrIllegalTargetValue, op, target.ValueType(),
+			)
+		}
+		if err := o.compileString(op); err != nil {
+			return nil, fmt.Errorf("%w, %v", ErrIllegalTargetValue, err)
+		}
+		return o, nil
+
+	case op.kind == opType:
+		if err := o.compileTypes(); err != nil {
+			return nil, err
+		}
+		return o, nil
+
+	case op.kind.isPresence():
+		// exists, isnull, empty 的目标值可以省略 (null), 或者是一个 bool 值, false 表示取反
+		if !target.IsNull() && !target.IsBoolean() && target.ValueType() != jsonvalue.NotExist {
+			return nil, fmt.Errorf(
+				"%w, operator '%v' accepts only null or bool but got %v",
+				ErrIllegalTargetValue, op, target.ValueType(),
+			)
+		}
+		return o, nil
+	}
+}
+
+// checkNumericTarget 检查数值比较的目标值是否为数字, 或者是符合时间格式的字符串
+func checkNumericTarget(op operator, target *jsonvalue.V, timeFmt string) error {
+	if target.IsNumber() {
+		return nil
+	}
+	if timeFmt != "" && target.IsString() {
+		if _, err := time.Parse(timeFmt, target.String()); err != nil {
+			return fmt.Errorf(
+				"%w, '%s' is not a time in format '%s' (%v)",
+				ErrIllegalTargetValue, target.String(), timeFmt, err,
+			)
+		}
+		return nil
+	}
+	return fmt.Errorf(
+		"%w, operator '%v' requires a number or timed string but got %v",
+		ErrIllegalTargetValue, op, target.ValueType(),
+	)
+}
+
+// compileBetween 解析 between 的上下界。目标值可以是 [lo, hi] 数组, 表示闭区间; 也可以是 "(lo,hi]"
+// 这样的区间字符串, 其中圆括号表示开区间, 方括号表示闭区间。时间格式包含逗号时参见 splitInterval
+func (o *operand) compileBetween(op operator, timeFmt string) error {
+	switch {
+	default:
+		return fmt.Errorf(
+			"%w, operator '%v' requires an array [lo, hi] or an interval string but got %v",
+			ErrIllegalTargetValue, op, o.v.ValueType(),
+		)
+
+	case o.v.IsArray():
+		if o.v.Len() != 2 {
+			return fmt.Errorf(
+				"%w, operator '%v' requires 2 bounds but got %d", ErrIllegalTargetValue, op, o.v.Len(),
+			)
+		}
+		o.lo, o.hi = o.v.MustGet(0), o.v.MustGet(1)
+
+	case o.v.IsString():
+		if err := o.parseInterval(timeFmt); err != nil {
+			return err
+		}
+	}
+
+	if err := checkNumericTarget(op, o.lo, timeFmt); err != nil {
+		return err
+	}
+	if err := checkNumericTarget(op, o.hi, timeFmt); err != nil {
+		return err
+	}
+	if o.lo.ValueType() != o.hi.ValueType() {
+		return fmt.Errorf(
+			"%w, bounds of '%v' should have same type but got (%v, %v)",
+			ErrIllegalTargetValue, op, o.lo.ValueType(), o.hi.ValueType(),
+		)
+	}
+	if greater, _ := compareNumber(o.lo, operator{kind: opGreater}, o.hi, timeFmt); greater {
+		return fmt.Errorf("%w, lower bound %v is greater than upper bound %v", ErrIllegalTargetValue, o.lo, o.hi)
+	}
+	return nil
+}
+
+func (o *operand) parseInterval(timeFmt string) error {
+	s := strings.TrimSpace(o.v.String())
+	illegal := fmt.Errorf("%w, illegal interval '%s'", ErrIllegalTargetValue, s)
+
+	if len(s) < 2 {
+		return illegal
+	}
+	switch s[0] {
+	default:
+		return illegal
+	case '(':
+		o.loOpen = true
+	case '[':
+	}
+	switch s[len(s)-1] {
+	default:
+		return illegal
+	case ')':
+		o.hiOpen = true
+	case ']':
+	}
+
+	lo, hi, ok := splitInterval(s[1:len(s)-1], timeFmt)
+	if !ok {
+		return illegal
+	}
+	bound := func(s string) *jsonvalue.V {
+		s = strings.TrimSpace(s)
+		if timeFmt != "" {
+			if _, err := time.Parse(timeFmt, s); err == nil {
+				return jsonvalue.NewString(s)
+			}
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return jsonvalue.NewFloat64(f)
+		}
+		return jsonvalue.NewString(s)
+	}
+	o.lo, o.hi = bound(lo), bound(hi)
+	return nil
+}
+
+// splitInterval 使用唯一的一个分隔逗号将区间分为上下界。时间格式本身可能包含逗号, 如 "Jan 2, 2006", 此时选择
+// 两侧都是合法的时间 (或者数字) 的那一个逗号, 没有或者有多个这样的逗号时返回 false
+func splitInterval(s, timeFmt string) (lo, hi string, ok bool) {
+	if n := strings.Count(s, ","); n == 1 {
+		i := strings.IndexByte(s, ',')
+		return s[:i], s[i+1:], true
+	} else if n == 0 || timeFmt == "" {
+		return "", "", false
+	}
+
+	isBound := func(s string) bool {
+		s = strings.TrimSpace(s)
+		if _, err := time.Parse(timeFmt, s); err == nil {
+			return true
+		}
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != ',' || !isBound(s[:i]) || !isBound(s[i+1:]) {
+			continue
+		}
+		if ok {
+			return "", "", false
+		}
+		lo, hi, ok = s[:i], s[i+1:], true
+	}
+	return lo, hi, ok
+}
+
+func (o *operand) compileTypes() error {
+	add := func(v *jsonvalue.V) error {
+		typ, exist := valueTypeNames[strings.ToLower(v.String())]
+		if !v.IsString() || !exist {
+			return fmt.Errorf("%w, illegal type name %v", ErrIllegalTargetValue, v)
+		}
+		o.types |= 1 << typ
+		return nil
+	}
+
+	if !o.v.IsArray() {
+		return add(o.v)
+	}
+	if o.v.Len() == 0 {
+		return fmt.Errorf("%w, no type name given", ErrIllegalTargetValue)
+	}
+	for _, v := range o.v.ForRangeArr() {
+		if err := add(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *operand) compileString(op operator) error {
+	var expr string
+	switch op.kind {
+	default:
+		if op.caseless {
+			o.lower = strings.ToLower(o.v.String())
+		}
+		return nil
+	case opRegex:
+		expr = o.v.String()
+	case opLike:
+		expr = wildcardToRegex(o.v.String(), '%', '_')
+	case opGlob:
+		expr = wildcardToRegex(o.v.String(), '*', '?')
+	}
+
+	if op.caseless {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	o.re = re
+	return nil
+}
+
+// wildcardToRegex 将通配符表达式转为完整匹配的正则表达式, 反斜杠用于转义
+func wildcardToRegex(pattern string, many, one rune) string {
+	b := strings.Builder{}
+	b.WriteString("(?s)^")
+
+	escaping := false
+	for _, r := range pattern {
+		switch {
+		case escaping:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaping = false
+		case r == '\\':
+			escaping = true
+		case r == many:
+			b.WriteString(".*")
+		case r == one:
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if escaping {
+		b.WriteString(regexp.QuoteMeta("\\"))
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
+// ----------------
+// MARK: compare
+
+func compare(v *jsonvalue.V, op operator, tgt *operand, timeFmt string) (bool, error) {
+	target := tgt.v
+
+	switch op.kind {
+	default:
+		// 所有数值比较的符号都交给这里统一过滤
+		return compareNumber(v, op, target, timeFmt)
+
+	case opContains, opPrefix, opSuffix, opRegex, opLike, opGlob:
+		return compareString(v, op, tgt)
+
+	case opExists, opIsNull, opEmpty, opType:
+		return comparePresence(v, op, tgt), nil
+
+	case opNotEqual:
+		res := !v.Equal(target)
+		debug("%v != %v ? %v", v, target, res)
+		return res, nil
+
+	case opEqual:
+		if v.ValueType() != target.ValueType() {
+			return false, fmt.Errorf(
+				"%w value and target should have same value type, but got (%v, %v)",
+				ErrTypeNotMatch, v.ValueType(), target.ValueType(),
+			)
+		}
+		res := v.Equal(target)
+		debug("%v == %v ? %v", v, target, res)
+		return res, nil
+
+	case opIn:
+		res, err := compareIn(v, target)
+		if op.not {
+			res = !res
+		}
+		return res, err
+
+	case opContainsAll, opContainsAny, opSubsetOf, opSetEqual:
+		return compareSet(v, op, target)
+
+	case opBetween:
+		return compareBetween(v, op, tgt, timeFmt)
+	}
+}
+
+func compareNumber(v *jsonvalue.V, op operator, target *jsonvalue.V, timeFmt string) (bool, error) {
+	formatError := func() error {
+		return fmt.Errorf(
+			"%w, expected both value and target both number or timed string, but got (%v, %v)",
+			ErrTypeNotMatch, v.ValueType(), target.ValueType(),
+		)
+	}
+
+	compareTime := false
+	var leftTime, rightTime time.Time
+	if v.IsNumber() && target.IsNumber() {
+		// OK
+	} else if timeFmt != "" && v.IsString() && target.IsString() {
+		var err error
+		if leftTime, err = time.Parse(timeFmt, v.String()); err != nil {
+			debug("parse time error: %v, source %v", err, v)
+			return false, formatError()
+		}
+		if rightTime, err = time.Parse(timeFmt, target.String()); err != nil {
+			debug("parse time error: %v, source %v", err, target)
+			return false, formatError()
+		}
+		compareTime = true
+	} else {
+		return false, formatError()
+	}
+
+	var res bool
+	switch op.kind {
+	default:
+		return false, fmt.Errorf("%w (%v)", ErrIllegalOperator, op)
+
+	case opLessOrGreater:
+		if compareTime {
+			res = !leftTime.Equal(rightTime)
+		} else {
+			res = !v.Equal(target)
+		}
+		debug("%v != %v ? %v", v, target, res)
+		return res, nil
+
+	case opLess:
+		if compareTime {
+			res = leftTime.Before(rightTime)
+		} else {
+			res = v.Float64() < target.Float64()
+		}
+		debug("%v < %v ? %v", v, target, res)
+		return res, nil
+
+	case opLessOrEqual:
+		if compareTime {
+			res = !leftTime.After(rightTime)
+		} else {
+			res = v.Float64() <= target.Float64()
+		}
+		debug("%v <= %v ? %v", v, target, res)
+		return res, nil
+
+	case opGreater:
+		if compareTime {
+			res = leftTime.After(rightTime)
+		} else {
+			res = v.Float64() > target.Float64()
+		}
+		debug("%v > %v ? %v", v, target, res)
+		return res, nil
+
+	case opGreaterOrEqual:
+		if compareTime {
+			res = !leftTime.Before(rightTime)
+		} else {
+			res = v.Float64() >= target.Float64()
+		}
+		debug("%v >= %v ? %v", v, target, res)
+		return res, nil
+	}
+}
+
+func compareIn(v *jsonvalue.V, target *jsonvalue.V) (bool, error) {
+	if !target.IsArray() {
+		return false, fmt.Errorf(
+			"%w, target value should be an array but got %v",
+			ErrTypeNotMatch, target.ValueType(),
+		)
+	}
+
+	res := false
+	target.RangeArray(func(_ int, subTarget *jsonvalue.V) bool {
+		res = v.Equal(subTarget)
+		return !res
+	})
+	return res, nil
+}
+
+func compareString(v *jsonvalue.V, op operator, tgt *operand) (bool, error) {
+	if !v.IsString() {
+		return false, fmt.Errorf(
+			"%w, operator '%v' expects a string value but got %v",
+			ErrTypeNotMatch, op, v.ValueType(),
+		)
+	}
+
+	s, sub := v.String(), tgt.v.String()
+	if op.caseless {
+		s, sub = strings.ToLower(s), tgt.lower
+	}
+
+	var res bool
+	switch op.kind {
+	default:
+		return false, fmt.Errorf("%w (%v)", ErrIllegalOperator, op)
+	case opContains:
+		res = strings.Contains(s, sub)
+	case opPrefix:
+		res = strings.HasPrefix(s, sub)
+	case opSuffix:
+		res = strings.HasSuffix(s, sub)
+	case opRegex, opLike, opGlob:
+		res = tgt.re.MatchString(v.String())
+	}
+
+	if op.not {
+		res = !res
+	}
+	debug("'%v' %v '%v' ? %v", v, op, tgt.v, res)
+	return res, nil
+}
+
+// comparePresence 检查值的存在性以及类型, v 为 nil 表示 field 不存在
+func comparePresence(v *jsonvalue.V, op operator, tgt *operand) bool {
+	var res bool
+	switch op.kind {
+	case opExists:
+		res = v != nil
+	case opIsNull:
+		res = v != nil && v.IsNull()
+	case opEmpty:
+		// 不存在、null、空字符串、空数组以及空对象均视为空
+		switch {
+		case v == nil || v.IsNull():
+			res = true
+		case v.IsString():
+			res = v.String() == ""
+		case v.IsArray(), v.IsObject():
+			res = v.Len() == 0
+		}
+	case opType:
+		res = v != nil && tgt.types&(1<<v.ValueType()) != 0
+	}
+
+	if tgt.v.IsBoolean() && !tgt.v.Bool() {
+		res = !res
+	}
+	if op.not {
+		res = !res
+	}
+	debug("%v %v %v ? %v", v, op, tgt.v, res)
+	return res
+}
+
+// compareSet 以集合语义比较两个数组, 元素使用 jsonvalue.V.Equal 判断是否相等
+func compareSet(v *jsonvalue.V, op operator, target *jsonvalue.V) (bool, error) {
+	if !v.IsArray() {
+		return false, fmt.Errorf(
+			"%w, operator '%v' expects an array value but got %v",
+			ErrTypeNotMatch, op, v.ValueType(),
+		)
+	}
+
+	// containsAll 表示 sub 中的每一个元素都在 super 中
+	containsAll := func(super, sub *jsonvalue.V) bool {
+		res := true
+		sub.RangeArray(func(_ int, s *jsonvalue.V) bool {
+			res, _ = compareIn(s, super)
+			return res
+		})
+		return res
+	}
+
+	var res bool
+	switch op.kind {
+	default:
+		return false, fmt.Errorf("%w (%v)", ErrIllegalOperator, op)
+	case opContainsAll:
+		res = containsAll(v, target)
+	case opSubsetOf:
+		res = containsAll(target, v)
+	case opSetEqual:
+		res = containsAll(v, target) && containsAll(target, v)
+	case opContainsAny:
+		v.RangeArray(func(_ int, s *jsonvalue.V) bool {
+			res, _ = compareIn(s, target)
+			return !res
+		})
+	}
+
+	if op.not {
+		res = !res
+	}
+	debug("%v %v %v ? %v", v, op, target, res)
+	return res, nil
+}
+
+// compareBetween 检查值是否在区间内
+func compareBetween(v *jsonvalue.V, op operator, tgt *operand, timeFmt string) (bool, error) {
+	lower, upper := operator{kind: opGreaterOrEqual}, operator{kind: opLessOrEqual}
+	if tgt.loOpen {
+		lower.kind = opGreater
+	}
+	if tgt.hiOpen {
+		upper.kind = opLess
+	}
+
+	res, err := compareNumber(v, lower, tgt.lo, timeFmt)
+	if err != nil {
+		return false, err
+	}
+	if res {
+		if res, err = compareNumber(v, upper, tgt.hi, timeFmt); err != nil {
+			return false, err
+		}
+	}
+
+	if op.not {
+		res = !res
+	}
+	debug("%v %v %v ? %v", v, op, tgt.v, res)
+	return res, nil
+}
@@ -1,6 +1,10 @@
 package jsonengine
 
-import "time"
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
 
 // ReturnType 表示如何返回
 type ReturnType uint
@@ -19,9 +23,13 @@ type options struct {
 	whenNotFound     ReturnType
 	whenTypeMismatch ReturnType
 	dateTimeFormat   string
+	workers          int
+	// err 表示不合法的参数, Compile 和 Validate 时返回
+	err error
 }
 
-// OptWhenNotFound 表示当查找不到值时, 如何返回
+// OptWhenNotFound 表示当查找不到值时, 如何返回。ReturnFalse 时, 数组量词中查找不到值的元素 (以及不存在的数组)
+// 视为不满足条件, 因此 [!] 和 [=0] 的结果与 NOT [+] 相同。OptWhenTypeMismatch 同理
 func OptWhenNotFound(typ ReturnType) Option {
 	return func(o *options) {
 		switch typ {
@@ -45,12 +53,22 @@ func OptWhenTypeMismatch(typ ReturnType) Option {
 	}
 }
 
-// OptDateTimeFormat 表示时间格式, Go 格式, 用于当目标是 string 的时候, 检查是不是时间
+// OptDateTimeFormat 表示时间格式, Go 格式, 用于当目标是 string 的时候, 检查是不是时间。不合法的格式 (包括不含
+// 任何时间元素的格式) 会在 Compile 和 Validate 时返回 ErrIllegalOption。空字符串表示不检查时间
 func OptDateTimeFormat(format string) Option {
-	s := time.Now().Format(format)
-	if _, err := time.Parse(format, s); err != nil {
+	if format == "" {
+		return func(o *options) {
+			o.dateTimeFormat = ""
+		}
+	}
+	// 参考时间的每一个元素都与 Go 格式中的写法不同, 因此不含时间元素的格式输出的结果与格式本身相同
+	ref := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	s := ref.Format(format)
+	if _, err := time.Parse(format, s); err != nil || s == format {
 		debug("illegal time format '%s'", format)
-		return func(*options) { /* do nothing */ }
+		return func(o *options) {
+			o.err = fmt.Errorf("%w, illegal time format '%s'", ErrIllegalOption, format)
+		}
 	}
 	debug("valid time format '%s'", format)
 	return func(o *options) {
@@ -58,6 +76,17 @@ func OptDateTimeFormat(format string) Option {
 	}
 }
 
+// OptWorkers 表示 FilterReader 并行匹配所使用的 worker 数量, 小于 1 时使用 runtime.GOMAXPROCS(0)。默认不并行,
+// 对其他的匹配方法没有影响
+func OptWorkers(n int) Option {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return func(o *options) {
+		o.workers = n
+	}
+}
+
 func mergeOptions(opts []Option) *options {
 	o := &options{}
 	for _, f := range opts {
@@ -0,0 +1,596 @@
+package jsonengine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ----------------
+// MARK: Parse
+
+// Parse 解析文本形式的规则, 得到与 JSON 形式完全相同的 Condition, 如:
+//
+//	user.age >= 18 AND (country IN ["CN","US"] OR NOT vip = true) AND items[+].price > 100
+//
+// 语法如下:
+//
+//   - 优先级从高到低依次为 NOT、AND、OR, 也可以写作 !、&&、||, 关键字不区分大小写; 可以使用括号改变优先级
+//   - 叶子条件为 field operator value, 不需要目标值的操作符可以省略 value, 如 name exists
+//   - field 的语法与 Expr.Field 相同, 另外在点分隔语法中 [ 和 { 之前的 . 可以省略, 如 items[+].price;
+//     单独的 @ 表示当前值本身
+//   - operator 支持所有的操作符别名, 如 =、>=、in、not in、!contains、len>=、is null
+//   - value 可以是 JSON 风格的字符串 (也可以使用单引号)、数字、true、false、null 以及由它们组成的数组和 object;
+//     没有引号的 $xxx 或 @xxx 表示引用, 参见 Expr.Ref
+//   - field ANY (...)、field ALL (...)、field NONE (...) 表示数组元素条件, 参见 Condition.Any
+//
+// 语法错误时返回 *ParseError, 其中包含错误的位置
+func Parse(s string) (Condition, error) {
+	p := &textParser{src: s}
+	c, err := p.parseOr()
+	if err != nil {
+		return Condition{}, err
+	}
+	p.skipSpaces()
+	if !p.eof() {
+		return Condition{}, p.errorf(p.pos, "unexpected %s", p.near())
+	}
+	return c, nil
+}
+
+// ----------------
+// MARK: type - ParseError
+
+// ParseError 表示 Parse 的语法错误。errors.Is(err, ErrSyntax) 总是成立, 如果是因为操作符或 field 不合法,
+// 则同时也满足 errors.Is(err, ErrIllegalOperator) 或 errors.Is(err, ErrIllegalField)
+type ParseError struct {
+	// Offset 表示错误在原文中的字节偏移量, 从 0 开始
+	Offset int
+	// Line 和 Column 表示错误所在的行和列, 均从 1 开始, 列以字符为单位
+	Line   int
+	Column int
+	Msg    string
+
+	err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at line %d, column %d: %s", ErrSyntax, e.Line, e.Column, e.Msg)
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.err
+}
+
+// ----------------
+// MARK: type - textParser
+
+// textParser 以递归下降的方式解析文本规则
+type textParser struct {
+	src string
+	pos int
+}
+
+func (p *textParser) errorf(pos int, format string, a ...any) *ParseError {
+	e := &ParseError{Offset: pos, Line: 1, Column: 1, Msg: fmt.Sprintf(format, a...)}
+	for _, c := range p.src[:pos] {
+		if c == '\n' {
+			e.Line, e.Column = e.Line+1, 1
+		} else {
+			e.Column++
+		}
+	}
+	return e
+}
+
+// wrapf 与 errorf 相同, 但是保留 err 以便使用 errors.Is 判断
+func (p *textParser) wrapf(pos int, err error, format string, a ...any) *ParseError {
+	e := p.errorf(pos, format, a...)
+	e.err = err
+	return e
+}
+
+func (p *textParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *textParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *textParser) skipSpaces() {
+	for !p.eof() {
+		c, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if !unicode.IsSpace(c) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+// near 描述当前位置的内容, 用于错误信息
+func (p *textParser) near() string {
+	if p.eof() {
+		return "end of input"
+	}
+	end := p.pos
+	for end < len(p.src) {
+		c, size := utf8.DecodeRuneInString(p.src[end:])
+		if unicode.IsSpace(c) || (end > p.pos && strings.ContainsRune("()[],", c)) {
+			break
+		}
+		end += size
+		if strings.ContainsRune("()[],", c) {
+			break
+		}
+	}
+	return "'" + p.src[p.pos:end] + "'"
+}
+
+// consume 跳过空白之后, 如果接下来的内容是 s, 则跳过并返回 true
+func (p *textParser) consume(s string) bool {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+// keyword 跳过空白之后, 如果接下来是不区分大小写的关键字 kw, 则跳过并返回 true
+func (p *textParser) keyword(kw string) bool {
+	p.skipSpaces()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.src) {
+		// 关键字之后不能紧跟可以出现在 field 中的字符, 如 order.id 不是 or 开头
+		c, _ := utf8.DecodeRuneInString(p.src[end:])
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.-$@#[{", c) {
+			return false
+		}
+	}
+	p.pos = end
+	return true
+}
+
+func (p *textParser) parseOr() (Condition, error) {
+	var or OR
+	for {
+		c, err := p.parseAnd()
+		if err != nil {
+			return Condition{}, err
+		}
+		or = append(or, c)
+		if !p.keyword("or") && !p.consume("||") {
+			break
+		}
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return Condition{OR: or}, nil
+}
+
+func (p *textParser) parseAnd() (Condition, error) {
+	var and AND
+	for {
+		c, err := p.parseNot()
+		if err != nil {
+			return Condition{}, err
+		}
+		and = append(and, c)
+		if !p.keyword("and") && !p.consume("&&") {
+			break
+		}
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return Condition{AND: and}, nil
+}
+
+func (p *textParser) parseNot() (Condition, error) {
+	if p.keyword("not") || p.consume("!") {
+		c, err := p.parseNot()
+		if err != nil {
+			return Condition{}, err
+		}
+		return Condition{NOT: &NOT{Condition: c}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *textParser) parsePrimary() (Condition, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return Condition{}, p.errorf(p.pos, "unexpected end of input, expecting a condition")
+	}
+
+	if p.consume("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return Condition{}, err
+		}
+		if !p.consume(")") {
+			return Condition{}, p.errorf(p.pos, "unexpected %s, expecting ')'", p.near())
+		}
+		return c, nil
+	}
+
+	f, err := p.parseField()
+	if err != nil {
+		return Condition{}, err
+	}
+
+	// 数组元素条件
+	for _, q := range []string{"any", "all", "none"} {
+		save := p.pos
+		if !p.keyword(q) {
+			continue
+		}
+		if !p.consume("(") {
+			p.pos = save
+			break
+		}
+		sub, err := p.parseOr()
+		if err != nil {
+			return Condition{}, err
+		}
+		if !p.consume(")") {
+			return Condition{}, p.errorf(p.pos, "unexpected %s, expecting ')'", p.near())
+		}
+		c := Condition{Expr: Expr{Field: f}}
+		switch q {
+		case "any":
+			c.Any = &sub
+		case "all":
+			c.All = &sub
+		default:
+			c.None = &sub
+		}
+		return c, nil
+	}
+
+	op, err := p.parseOperator()
+	if err != nil {
+		return Condition{}, err
+	}
+	e := Expr{Field: f, Operator: op}
+
+	// 只有不需要目标值的操作符 (exists、isnull、empty 等) 可以省略目标值
+	p.skipSpaces()
+	omitted := p.eof() || p.peek() == ')' || strings.HasPrefix(p.src[p.pos:], "&&") || strings.HasPrefix(p.src[p.pos:], "||")
+	if save := p.pos; !omitted && (p.keyword("and") || p.keyword("or")) {
+		p.pos, omitted = save, true
+	}
+	if omitted {
+		if o, _ := parseOperator(op); !o.kind.isPresence() || o.kind == opType {
+			return Condition{}, p.errorf(p.pos, "unexpected %s, expecting a value", p.near())
+		}
+		return Condition{Expr: e}, nil
+	}
+
+	if c := p.peek(); c == '$' || c == '@' {
+		start := p.pos
+		e.Ref = p.scanPath()
+		if _, err := parseRef(e.Ref); err != nil {
+			return Condition{}, p.wrapf(start, err, "%v", err)
+		}
+		return Condition{Expr: e}, nil
+	}
+
+	if e.Value, err = p.parseValue(); err != nil {
+		return Condition{}, err
+	}
+	return Condition{Expr: e}, nil
+}
+
+// ----------------
+// MARK: field
+
+// parseField 解析条件左侧的 field, 并检查其语法
+func (p *textParser) parseField() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	f := p.scanPath()
+	if f == "" {
+		return "", p.errorf(start, "unexpected %s, expecting a field", p.near())
+	}
+	if f == "@" {
+		return "", nil
+	}
+	if _, err := ParsePathSyntax(f, SyntaxAuto); err != nil {
+		return "", p.wrapf(start, err, "%v", err)
+	}
+	return f, nil
+}
+
+// textOperatorChars 表示符号形式的操作符中可能出现的字符
+const textOperatorChars = "=!<>~≹≸≠≶≷≱≤≦≯≰≥≧≮"
+
+// scanPath 读取一个路径, 直到顶层的空白、括号、逗号或者操作符为止。点分隔语法中省略的 . 会被补上
+func (p *textParser) scanPath() string {
+	src := p.src[p.pos:]
+	pointer := strings.HasPrefix(src, "/")
+	dotted := !pointer && !strings.HasPrefix(src, "$") && !strings.HasPrefix(src, "@")
+
+	b := strings.Builder{}
+	depth := 0
+	var quote rune
+	var prev rune
+
+	for !p.eof() {
+		c, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		switch {
+		case quote != 0:
+			if c == '\\' && p.pos+size < len(p.src) {
+				b.WriteRune(c)
+				p.pos += size
+				c, size = utf8.DecodeRuneInString(p.src[p.pos:])
+			} else if c == quote {
+				quote = 0
+			}
+
+		case c == '\'' || c == '"':
+			quote = c
+
+		case c == '[' || c == '{':
+			if depth == 0 && dotted && b.Len() > 0 && prev != '.' {
+				b.WriteByte('.')
+			}
+			depth++
+
+		case c == ']' || c == '}':
+			if depth == 0 {
+				return b.String()
+			}
+			depth--
+
+		case depth > 0:
+			// 括号内的内容原样保留, 如 [?(@.qty>1)]
+
+		case unicode.IsSpace(c) || c == '(' || c == ')' || c == ',':
+			return b.String()
+
+		case !pointer && strings.ContainsRune(textOperatorChars, c):
+			return b.String()
+		}
+
+		b.WriteRune(c)
+		p.pos += size
+		prev = c
+	}
+	return b.String()
+}
+
+// ----------------
+// MARK: operator
+
+// parseOperator 解析操作符, 支持 not in、len >=、is null 等由多个单词组成的写法
+func (p *textParser) parseOperator() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	w := p.scanOperatorWord()
+	if w == "" {
+		return "", p.errorf(start, "unexpected %s, expecting an operator", p.near())
+	}
+
+	switch lower := strings.ToLower(w); lower {
+	case "not", "!", "len", "is":
+		save := p.pos
+		p.skipSpaces()
+		next := p.scanOperatorWord()
+		if strings.EqualFold(next, "not") && lower != "not" {
+			// len not in, is not null
+			p.skipSpaces()
+			next = p.scanOperatorWord()
+		}
+		if next == "" {
+			p.pos = save
+		}
+	}
+
+	// 尽量保留原文的写法, 如 not in; 不合法时再尝试去掉空格, 如 is null
+	op := strings.Join(strings.Fields(p.src[start:p.pos]), " ")
+	_, err := parseOperator(op)
+	if err != nil && strings.Contains(op, " ") {
+		if _, e := parseOperator(strings.ReplaceAll(op, " ", "")); e == nil {
+			op, err = strings.ReplaceAll(op, " ", ""), nil
+		}
+	}
+	if err != nil {
+		return "", p.wrapf(start, err, "unknown operator '%s'", op)
+	}
+	return op, nil
+}
+
+// scanOperatorWord 读取一个单词或者是一串操作符符号
+func (p *textParser) scanOperatorWord() string {
+	start := p.pos
+	if p.eof() {
+		return ""
+	}
+	c, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+	symbol := strings.ContainsRune(textOperatorChars, c) || c == '*'
+	for !p.eof() {
+		c, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		if symbol {
+			if !strings.ContainsRune(textOperatorChars, c) && c != '*' {
+				break
+			}
+		} else if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+			break
+		}
+		p.pos += size
+	}
+	return p.src[start:p.pos]
+}
+
+// ----------------
+// MARK: value
+
+// parseValue 解析 JSON 风格的字面量, 数字的类型与 UnmarshalJSON 解析 JSON 形式的结果相同, 参见 numberValue
+func (p *textParser) parseValue() (any, error) {
+	p.skipSpaces()
+	if p.eof() {
+		return nil, p.errorf(p.pos, "unexpected end of input, expecting a value")
+	}
+
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.parseString()
+
+	case c == '[':
+		p.pos++
+		arr := []any{}
+		if p.consume("]") {
+			return arr, nil
+		}
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+			if p.consume(",") {
+				continue
+			}
+			if p.consume("]") {
+				return arr, nil
+			}
+			return nil, p.errorf(p.pos, "unexpected %s, expecting ',' or ']'", p.near())
+		}
+
+	case c == '{':
+		p.pos++
+		obj := map[string]any{}
+		if p.consume("}") {
+			return obj, nil
+		}
+		for {
+			p.skipSpaces()
+			if p.eof() || (p.peek() != '"' && p.peek() != '\'') {
+				return nil, p.errorf(p.pos, "unexpected %s, expecting a quoted key", p.near())
+			}
+			k, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(":") {
+				return nil, p.errorf(p.pos, "unexpected %s, expecting ':'", p.near())
+			}
+			if obj[k], err = p.parseValue(); err != nil {
+				return nil, err
+			}
+			if p.consume(",") {
+				continue
+			}
+			if p.consume("}") {
+				return obj, nil
+			}
+			return nil, p.errorf(p.pos, "unexpected %s, expecting ',' or '}'", p.near())
+		}
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		for !p.eof() && strings.IndexByte("+-.0123456789eE", p.peek()) >= 0 {
+			p.pos++
+		}
+		n, err := numberValue(p.src[start:p.pos])
+		if err != nil {
+			return nil, p.errorf(start, "invalid number '%s'", p.src[start:p.pos])
+		}
+		return n, nil
+	}
+
+	for _, kw := range []struct {
+		s string
+		v any
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if p.keyword(kw.s) {
+			return kw.v, nil
+		}
+	}
+	return nil, p.errorf(start, "unexpected %s, expecting a value", p.near())
+}
+
+// parseString 解析单引号或双引号括起来的字符串, 支持 JSON 的转义字符以及 \'
+func (p *textParser) parseString() (string, error) {
+	start, q := p.pos, p.peek()
+	p.pos++
+
+	b := strings.Builder{}
+	for !p.eof() {
+		c, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		switch c {
+		case rune(q):
+			p.pos++
+			return b.String(), nil
+
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				return "", p.errorf(p.pos, "unfinished escape")
+			}
+			esc := p.src[p.pos+1]
+			switch esc {
+			default:
+				return "", p.errorf(p.pos, "invalid escape '\\%c'", esc)
+			case '"', '\'', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				r, err := p.unicodeEscape(p.pos)
+				if err != nil {
+					return "", err
+				}
+				// 与 encoding/json 相同, UTF-16 代理对合并为一个字符, 单独的代理项替换为 U+FFFD
+				if utf16.IsSurrogate(r) {
+					r2, err := p.unicodeEscape(p.pos + 6)
+					if dec := utf16.DecodeRune(r, r2); err == nil && dec != unicode.ReplacementChar {
+						r = dec
+						p.pos += 6
+					} else {
+						r = unicode.ReplacementChar
+					}
+				}
+				b.WriteRune(r)
+				p.pos += 4
+			}
+			p.pos += 2
+			continue
+		}
+		b.WriteString(p.src[p.pos : p.pos+size])
+		p.pos += size
+	}
+	return "", p.errorf(start, "unterminated string")
+}
+
+// unicodeEscape 解析 at 处形如 \uXXXX 的转义字符
+func (p *textParser) unicodeEscape(at int) (rune, error) {
+	if at+6 > len(p.src) || p.src[at] != '\\' || p.src[at+1] != 'u' {
+		return 0, p.errorf(at, "invalid unicode escape")
+	}
+	n, err := strconv.ParseUint(p.src[at+2:at+6], 16, 16)
+	if err != nil {
+		return 0, p.errorf(at, "invalid unicode escape '%s'", p.src[at:at+6])
+	}
+	return rune(n), nil
+}
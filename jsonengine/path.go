@@ -0,0 +1,383 @@
+package jsonengine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----------------
+// MARK: type - Path
+
+// Path 表示解析之后的 field 路径, 可以通过 String 方法还原为规范的字符串形式。
+//
+// 路径的语法如下:
+//
+//   - 各段之间使用 . 分隔, 段前后的空白会被忽略
+//   - 普通的 key 中可以使用反斜杠转义任意字符, 如 a\.b 表示 key "a.b"; [ { ' " 仅在段的开头具有特殊含义,
+//     出现在其他位置时是 key 的一部分, 如 it's、a[b
+//   - 使用单引号或双引号括起来的段表示原样的 key, 如 'a.b'、" key"; 也可以写在方括号中, 如 ["a.b"]。
+//     引号内同样可以使用反斜杠转义
+//   - [N]、[*]、[+]、[!]、[=N]、[+0:3] 等表示数组的下标、量词和切片; {*}、{+} 等表示遍历 object 的所有值
+//   - ..name 表示在任意深度查找 name 字段, 可以出现在开头
+//   - [?(...)] 表示仅匹配满足过滤条件的数组元素中的任意一个, 过滤表达式的语法与 JSONPath 相同, 参见 FieldSyntax
+//   - 最后一段为 # 时表示取长度, 如果要表示名为 # 的 key, 请使用 \# 或 '#'
+type Path []field
+
+// ParsePath 解析 field 路径, 空字符串表示当前值本身, 返回 nil
+func ParsePath(s string) (Path, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	sc := &pathScanner{src: s}
+	return sc.parse()
+}
+
+// String 返回路径的规范字符串形式, 再次解析之后得到相同的路径
+func (p Path) String() string {
+	b := strings.Builder{}
+	for i, f := range p {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		if f.Recursive {
+			if i == 0 {
+				b.WriteString("..")
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// parseField 解析 Field 字段, 空字符串表示当前值本身
+func parseField(f string) ([]field, error) {
+	return ParsePath(f)
+}
+
+// String 返回单个路径段的字符串形式, 不包含递归查找的 .. 前缀
+func (f field) String() string {
+	switch {
+	case f.Length:
+		return "#"
+	case f.Index:
+		return "[" + strconv.Itoa(f.Array.At) + "]"
+	case f.Array.Filter != nil:
+		return "[?(" + f.Array.Filter.raw + ")]"
+	case f.Values != nil:
+		return "{" + f.Values.symbol() + "}"
+	case f.Object != "":
+		return formatKey(f.Object)
+	case f.Array.Slice != nil:
+		return "[" + f.Array.Quant.symbol() + f.Array.Slice.String() + "]"
+	case f.Array.Quant.kind != quantIndex:
+		return "[" + f.Array.Quant.symbol() + "]"
+	default:
+		return "[" + strconv.Itoa(f.Array.At) + "]"
+	}
+}
+
+// symbol 返回量词在路径中的写法
+func (q quantifier) symbol() string {
+	switch q.kind {
+	default:
+		return ""
+	case quantAny:
+		return "+"
+	case quantAll:
+		return "*"
+	case quantNone:
+		return "!"
+	case quantCount:
+		return q.cmp.String() + strconv.Itoa(q.n)
+	}
+}
+
+func (sl *arraySlice) String() string {
+	b := strings.Builder{}
+	if sl.hasStart {
+		b.WriteString(strconv.Itoa(sl.start))
+	}
+	b.WriteByte(':')
+	if sl.hasStop {
+		b.WriteString(strconv.Itoa(sl.stop))
+	}
+	if sl.step != 1 {
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(sl.step))
+	}
+	return b.String()
+}
+
+// formatKey 返回 key 在路径中的写法, 必要时使用双引号括起来
+func formatKey(k string) string {
+	if !needQuote(k) {
+		return k
+	}
+	return quoteKey(k)
+}
+
+// quoteKey 使用双引号括起 key, 并转义其中的双引号和反斜杠
+func quoteKey(k string) string {
+	b := strings.Builder{}
+	b.Grow(len(k) + 2)
+	b.WriteByte('"')
+	for _, c := range k {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func needQuote(k string) bool {
+	if k == "" || k == "#" {
+		return true
+	}
+	if k[0] == '/' || k[0] == '$' {
+		// 避免被识别为 JSON Pointer 或 JSONPath
+		return true
+	}
+	if first, _ := utf8.DecodeRuneInString(k); unicode.IsSpace(first) {
+		return true
+	}
+	if last, _ := utf8.DecodeLastRuneInString(k); unicode.IsSpace(last) {
+		return true
+	}
+	return strings.ContainsAny(k, pathSpecialChars)
+}
+
+// ----------------
+// MARK: type - pathScanner
+
+// pathSpecialChars 表示 String 输出时需要使用引号括起来的 key 中的字符
+const pathSpecialChars = `.\[]{}'"`
+
+// pathScanner 逐个字符地解析 field 路径
+type pathScanner struct {
+	src string
+	pos int
+}
+
+func (sc *pathScanner) errorf(pos int, format string, a ...any) error {
+	return fmt.Errorf("%w '%s', %s at position %d", ErrIllegalField, sc.src, fmt.Sprintf(format, a...), pos)
+}
+
+func (sc *pathScanner) eof() bool {
+	return sc.pos >= len(sc.src)
+}
+
+func (sc *pathScanner) peek() byte {
+	return sc.src[sc.pos]
+}
+
+func (sc *pathScanner) skipSpaces() {
+	for !sc.eof() {
+		c, size := utf8.DecodeRuneInString(sc.src[sc.pos:])
+		if !unicode.IsSpace(c) {
+			return
+		}
+		sc.pos += size
+	}
+}
+
+// consume 如果接下来的内容是 s, 则跳过并返回 true
+func (sc *pathScanner) consume(s string) bool {
+	if !strings.HasPrefix(sc.src[sc.pos:], s) {
+		return false
+	}
+	sc.pos += len(s)
+	return true
+}
+
+func (sc *pathScanner) parse() (Path, error) {
+	var p Path
+
+	// .. 表示递归查找下一段的 key, 也可以出现在开头
+	sc.skipSpaces()
+	recursive := sc.consume("..")
+
+	for {
+		start := sc.pos
+		f, err := sc.segment()
+		if err != nil {
+			return nil, err
+		}
+		if recursive {
+			if f.Object == "" {
+				return nil, sc.errorf(start, "'..' should be followed by a key")
+			}
+			f.Recursive = true
+		}
+		p = append(p, f)
+
+		sc.skipSpaces()
+		if sc.eof() {
+			return p, nil
+		}
+		if f.Length {
+			return nil, sc.errorf(start, "'#' should be the last segment")
+		}
+		if !sc.consume(".") {
+			return nil, sc.errorf(sc.pos, "unexpected '%c', expecting '.'", sc.peek())
+		}
+		recursive = sc.consume(".")
+	}
+}
+
+// segment 解析一个路径段, 不包含分隔符
+func (sc *pathScanner) segment() (field, error) {
+	sc.skipSpaces()
+	if sc.eof() {
+		return field{}, sc.errorf(sc.pos, "unexpected end of path, expecting a segment")
+	}
+
+	switch start := sc.pos; sc.peek() {
+	case '\'', '"':
+		k, err := sc.quoted()
+		if err != nil {
+			return field{}, err
+		}
+		return field{Object: k}, nil
+
+	case '[':
+		sc.pos++
+		sc.skipSpaces()
+		if !sc.eof() && sc.peek() == '?' {
+			return sc.filter()
+		}
+		if !sc.eof() && (sc.peek() == '\'' || sc.peek() == '"') {
+			k, err := sc.quoted()
+			if err != nil {
+				return field{}, err
+			}
+			sc.skipSpaces()
+			if sc.eof() || sc.peek() != ']' {
+				return field{}, sc.errorf(sc.pos, "expecting ']' after quoted key")
+			}
+			sc.pos++
+			return field{Object: k}, nil
+		}
+		inner, err := sc.enclosed(start, ']')
+		if err != nil {
+			return field{}, err
+		}
+		f, ok := parseArrayField("[" + inner + "]")
+		if !ok {
+			return field{}, sc.errorf(start, "invalid array segment '[%s]'", inner)
+		}
+		return f, nil
+
+	case '{':
+		sc.pos++
+		inner, err := sc.enclosed(start, '}')
+		if err != nil {
+			return field{}, err
+		}
+		q, ok := parseQuantifier(strings.TrimSpace(inner))
+		if !ok {
+			return field{}, sc.errorf(start, "invalid object segment '{%s}'", inner)
+		}
+		return field{Values: &q}, nil
+
+	default:
+		return sc.bare()
+	}
+}
+
+// enclosed 读取到 end 为止的内容, 并跳过 end
+func (sc *pathScanner) enclosed(start int, end byte) (string, error) {
+	i := strings.IndexByte(sc.src[sc.pos:], end)
+	if i < 0 {
+		return "", sc.errorf(start, "unclosed '%c'", sc.src[start])
+	}
+	inner := sc.src[sc.pos : sc.pos+i]
+	if j := strings.IndexAny(inner, `[{'"`); j >= 0 {
+		return "", sc.errorf(sc.pos+j, "unexpected '%c'", inner[j])
+	}
+	sc.pos += i + 1
+	return inner, nil
+}
+
+// quoted 读取引号括起来的 key, 不允许为空
+func (sc *pathScanner) quoted() (string, error) {
+	start := sc.pos
+	k, err := sc.quotedString()
+	if err != nil {
+		return "", err
+	}
+	if k == "" {
+		return "", sc.errorf(start, "empty key")
+	}
+	return k, nil
+}
+
+// quotedString 读取引号括起来的字符串, 引号内可以使用反斜杠转义任意字符
+func (sc *pathScanner) quotedString() (string, error) {
+	start, q := sc.pos, sc.peek()
+	sc.pos++
+
+	b := strings.Builder{}
+	for !sc.eof() {
+		c := sc.peek()
+		switch c {
+		case q:
+			sc.pos++
+			return b.String(), nil
+		case '\\':
+			if sc.pos+1 >= len(sc.src) {
+				return "", sc.errorf(sc.pos, "unfinished escape")
+			}
+			sc.pos++
+		}
+		_, size := utf8.DecodeRuneInString(sc.src[sc.pos:])
+		b.WriteString(sc.src[sc.pos : sc.pos+size])
+		sc.pos += size
+	}
+	return "", sc.errorf(start, "unterminated quoted string")
+}
+
+// bare 读取没有引号的 key, 直到未转义的 . 为止, 并忽略前后未转义的空白
+func (sc *pathScanner) bare() (field, error) {
+	start := sc.pos
+	b := strings.Builder{}
+	keep := 0 // 去除末尾空白之后的长度
+	escaped := false
+
+	for !sc.eof() {
+		c := sc.peek()
+		if c == '.' {
+			break
+		}
+		if c == '\\' {
+			if sc.pos+1 >= len(sc.src) {
+				return field{}, sc.errorf(sc.pos, "unfinished escape")
+			}
+			sc.pos++
+			escaped = true
+		}
+
+		r, size := utf8.DecodeRuneInString(sc.src[sc.pos:])
+		b.WriteString(sc.src[sc.pos : sc.pos+size])
+		if c == '\\' || !unicode.IsSpace(r) {
+			// 被转义的字符总是保留
+			keep = b.Len()
+		}
+		sc.pos += size
+	}
+
+	k := b.String()[:keep]
+	if k == "" {
+		return field{}, sc.errorf(start, "empty segment")
+	}
+	if k == "#" && !escaped {
+		return field{Length: true}, nil
+	}
+	return field{Object: k}, nil
+}
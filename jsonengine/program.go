@@ -0,0 +1,531 @@
+package jsonengine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+)
+
+// ----------------
+// MARK: type - Program
+
+// Program 表示编译后的规则。Program 是只读的, 可以在多个 goroutine 中并发使用
+type Program struct {
+	root matcher
+	opt  options
+}
+
+// Compile 编译规则。编译时会检查整个规则树中的操作符、字段路径以及目标值 (包括时间格式), 任何一处
+// 不合法都会返回错误。
+func Compile(cond Condition, opts ...Option) (*Program, error) {
+	o := mergeOptions(opts)
+	if o.err != nil {
+		return nil, o.err
+	}
+	m, err := compileCondition(&cond, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{
+		root: m,
+		opt:  *o,
+	}, nil
+}
+
+// Match 规则匹配, 可以并发调用。
+//
+// value 在整个匹配过程中只会被导入一次, 如果 value 已经是 *jsonvalue.V 类型, 则直接使用而不会复制,
+// 调用方需要保证匹配过程中不修改它。
+func (p *Program) Match(value any) (bool, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return false, err
+	}
+	ctx := &matchContext{
+		opt:  &p.opt,
+		root: v,
+	}
+	return p.root.match(ctx, v)
+}
+
+// importValue 将待匹配的值转为 *jsonvalue.V, 已经解析过的值不再复制
+func importValue(value any) (*jsonvalue.V, error) {
+	if v, ok := value.(*jsonvalue.V); ok && v != nil {
+		return v, nil
+	}
+	return jsonvalue.Import(value)
+}
+
+// ----------------
+// MARK: compile
+
+func compileCondition(cond *Condition, o *options) (matcher, error) {
+	if len(cond.OR) > 0 {
+		m := make(orMatcher, 0, len(cond.OR))
+		for i := range cond.OR {
+			sub, err := compileCondition(&cond.OR[i], o)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, sub)
+		}
+		return m, nil
+	}
+
+	if len(cond.AND) > 0 {
+		m := make(andMatcher, 0, len(cond.AND))
+		for i := range cond.AND {
+			sub, err := compileCondition(&cond.AND[i], o)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, sub)
+		}
+		return m, nil
+	}
+
+	if cond.NOT != nil {
+		sub, err := compileCondition(&cond.NOT.Condition, o)
+		if err != nil {
+			return nil, err
+		}
+		return notMatcher{sub: sub}, nil
+	}
+
+	for _, q := range []struct {
+		cond *Condition
+		q    quantifier
+	}{
+		{cond.Any, quantifier{kind: quantAny}},
+		{cond.All, quantifier{kind: quantAll}},
+		{cond.None, quantifier{kind: quantNone}},
+	} {
+		if q.cond != nil {
+			return compileElem(&cond.Expr, q.q, q.cond, o)
+		}
+	}
+
+	return compileExpr(&cond.Expr, o)
+}
+
+func compileExpr(e *Expr, o *options) (*exprMatcher, error) {
+	chain, err := compileField(e.Field, e.Syntax, o)
+	if err != nil {
+		return nil, err
+	}
+	op, err := parseOperator(e.Operator)
+	if err != nil {
+		return nil, err
+	}
+	if op.length {
+		chain = append(chain, field{Length: true})
+	}
+	target, err := jsonvalue.Import(e.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%v)", ErrImportTargetValue, err)
+	}
+
+	m := &exprMatcher{
+		field: e.Field,
+		chain: chain,
+		op:    op,
+	}
+
+	if e.Ref != "" {
+		if e.Value != nil {
+			return nil, fmt.Errorf("%w, value and ref should not be both given", ErrIllegalTargetValue)
+		}
+		if op.kind.isPresence() {
+			return nil, fmt.Errorf("%w, operator '%v' does not accept a ref", ErrIllegalTargetValue, op)
+		}
+		if m.ref, err = parseRef(e.Ref); err != nil {
+			return nil, err
+		}
+		m.target = &operand{v: target}
+		return m, nil
+	}
+
+	if m.target, err = newOperand(op, target, o.dateTimeFormat); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// compileField 按照指定的语法解析 field, 并编译其中的过滤条件
+func compileField(f string, syntax FieldSyntax, o *options) ([]field, error) {
+	chain, err := ParsePathSyntax(f, syntax)
+	if err != nil {
+		return nil, err
+	}
+	if err := compileFilters(chain, o); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// ----------------
+// MARK: type - reference
+
+// reference 表示 Expr.Ref 所引用的值
+type reference struct {
+	raw string
+	// element 表示相对于当前遍历的数组元素, 否则相对于文档根
+	element bool
+	chain   []field
+}
+
+// parseRef 解析引用, 引用中不允许出现 [+] 和 [*]
+func parseRef(s string) (*reference, error) {
+	ref := &reference{raw: s}
+	path := strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(path, "@"):
+		ref.element, path = true, path[1:]
+	case strings.HasPrefix(path, "$"):
+		path = path[1:]
+	}
+	path = strings.TrimPrefix(path, ".")
+
+	chain, err := parseField(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w, ref '%s' (%v)", ErrIllegalField, s, err)
+	}
+	if !isSingle(chain) {
+		return nil, fmt.Errorf("%w, ref '%s' should refer to exactly one value", ErrIllegalField, s)
+	}
+	ref.chain = chain
+	return ref, nil
+}
+
+// resolve 解析引用的值
+func (ref *reference) resolve(ctx *matchContext) (*jsonvalue.V, error) {
+	v := ctx.root
+	if ref.element && ctx.elem != nil {
+		v = ctx.elem
+	}
+	v, err := resolveChain(v, ref.chain)
+	if err != nil {
+		return nil, fmt.Errorf("resolve ref '%s' error (%w)", ref.raw, err)
+	}
+	return v, nil
+}
+
+// resolveChain 按照不含 [+] / [*] 的 field 解析出唯一的值
+func resolveChain(v *jsonvalue.V, chain []field) (*jsonvalue.V, error) {
+	for _, f := range chain {
+		var err error
+		switch {
+		case f.Length:
+			var n int
+			if n, err = valueLength(v); err == nil {
+				v = jsonvalue.NewInt(n)
+			}
+		case f.Object != "" && !(f.Index && v.IsArray()):
+			v, err = v.Get(f.Object)
+		default:
+			v, err = v.Get(f.Array.At)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// isSingle 表示 field 是否只会解析出唯一的值, 即不含量词以及递归查找
+func isSingle(chain []field) bool {
+	for _, f := range chain {
+		if f.Array.Quant.kind != quantIndex || f.Values != nil || f.Recursive {
+			return false
+		}
+	}
+	return true
+}
+
+// ----------------
+// MARK: type - matcher
+
+// matchContext 表示一次匹配过程中的上下文
+type matchContext struct {
+	opt *options
+
+	// root 表示文档根, elem 表示当前正在遍历的数组元素, 用于解析引用
+	root *jsonvalue.V
+	elem *jsonvalue.V
+
+	// 以下仅在 explain 模式下使用, trace 为 nil 时表示不追踪
+	trace *Explanation
+	path  []string
+}
+
+// tolerate 按照 OptWhenNotFound 以及 OptWhenTypeMismatch 处理匹配过程中的错误
+func (ctx *matchContext) tolerate(err error) (bool, error) {
+	if errors.Is(err, ErrNotFound) && ctx.opt.whenNotFound == ReturnFalse {
+		ctx.swallow(err)
+		return false, nil
+	}
+	if errors.Is(err, ErrTypeNotMatch) && ctx.opt.whenTypeMismatch == ReturnFalse {
+		ctx.swallow(err)
+		return false, nil
+	}
+	return false, err
+}
+
+// matcher 表示编译后的条件节点, 实现必须是只读的
+type matcher interface {
+	match(ctx *matchContext, v *jsonvalue.V) (bool, error)
+
+	// describe 返回一个描述当前节点的 Explanation, 不包含匹配结果
+	describe() *Explanation
+}
+
+type orMatcher []matcher
+
+func (m orMatcher) match(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	for i, sub := range m {
+		b, err := ctx.matchChild(sub, v)
+		if err != nil {
+			ctx.skip(len(m) - i - 1)
+			return false, err
+		}
+		if b {
+			ctx.skip(len(m) - i - 1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m orMatcher) describe() *Explanation {
+	return &Explanation{Type: ExplainOR}
+}
+
+type andMatcher []matcher
+
+func (m andMatcher) match(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	for i, sub := range m {
+		b, err := ctx.matchChild(sub, v)
+		if err != nil {
+			ctx.skip(len(m) - i - 1)
+			return false, err
+		}
+		if !b {
+			ctx.skip(len(m) - i - 1)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m andMatcher) describe() *Explanation {
+	return &Explanation{Type: ExplainAND}
+}
+
+type notMatcher struct {
+	sub matcher
+}
+
+func (m notMatcher) match(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	b, err := ctx.matchChild(m.sub, v)
+	if err != nil {
+		return false, err
+	}
+	return !b, nil
+}
+
+func (m notMatcher) describe() *Explanation {
+	return &Explanation{Type: ExplainNOT}
+}
+
+// exprMatcher 表示编译后的 Expr
+type exprMatcher struct {
+	field  string
+	chain  []field
+	op     operator
+	target *operand
+	ref    *reference
+}
+
+func (e *exprMatcher) describe() *Explanation {
+	return &Explanation{
+		Type:     ExplainExpr,
+		Field:    e.field,
+		Operator: e.op.String(),
+		Value:    e.target.v.MustMarshal(),
+		Ref:      e.refString(),
+	}
+}
+
+func (e *exprMatcher) refString() string {
+	if e.ref == nil {
+		return ""
+	}
+	return e.ref.raw
+}
+
+func (e *exprMatcher) match(ctx *matchContext, v *jsonvalue.V) (bool, error) {
+	b, err := e.matchField(ctx, v, e.chain)
+	if err != nil {
+		debug("got error: '%v'", err)
+		if _, err := ctx.tolerate(err); err != nil {
+			return false, err
+		}
+		// 数组本身不存在或者类型不匹配, 参见 quantifier.missing
+		return firstQuantifier(e.chain).missing(), nil
+	}
+	return b, nil
+}
+
+func (e *exprMatcher) matchField(ctx *matchContext, v *jsonvalue.V, chain []field) (bool, error) {
+	// 当前值比较
+	if len(chain) == 0 {
+		tgt, err := e.operand(ctx)
+		if err != nil {
+			ctx.resolve(v, false, err)
+			return false, err
+		}
+		b, err := compare(v, e.op, tgt, ctx.opt.dateTimeFormat)
+		ctx.resolve(v, b, err)
+		ctx.resolveTarget(e.ref, tgt.v)
+		return b, err
+	}
+
+	top, rest := chain[0], chain[1:]
+
+	// 长度
+	if top.Length {
+		ctx.push("#")
+		defer ctx.pop()
+
+		n, err := valueLength(v)
+		if err != nil {
+			return e.missing(ctx, err)
+		}
+		return e.matchField(ctx, jsonvalue.NewInt(n), rest)
+	}
+
+	// 遍历 object 的所有值, 或者是递归查找某个 key
+	if top.Values != nil || top.Recursive {
+		q := quantifier{kind: quantAny}
+		var values []pathValue
+		if top.Recursive {
+			if values = recursiveValues(v, top.Object, "", nil); len(values) == 0 {
+				return e.missing(ctx, fmt.Errorf("%w, key '%s' not found at any depth", ErrNotFound, top.Object))
+			}
+		} else if v.IsObject() {
+			q, values = *top.Values, objectValues(v)
+		} else {
+			return e.missing(ctx, fmt.Errorf("%w, target to match is not an object", ErrTypeNotMatch))
+		}
+
+		elem := ctx.elem
+		defer func() { ctx.elem = elem }()
+
+		return rangeQuantified(ctx, rangePathValues(values), q, func(i int, subV *jsonvalue.V) (bool, error) {
+			ctx.elem = subV
+			ctx.push(values[i].path)
+			b, err := e.matchField(ctx, subV, rest)
+			ctx.pop()
+			ctx.elem = elem
+			return b, err
+		})
+	}
+
+	// object 就是最简单的单层匹配就行了, JSON Pointer 中的数字 token 遇到数组时按照下标处理
+	if top.Object != "" && !(top.Index && v.IsArray()) {
+		ctx.pushKey(top.Object)
+		defer ctx.pop()
+
+		subV, err := v.Get(top.Object)
+		if err != nil {
+			debug("Get and got error: '%v', top field '%v', value %v", err, top.Object, v)
+			return e.missing(ctx, err)
+		}
+		return e.matchField(ctx, subV, rest)
+	}
+
+	// 以下是数组逻辑
+	if !v.IsArray() {
+		return e.missing(ctx, fmt.Errorf("%w, target to match is not an array", ErrTypeNotMatch))
+	}
+
+	// 记录当前遍历的数组元素, 供 @ 引用使用
+	elem := ctx.elem
+	defer func() { ctx.elem = elem }()
+
+	// 按照量词遍历数组中的元素
+	if q := top.Array.Quant; q.kind != quantIndex {
+		rangeArray := v.RangeArray
+		if top.Array.Slice != nil {
+			rangeArray = top.Array.Slice.rangeFunc(v)
+		}
+		if top.Array.Filter != nil {
+			rangeArray = top.Array.Filter.rangeFunc(ctx, rangeArray)
+		}
+		return rangeQuantified(ctx, rangeArray, q, func(i int, subV *jsonvalue.V) (bool, error) {
+			ctx.elem = subV
+			ctx.pushIndex(i)
+			b, err := e.matchField(ctx, subV, rest)
+			ctx.pop()
+			ctx.elem = elem
+			return b, err
+		})
+	}
+
+	// 如果是指定 array 的具体某个 index, 那也算简单匹配
+	if at := top.Array.At; at < 0 {
+		ctx.pushIndex(v.Len() + at)
+	} else {
+		ctx.pushIndex(at)
+	}
+	defer ctx.pop()
+
+	subV, err := v.Get(top.Array.At)
+	if err != nil {
+		return e.missing(ctx, err)
+	}
+	ctx.elem = subV
+	return e.matchField(ctx, subV, rest)
+}
+
+// operand 返回比较的目标值, 如果是引用则在此时解析
+func (e *exprMatcher) operand(ctx *matchContext) (*operand, error) {
+	if e.ref == nil {
+		return e.target, nil
+	}
+	v, err := e.ref.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tgt, err := newOperand(e.op, v, ctx.opt.dateTimeFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w, ref '%s' (%v)", ErrTypeNotMatch, e.ref.raw, err)
+	}
+	return tgt, nil
+}
+
+// missing 处理 field 无法解析的情况。存在性操作符会将其视为值不存在并正常比较, 其他操作符返回错误
+func (e *exprMatcher) missing(ctx *matchContext, err error) (bool, error) {
+	if e.op.kind.isPresence() {
+		b := comparePresence(nil, e.op, e.target)
+		ctx.resolve(nil, b, nil)
+		return b, nil
+	}
+	ctx.resolve(nil, false, err)
+	return false, err
+}
+
+// valueLength 返回数组长度、对象的 key 数量或者是字符串的字符数
+func valueLength(v *jsonvalue.V) (int, error) {
+	switch {
+	default:
+		return 0, fmt.Errorf("%w, cannot get length of %v", ErrTypeNotMatch, v.ValueType())
+	case v.IsArray(), v.IsObject():
+		return v.Len(), nil
+	case v.IsString():
+		return utf8.RuneCountInString(v.String()), nil
+	}
+}
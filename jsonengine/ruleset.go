@@ -0,0 +1,315 @@
+package jsonengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: type - Rule
+
+// Rule 表示规则集中的一条规则
+type Rule struct {
+	// ID 在规则集中唯一, 不能为空
+	ID string `json:"id" yaml:"id"`
+	// Priority 越大越先匹配, 相同优先级的规则按照在规则集中的顺序匹配
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// Disabled 为 true 时规则仍然会被编译检查, 但是不参与匹配
+	Disabled  bool      `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	Condition Condition `json:"condition" yaml:"condition"`
+	// Payload 为规则命中时返回的任意 JSON 值, 如路由目标、价格或者告警级别
+	Payload any `json:"payload,omitempty" yaml:"payload,omitempty"`
+
+	// Then 和 Else 分别表示规则命中和未命中时执行的动作, 由 Decide 执行
+	Then *Action `json:"then,omitempty" yaml:"then,omitempty"`
+	Else *Action `json:"else,omitempty" yaml:"else,omitempty"`
+}
+
+type ruleWrapping Rule
+
+// UnmarshalJSON 与 Condition 相同, Payload 中的整数不会丢失精度
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	w := &ruleWrapping{}
+	if err := unmarshalExact(b, w); err != nil {
+		return err
+	}
+	v, err := exactNumbers(w.Payload)
+	if err != nil {
+		return err
+	}
+	w.Payload = v
+	*r = Rule(*w)
+	return nil
+}
+
+// UnmarshalYAML 与 Condition 相同, Payload 中数字的类型与 JSON 形式保持一致
+func (r *Rule) UnmarshalYAML(node *yaml.Node) error {
+	w := &ruleWrapping{}
+	if err := node.Decode(w); err != nil {
+		return err
+	}
+	if n := yamlMappingValue(node, "payload"); n != nil {
+		v, err := yamlValue(n)
+		if err != nil {
+			return err
+		}
+		w.Payload = v
+	}
+	*r = Rule(*w)
+	return nil
+}
+
+// ----------------
+// MARK: type - RuleSet
+
+// RuleSet 表示编译后的一组规则。与 Program 相同, RuleSet 是只读的, 可以在多个 goroutine 中并发使用。
+//
+// RuleSet 的 JSON 形式为 {"rules":[...]}, 也可以直接是规则的数组, YAML 形式相同。通过 UnmarshalJSON 或
+// UnmarshalYAML 解析时使用默认的参数编译, 需要指定参数时请使用 UnmarshalRuleSet 或 UnmarshalRuleSetYAML
+type RuleSet struct {
+	// rules 为原始顺序
+	rules []Rule
+	// sorted 为按照优先级排序之后的已启用规则
+	sorted []compiledRule
+}
+
+type compiledRule struct {
+	rule *Rule
+	prog *Program
+	then *compiledAction
+	els  *compiledAction
+}
+
+// Result 表示一条规则的匹配结果
+type Result struct {
+	ID       string `json:"id"`
+	Priority int    `json:"priority,omitempty"`
+	Matched  bool   `json:"matched"`
+	Payload  any    `json:"payload,omitempty"`
+	// Err 表示匹配过程中的错误, 此时 Matched 为 false
+	Err error `json:"-"`
+}
+
+// NewRuleSet 编译一组规则, 规则的 ID 不能为空或重复, 任何一条规则编译失败都会返回错误。rules 中的 Payload
+// 和动作会被深拷贝, 之后修改 rules 不会影响 RuleSet
+func NewRuleSet(rules []Rule, opts ...Option) (*RuleSet, error) {
+	rs := &RuleSet{}
+	if err := rs.compile(rules, opts); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (rs *RuleSet) compile(rules []Rule, opts []Option) error {
+	rs.rules = make([]Rule, len(rules))
+	for i, r := range rules {
+		rs.rules[i] = copyRule(r)
+	}
+	rs.sorted = make([]compiledRule, 0, len(rules))
+
+	ids := make(map[string]struct{}, len(rules))
+	for i := range rs.rules {
+		r := &rs.rules[i]
+		if r.ID == "" {
+			return fmt.Errorf("%w, rule No.%d has no id", ErrIllegalRule, i+1)
+		}
+		if _, exist := ids[r.ID]; exist {
+			return fmt.Errorf("%w, duplicated rule id '%s'", ErrIllegalRule, r.ID)
+		}
+		ids[r.ID] = struct{}{}
+
+		c, err := compileRule(r, opts)
+		if err != nil {
+			return fmt.Errorf("compile rule '%s' error (%w)", r.ID, err)
+		}
+		if !r.Disabled {
+			rs.sorted = append(rs.sorted, c)
+		}
+	}
+
+	sort.SliceStable(rs.sorted, func(i, j int) bool {
+		return rs.sorted[i].rule.Priority > rs.sorted[j].rule.Priority
+	})
+	return nil
+}
+
+func compileRule(r *Rule, opts []Option) (c compiledRule, err error) {
+	c.rule = r
+	if c.prog, err = Compile(r.Condition, opts...); err != nil {
+		return c, err
+	}
+	if c.then, err = compileAction(r.Then); err != nil {
+		return c, err
+	}
+	c.els, err = compileAction(r.Else)
+	return c, err
+}
+
+// copyRule 深拷贝规则的 Payload 以及 Then 和 Else
+func copyRule(r Rule) Rule {
+	r.Payload = copyValue(r.Payload)
+	r.Then = copyAction(r.Then)
+	r.Else = copyAction(r.Else)
+	return r
+}
+
+// Rules 返回规则集中的所有规则, 包括被禁用的规则, 按照原始顺序排列。Payload 和动作均为拷贝
+func (rs *RuleSet) Rules() []Rule {
+	res := make([]Rule, len(rs.rules))
+	for i, r := range rs.rules {
+		res[i] = copyRule(r)
+	}
+	return res
+}
+
+// FirstMatch 按照优先级返回第一条命中的规则, 没有规则命中时返回 false。任何一条规则匹配出错时立即返回错误,
+// 如果希望忽略找不到字段等错误, 请在编译时使用 OptWhenNotFound 等参数
+func (rs *RuleSet) FirstMatch(value any) (Result, bool, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return Result{}, false, err
+	}
+	for _, r := range rs.sorted {
+		b, err := r.prog.Match(v)
+		if err != nil {
+			return Result{}, false, fmt.Errorf("match rule '%s' error (%w)", r.rule.ID, err)
+		}
+		if b {
+			return r.result(true, nil), true, nil
+		}
+	}
+	return Result{}, false, nil
+}
+
+// AllMatches 按照优先级返回所有命中的规则, 出错时的处理与 FirstMatch 相同
+func (rs *RuleSet) AllMatches(value any) ([]Result, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return nil, err
+	}
+	var res []Result
+	for _, r := range rs.sorted {
+		b, err := r.prog.Match(v)
+		if err != nil {
+			return nil, fmt.Errorf("match rule '%s' error (%w)", r.rule.ID, err)
+		}
+		if b {
+			res = append(res, r.result(true, nil))
+		}
+	}
+	return res, nil
+}
+
+// Evaluate 按照优先级匹配所有已启用的规则, 并返回每一条规则的结果。与 FirstMatch 和 AllMatches 不同,
+// 单条规则的错误记录在对应 Result 的 Err 中, 不影响其他规则。value 无法解析时, 每一条规则的 Result 中都带有
+// 该错误
+func (rs *RuleSet) Evaluate(value any) []Result {
+	res := make([]Result, 0, len(rs.sorted))
+	v, err := importValue(value)
+	if err != nil {
+		for _, r := range rs.sorted {
+			res = append(res, r.result(false, err))
+		}
+		return res
+	}
+	for _, r := range rs.sorted {
+		b, err := r.prog.Match(v)
+		res = append(res, r.result(b && err == nil, err))
+	}
+	return res
+}
+
+func (r compiledRule) result(matched bool, err error) Result {
+	return Result{
+		ID:       r.rule.ID,
+		Priority: r.rule.Priority,
+		Matched:  matched,
+		Payload:  copyValue(r.rule.Payload),
+		Err:      err,
+	}
+}
+
+// ----------------
+// MARK: RuleSet serialization
+
+type ruleSetWrapping struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// UnmarshalRuleSet 解析 JSON 形式的规则集, 并使用指定的参数编译
+func UnmarshalRuleSet(b []byte, opts ...Option) (*RuleSet, error) {
+	var rules []Rule
+	if b := bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return nil, err
+		}
+	} else {
+		w := ruleSetWrapping{}
+		if err := json.Unmarshal(b, &w); err != nil {
+			return nil, err
+		}
+		rules = w.Rules
+	}
+	return NewRuleSet(rules, opts...)
+}
+
+// UnmarshalRuleSetYAML 解析 YAML 形式的规则集, 并使用指定的参数编译
+func UnmarshalRuleSetYAML(b []byte, opts ...Option) (*RuleSet, error) {
+	node := yaml.Node{}
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := decodeRules(&node, &rules); err != nil {
+		return nil, err
+	}
+	return NewRuleSet(rules, opts...)
+}
+
+func decodeRules(node *yaml.Node, rules *[]Rule) error {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(rules)
+	}
+	w := ruleSetWrapping{}
+	if err := node.Decode(&w); err != nil {
+		return err
+	}
+	*rules = w.Rules
+	return nil
+}
+
+// UnmarshalJSON 解析 JSON 形式的规则集, 并使用默认参数编译
+func (rs *RuleSet) UnmarshalJSON(b []byte) error {
+	res, err := UnmarshalRuleSet(b)
+	if err != nil {
+		return err
+	}
+	*rs = *res
+	return nil
+}
+
+// UnmarshalYAML 解析 YAML 形式的规则集, 并使用默认参数编译
+func (rs *RuleSet) UnmarshalYAML(node *yaml.Node) error {
+	var rules []Rule
+	if err := decodeRules(node, &rules); err != nil {
+		return err
+	}
+	return rs.compile(rules, nil)
+}
+
+// MarshalJSON 按照原始顺序输出所有规则, 形如 {"rules":[...]}
+func (rs *RuleSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ruleSetWrapping{Rules: rs.rules})
+}
+
+// MarshalYAML 按照原始顺序输出所有规则
+func (rs *RuleSet) MarshalYAML() (any, error) {
+	return ruleSetWrapping{Rules: rs.rules}, nil
+}
@@ -0,0 +1,390 @@
+package jsonengine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ----------------
+// MARK: type - FieldSyntax
+
+// FieldSyntax 表示 Expr.Field 所使用的语法。三种语法最终都会编译为相同的路径, 可以使用 ConvertPath 互相转换。
+//
+// JSON Pointer 遵循 RFC 6901, 如 /items/0/sku, 其中 ~1 表示 /, ~0 表示 ~。数字 token 在数组上表示下标,
+// 在 object 上表示 key。空字符串表示文档根, 暂不支持空 key。
+//
+// JSONPath 仅支持以下子集, 一个 JSONPath 会选出一组值, 其中任意一个满足条件即可:
+//
+//   - $ 表示文档根, .name 或 ['name'] 表示 object 的字段, .* 表示 object 的任意一个值
+//   - [N] 表示数组下标, 可以为负数; [*] 表示数组中的任意一个元素; [start:end:step] 表示切片
+//   - ..name 表示在任意深度查找 name 字段
+//   - [?(expr)] 表示满足过滤条件的元素, expr 中使用 @ 表示当前元素, 如 [?(@.qty>1)]。支持 ==、!=、<、<=、>、>=
+//     以及 =~ (正则), 右侧只能是数字、字符串、true、false 或 null; 只写 @.name 表示字段存在。多个条件之间可以使用
+//     &&、||、! 以及括号组合
+type FieldSyntax string
+
+const (
+	// SyntaxAuto 按照前缀自动识别: 以 / 开头的是 JSON Pointer, 以 $ 开头的是 JSONPath, 其他为点分隔语法。
+	//
+	// 注意这与之前的版本不兼容: 之前 /api.v 表示 key "/api" 下的 v, $.x 表示 key "$" 下的 x。这样的 field 需要
+	// 指定 SyntaxDot, 或者使用引号括起来, 如 '/api'.v
+	SyntaxAuto FieldSyntax = ""
+	// SyntaxDot 表示本包原有的点分隔语法, 参见 Path
+	SyntaxDot FieldSyntax = "dot"
+	// SyntaxPointer 表示 JSON Pointer
+	SyntaxPointer FieldSyntax = "pointer"
+	// SyntaxJSONPath 表示 JSONPath
+	SyntaxJSONPath FieldSyntax = "jsonpath"
+)
+
+// detectSyntax 识别字段的语法
+func detectSyntax(s string) FieldSyntax {
+	if strings.HasPrefix(s, "/") {
+		return SyntaxPointer
+	}
+	if t := strings.TrimSpace(s); t == "$" || strings.HasPrefix(t, "$.") || strings.HasPrefix(t, "$[") {
+		return SyntaxJSONPath
+	}
+	return SyntaxDot
+}
+
+// ParsePathSyntax 按照指定的语法解析路径, SyntaxAuto 表示按照前缀自动识别
+func ParsePathSyntax(s string, syntax FieldSyntax) (Path, error) {
+	if syntax == SyntaxAuto {
+		syntax = detectSyntax(s)
+	}
+	switch syntax {
+	default:
+		return nil, fmt.Errorf("%w '%s', unknown syntax '%s'", ErrIllegalField, s, syntax)
+	case SyntaxDot:
+		return ParsePath(s)
+	case SyntaxPointer:
+		return ParsePointer(s)
+	case SyntaxJSONPath:
+		return ParseJSONPath(s)
+	}
+}
+
+// Format 按照指定的语法输出路径, SyntaxAuto 等同于 SyntaxDot。如果路径无法使用该语法表示, 则返回错误
+func (p Path) Format(syntax FieldSyntax) (string, error) {
+	switch syntax {
+	default:
+		return "", fmt.Errorf("%w '%v', unknown syntax '%s'", ErrIllegalField, p, syntax)
+	case SyntaxAuto, SyntaxDot:
+		return p.String(), nil
+	case SyntaxPointer:
+		return p.Pointer()
+	case SyntaxJSONPath:
+		return p.JSONPath()
+	}
+}
+
+// ConvertPath 将路径从一种语法转换为另一种语法, from 为 SyntaxAuto 时按照前缀自动识别
+func ConvertPath(s string, from, to FieldSyntax) (string, error) {
+	p, err := ParsePathSyntax(s, from)
+	if err != nil {
+		return "", err
+	}
+	return p.Format(to)
+}
+
+// ----------------
+// MARK: JSON Pointer
+
+// ParsePointer 解析 RFC 6901 JSON Pointer, 空字符串表示文档根
+func ParsePointer(s string) (Path, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("%w '%s', JSON Pointer should start with '/'", ErrIllegalField, s)
+	}
+
+	tokens := strings.Split(s[1:], "/")
+	p := make(Path, 0, len(tokens))
+	pos := 1
+	for _, tok := range tokens {
+		k, err := unescapePointerToken(tok)
+		if err != nil {
+			return nil, fmt.Errorf("%w '%s', %v at position %d", ErrIllegalField, s, err, pos)
+		}
+		if k == "" {
+			return nil, fmt.Errorf("%w '%s', empty key at position %d", ErrIllegalField, s, pos)
+		}
+		f := field{Object: k}
+		if isPointerIndex(k) {
+			if n, err := strconv.ParseInt(k, 10, 32); err == nil {
+				f.Index, f.Array.At = true, int(n)
+			}
+		}
+		p = append(p, f)
+		pos += len(tok) + 1
+	}
+	return p, nil
+}
+
+func unescapePointerToken(tok string) (string, error) {
+	if !strings.Contains(tok, "~") {
+		return tok, nil
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] != '~' {
+			b.WriteByte(tok[i])
+			continue
+		}
+		switch {
+		case i+1 < len(tok) && tok[i+1] == '0':
+			b.WriteByte('~')
+		case i+1 < len(tok) && tok[i+1] == '1':
+			b.WriteByte('/')
+		default:
+			return "", fmt.Errorf("invalid escape '~' in token '%s'", tok)
+		}
+		i++
+	}
+	return b.String(), nil
+}
+
+// isPointerIndex 判断 token 是否符合 RFC 6901 中数组下标的格式, 即不带前导 0 的非负整数
+func isPointerIndex(tok string) bool {
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return false
+	}
+	for i := 0; i < len(tok); i++ {
+		if tok[i] < '0' || tok[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Pointer 将路径输出为 JSON Pointer, 路径中不能包含量词、递归查找、长度以及负数下标
+func (p Path) Pointer() (string, error) {
+	b := strings.Builder{}
+	for _, f := range p {
+		b.WriteByte('/')
+		switch {
+		case f.Length || f.Recursive || f.Values != nil || f.Array.Quant.kind != quantIndex:
+			return "", fmt.Errorf("%w '%v', segment '%v' can not be expressed as a JSON Pointer", ErrIllegalField, p, f)
+		case f.Index || f.Object == "":
+			if f.Array.At < 0 {
+				return "", fmt.Errorf("%w '%v', negative index can not be expressed as a JSON Pointer", ErrIllegalField, p)
+			}
+			b.WriteString(strconv.Itoa(f.Array.At))
+		default:
+			b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(f.Object))
+		}
+	}
+	return b.String(), nil
+}
+
+// ----------------
+// MARK: JSONPath
+
+// ParseJSONPath 解析 JSONPath, 支持的子集参见 FieldSyntax
+func ParseJSONPath(s string) (Path, error) {
+	sc := &pathScanner{src: s}
+	sc.skipSpaces()
+	if !sc.consume("$") {
+		return nil, sc.errorf(sc.pos, "JSONPath should start with '$'")
+	}
+	p, err := sc.jsonPathSegments()
+	if err != nil {
+		return nil, err
+	}
+	sc.skipSpaces()
+	if !sc.eof() {
+		return nil, sc.errorf(sc.pos, "unexpected '%c'", sc.peek())
+	}
+	return p, nil
+}
+
+// jsonPathSegments 解析 $ 或 @ 之后的 JSONPath 路径段, 遇到不属于路径的字符时停止
+func (sc *pathScanner) jsonPathSegments() (Path, error) {
+	var p Path
+	for !sc.eof() {
+		start := sc.pos
+		switch {
+		case sc.consume(".."):
+			f, err := sc.jsonPathMember(start)
+			if err != nil {
+				return nil, err
+			}
+			if f.Object == "" {
+				return nil, sc.errorf(start, "'..' should be followed by a key")
+			}
+			f.Recursive = true
+			p = append(p, f)
+
+		case sc.consume("."):
+			if sc.consume("*") {
+				p = append(p, field{Values: &quantifier{kind: quantAny}})
+				continue
+			}
+			f, err := sc.jsonPathMember(start)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, f)
+
+		case sc.peek() == '[':
+			f, err := sc.jsonPathBracket()
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, f)
+
+		default:
+			return p, nil
+		}
+	}
+	return p, nil
+}
+
+// jsonPathMember 解析 . 或 .. 之后的字段名, 也可以是 ['name'] 的形式
+func (sc *pathScanner) jsonPathMember(start int) (field, error) {
+	if !sc.eof() && sc.peek() == '[' {
+		return sc.jsonPathBracket()
+	}
+	begin := sc.pos
+	for !sc.eof() {
+		c, size := utf8.DecodeRuneInString(sc.src[sc.pos:])
+		if !isJSONPathNameChar(c, sc.pos == begin) {
+			break
+		}
+		sc.pos += size
+	}
+	if sc.pos == begin {
+		return field{}, sc.errorf(start, "expecting a member name")
+	}
+	return field{Object: sc.src[begin:sc.pos]}, nil
+}
+
+// isJSONPathNameChar 判断字符是否可以出现在 JSONPath 的简写字段名中
+func isJSONPathNameChar(c rune, first bool) bool {
+	if c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(c) {
+		return c != utf8.RuneError
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// jsonPathBracket 解析 [...] 形式的路径段
+func (sc *pathScanner) jsonPathBracket() (field, error) {
+	start := sc.pos
+	sc.pos++
+	sc.skipSpaces()
+	if sc.eof() {
+		return field{}, sc.errorf(start, "unclosed '['")
+	}
+
+	switch sc.peek() {
+	case '?':
+		return sc.filter()
+
+	case '\'', '"':
+		k, err := sc.quoted()
+		if err != nil {
+			return field{}, err
+		}
+		sc.skipSpaces()
+		if sc.consume(",") {
+			return field{}, sc.errorf(start, "union is not supported")
+		}
+		if !sc.consume("]") {
+			return field{}, sc.errorf(sc.pos, "expecting ']' after quoted key")
+		}
+		return field{Object: k}, nil
+	}
+
+	inner, err := sc.enclosed(start, ']')
+	if err != nil {
+		return field{}, err
+	}
+	switch s := strings.TrimSpace(inner); {
+	case s == "*":
+		return field{Array: arrayField{Quant: quantifier{kind: quantAny}}}, nil
+	case strings.Contains(s, ","):
+		return field{}, sc.errorf(start, "union is not supported")
+	case strings.Contains(s, ":"):
+		sl, ok := parseArraySlice(s)
+		if !ok {
+			return field{}, sc.errorf(start, "invalid slice '[%s]'", inner)
+		}
+		return field{Array: arrayField{Quant: quantifier{kind: quantAny}, Slice: sl}}, nil
+	default:
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return field{}, sc.errorf(start, "invalid array index '[%s]'", inner)
+		}
+		return field{Array: arrayField{At: int(n)}}, nil
+	}
+}
+
+// JSONPath 将路径输出为 JSONPath。JSONPath 只能表示 "任意一个" 的语义, 因此路径中不能包含 [*]、[!]
+// 等其他量词以及长度
+func (p Path) JSONPath() (string, error) {
+	b := strings.Builder{}
+	b.WriteByte('$')
+	for _, f := range p {
+		seg, ok := jsonPathSegment(f)
+		if !ok {
+			return "", fmt.Errorf("%w '%v', segment '%v' can not be expressed as a JSONPath", ErrIllegalField, p, f)
+		}
+		b.WriteString(seg)
+	}
+	return b.String(), nil
+}
+
+func jsonPathSegment(f field) (string, bool) {
+	switch {
+	case f.Length:
+		return "", false
+	case f.Index:
+		return "[" + strconv.Itoa(f.Array.At) + "]", true
+	case f.Recursive:
+		return ".." + strings.TrimPrefix(jsonPathMember(f.Object), "."), true
+	case f.Object != "":
+		return jsonPathMember(f.Object), true
+	case f.Values != nil:
+		return ".*", f.Values.kind == quantAny
+	case f.Array.Quant.kind == quantIndex:
+		return "[" + strconv.Itoa(f.Array.At) + "]", true
+	case f.Array.Quant.kind != quantAny:
+		return "", false
+	case f.Array.Filter != nil:
+		return "[?(" + f.Array.Filter.raw + ")]", true
+	case f.Array.Slice != nil:
+		return "[" + f.Array.Slice.String() + "]", true
+	default:
+		return "[*]", true
+	}
+}
+
+// jsonPathMember 返回字段在 JSONPath 中的写法, 必要时使用 ['name'] 的形式
+func jsonPathMember(k string) string {
+	simple := true
+	for i, c := range k {
+		if !isJSONPathNameChar(c, i == 0) {
+			simple = false
+			break
+		}
+	}
+	if simple {
+		return "." + k
+	}
+
+	b := strings.Builder{}
+	b.WriteString("['")
+	for _, c := range k {
+		if c == '\'' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString("']")
+	return b.String()
+}
@@ -0,0 +1,1143 @@
+package jsonengine
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	jsonvalue "github.com/Andrew-M-C/go.jsonvalue"
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: type - HitPolicy
+
+// HitPolicy 表示决策表的命中策略, 与 DMN 相同
+type HitPolicy string
+
+const (
+	// HitUnique 表示至多只有一行命中, 加载时会检查行之间的重叠以及遗漏的输入
+	HitUnique HitPolicy = "unique"
+	// HitFirst 表示按照行的顺序取第一个命中的行
+	HitFirst HitPolicy = "first"
+	// HitPriority 表示在所有命中的行中, 取输出在 OutputOrder 中最靠前的行
+	HitPriority HitPolicy = "priority"
+	// HitAny 表示可以有多行命中, 但是它们的输出必须相同
+	HitAny HitPolicy = "any"
+	// HitCollect 表示收集所有命中的行的输出, 可以通过 Aggregation 进行汇总
+	HitCollect HitPolicy = "collect"
+)
+
+// Aggregation 表示 collect 命中策略的汇总方式, 为空时输出所有命中的行的输出组成的数组
+type Aggregation string
+
+const (
+	AggregateNone  Aggregation = ""
+	AggregateSum   Aggregation = "sum"
+	AggregateMin   Aggregation = "min"
+	AggregateMax   Aggregation = "max"
+	AggregateCount Aggregation = "count"
+)
+
+// hitPolicyAbbreviations DMN 中命中策略的缩写
+var hitPolicyAbbreviations = map[string]struct {
+	policy HitPolicy
+	agg    Aggregation
+}{
+	"u": {HitUnique, AggregateNone}, "f": {HitFirst, AggregateNone}, "p": {HitPriority, AggregateNone},
+	"a": {HitAny, AggregateNone}, "c": {HitCollect, AggregateNone},
+	"c+": {HitCollect, AggregateSum}, "c<": {HitCollect, AggregateMin},
+	"c>": {HitCollect, AggregateMax}, "c#": {HitCollect, AggregateCount},
+}
+
+// parseHitPolicy 解析命中策略, 支持全称以及 DMN 的缩写, 如 U、C+。为空时为 unique
+func parseHitPolicy(p HitPolicy, agg Aggregation) (HitPolicy, Aggregation, error) {
+	s := strings.ToLower(strings.TrimSpace(string(p)))
+	if a, exist := hitPolicyAbbreviations[s]; exist {
+		if a.agg != AggregateNone {
+			if agg != AggregateNone && agg != a.agg {
+				return "", "", fmt.Errorf("%w, hit policy '%s' conflicts with aggregation '%s'", ErrIllegalRule, p, agg)
+			}
+			agg = a.agg
+		}
+		s = string(a.policy)
+	}
+
+	switch HitPolicy(s) {
+	case "":
+		s = string(HitUnique)
+	case HitUnique, HitFirst, HitPriority, HitAny, HitCollect:
+	default:
+		return "", "", fmt.Errorf("%w, unknown hit policy '%s'", ErrIllegalRule, p)
+	}
+
+	switch a := Aggregation(strings.ToLower(strings.TrimSpace(string(agg)))); a {
+	case AggregateNone:
+		return HitPolicy(s), a, nil
+	case AggregateSum, AggregateMin, AggregateMax, AggregateCount:
+		if HitPolicy(s) != HitCollect {
+			return "", "", fmt.Errorf("%w, aggregation '%s' is only allowed in collect hit policy", ErrIllegalRule, agg)
+		}
+		return HitPolicy(s), a, nil
+	default:
+		return "", "", fmt.Errorf("%w, unknown aggregation '%s'", ErrIllegalRule, agg)
+	}
+}
+
+// ----------------
+// MARK: type - TableDefinition
+
+// TableDefinition 表示决策表的定义, 每一列为一个输入字段, 每一行为一条规则, 行的最后是输出
+type TableDefinition struct {
+	// HitPolicy 为空时为 unique, 也可以使用 DMN 的缩写, 如 U、F、P、A、C、C+、C<、C>、C#
+	HitPolicy   HitPolicy   `json:"hit_policy,omitempty"  yaml:"hit_policy,omitempty"`
+	Aggregation Aggregation `json:"aggregation,omitempty" yaml:"aggregation,omitempty"`
+	// Inputs 为每一列的输入字段, 写法与 Expr.Field 相同
+	Inputs []string `json:"inputs" yaml:"inputs"`
+	// OutputOrder 为 priority 命中策略所使用的输出优先级, 越靠前优先级越高, 所有行的输出都必须在其中
+	OutputOrder []any `json:"output_order,omitempty" yaml:"output_order,omitempty"`
+	// Default 为没有任何行命中时的输出。unique 表格给出 Default 时不再检查遗漏的输入
+	Default any        `json:"default,omitempty" yaml:"default,omitempty"`
+	Rows    []TableRow `json:"rows" yaml:"rows"`
+}
+
+// TableRow 表示决策表中的一行。
+//
+// When 中的每一个单元格对应一列输入, 可以是:
+//
+//   - null、"" 或 "-", 表示任意值
+//   - 数字或布尔值, 表示等于该值
+//   - 形如 ">= 18"、"in ['CN', 'US']"、"between [1, 5]"、"exists" 的字符串, 即 Parse 中省略了 field 的叶子条件
+//   - 形如 "18"、"\"gold\"" 的 JSON 字面量, 表示等于该值; 其他无法解析的字符串 (不以操作符开头) 表示等于该字符串本身,
+//     如 gold
+//   - 形如 [">=", 18] 或 ["exists"] 的数组, 即省略了 field 的 SQL 风格表达式, 值为 {"ref":"xxx"} 时表示引用
+type TableRow struct {
+	ID     string `json:"id,omitempty"     yaml:"id,omitempty"`
+	When   []any  `json:"when"             yaml:"when"`
+	Output any    `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+type (
+	tableDefinitionWrapping TableDefinition
+	tableRowWrapping        TableRow
+)
+
+// UnmarshalJSON 与 Condition 相同, OutputOrder 和 Default 中的整数不会丢失精度
+func (def *TableDefinition) UnmarshalJSON(b []byte) error {
+	w := &tableDefinitionWrapping{}
+	if err := unmarshalExact(b, w); err != nil {
+		return err
+	}
+	for i, o := range w.OutputOrder {
+		v, err := exactNumbers(o)
+		if err != nil {
+			return err
+		}
+		w.OutputOrder[i] = v
+	}
+	v, err := exactNumbers(w.Default)
+	if err != nil {
+		return err
+	}
+	w.Default = v
+	*def = TableDefinition(*w)
+	return nil
+}
+
+// UnmarshalJSON 与 Condition 相同, 单元格和输出中的整数不会丢失精度
+func (row *TableRow) UnmarshalJSON(b []byte) error {
+	w := &tableRowWrapping{}
+	if err := unmarshalExact(b, w); err != nil {
+		return err
+	}
+	for i, c := range w.When {
+		v, err := exactNumbers(c)
+		if err != nil {
+			return err
+		}
+		w.When[i] = v
+	}
+	v, err := exactNumbers(w.Output)
+	if err != nil {
+		return err
+	}
+	w.Output = v
+	*row = TableRow(*w)
+	return nil
+}
+
+// ----------------
+// MARK: type - DecisionTable
+
+// DecisionTable 表示编译之后的决策表, 与 Program 相同, 是只读的, 可以在多个 goroutine 中并发使用
+type DecisionTable struct {
+	def    TableDefinition
+	policy HitPolicy
+	agg    Aggregation
+	rows   []tableRow
+	// rank 为 priority 命中策略中每一行输出的优先级, 越小越优先
+	rank []int
+}
+
+type tableRow struct {
+	name  string
+	cells []*Expr // nil 表示任意值
+	prog  *Program
+}
+
+// TableResult 表示决策表的匹配结果
+type TableResult struct {
+	// Output 为按照命中策略得到的输出, 没有命中任何行时为 Default (collect 中的 count 为 0)
+	Output any `json:"output"`
+	// Matched 为所有命中的行的下标, 从 0 开始
+	Matched []int `json:"matched"`
+}
+
+// NewDecisionTable 编译决策表。没有任何行或者任何一个单元格不合法时返回 ErrIllegalRule; unique 和 any 表格中
+// 有重叠的行、unique 表格中有遗漏的输入时, 返回的错误为 ValidationErrors, 分别满足
+// errors.Is(err, ErrTableOverlap) 和 errors.Is(err, ErrTableGap)。
+//
+// def 会被深拷贝, 编译之后调用方再修改 def 不会影响决策表
+func NewDecisionTable(def TableDefinition, opts ...Option) (*DecisionTable, error) {
+	policy, agg, err := parseHitPolicy(def.HitPolicy, def.Aggregation)
+	if err != nil {
+		return nil, err
+	}
+	if len(def.Inputs) == 0 {
+		return nil, fmt.Errorf("%w, decision table has no input", ErrIllegalRule)
+	}
+	o := mergeOptions(opts)
+	if o.err != nil {
+		return nil, o.err
+	}
+	if len(def.Rows) == 0 {
+		return nil, fmt.Errorf("%w, decision table has no rows", ErrIllegalRule)
+	}
+
+	def = copyTableDefinition(def)
+	dt := &DecisionTable{def: def, policy: policy, agg: agg}
+	for i := range def.Rows {
+		r, err := compileTableRow(&def, i, opts)
+		if err != nil {
+			return nil, err
+		}
+		dt.rows = append(dt.rows, r)
+	}
+
+	if err := dt.checkOutputs(); err != nil {
+		return nil, err
+	}
+	if err := dt.checkCompleteness(o); err != nil {
+		return nil, err
+	}
+	return dt, nil
+}
+
+func compileTableRow(def *TableDefinition, i int, opts []Option) (tableRow, error) {
+	row := &def.Rows[i]
+	r := tableRow{name: row.ID, cells: make([]*Expr, len(def.Inputs))}
+	if r.name == "" {
+		r.name = fmt.Sprintf("rows[%d]", i)
+	}
+	if len(row.When) != len(def.Inputs) {
+		return r, fmt.Errorf("%w, %s has %d cells but the table has %d inputs",
+			ErrIllegalRule, r.name, len(row.When), len(def.Inputs))
+	}
+
+	var and AND
+	for j, cell := range row.When {
+		e, err := parseCell(cell)
+		if err != nil {
+			return r, fmt.Errorf("%s, input '%s': %w", r.name, def.Inputs[j], err)
+		}
+		if e == nil {
+			continue
+		}
+		e.Field = def.Inputs[j]
+		r.cells[j] = e
+		and = append(and, Condition{Expr: *e})
+	}
+
+	cond := Condition{AND: and}
+	if len(and) == 0 {
+		// 所有单元格都是任意值
+		cond = Condition{Expr: Expr{Operator: "exists"}}
+	}
+	p, err := Compile(cond, opts...)
+	if err != nil {
+		return r, fmt.Errorf("%s: %w", r.name, err)
+	}
+	r.prog = p
+	return r, nil
+}
+
+// parseCell 解析单元格, 返回 nil 表示任意值
+func parseCell(cell any) (*Expr, error) {
+	switch c := cell.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return parseTextCell(c)
+	case []any:
+		if len(c) != 1 && len(c) != 2 {
+			return nil, fmt.Errorf("%w, SQL style cell should have length 1 or 2, but got %d", ErrIllegalRule, len(c))
+		}
+		op, ok := c[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%w, operator of SQL style cell should be a string", ErrIllegalRule)
+		}
+		e := &Expr{Operator: op}
+		if len(c) == 2 {
+			e.setSQLStyleValue(c[1])
+		}
+		return e, nil
+	default:
+		// 数字、布尔值等
+		return &Expr{Operator: "=", Value: c}, nil
+	}
+}
+
+func parseTextCell(s string) (*Expr, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return nil, nil
+	}
+
+	leaf := func(text string) (*Expr, error) {
+		c, err := Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		if !c.isLeaf() || c.Field != "" {
+			return nil, fmt.Errorf("%w, cell should be a single condition", ErrSyntax)
+		}
+		return &c.Expr, nil
+	}
+
+	e, err := leaf("@ " + s)
+	if err == nil {
+		return e, nil
+	}
+	// 以操作符开头时一定是写错了, 而不是不带引号的字符串
+	if r, _ := utf8.DecodeRuneInString(s); strings.ContainsRune(textOperatorChars, r) {
+		return nil, fmt.Errorf("%w, illegal cell '%s' (%v)", ErrIllegalRule, s, err)
+	}
+	if _, e := (&textParser{src: s}).parseOperator(); e == nil {
+		return nil, fmt.Errorf("%w, illegal cell '%s' (%v)", ErrIllegalRule, s, err)
+	}
+	if e, err := leaf("@ = " + s); err == nil {
+		return e, nil
+	}
+	// 不带引号的字符串
+	return &Expr{Operator: "=", Value: s}, nil
+}
+
+// checkOutputs 检查 priority 以及汇总所需要的输出
+func (dt *DecisionTable) checkOutputs() error {
+	switch {
+	case dt.policy == HitPriority:
+		if len(dt.def.OutputOrder) == 0 {
+			return fmt.Errorf("%w, priority hit policy requires output_order", ErrIllegalRule)
+		}
+		dt.rank = make([]int, len(dt.rows))
+		for i, r := range dt.def.Rows {
+			dt.rank[i] = -1
+			for j, o := range dt.def.OutputOrder {
+				if outputEqual(r.Output, o) {
+					dt.rank[i] = j
+					break
+				}
+			}
+			if dt.rank[i] < 0 {
+				return fmt.Errorf("%w, output of %s is not in output_order", ErrIllegalRule, dt.rows[i].name)
+			}
+		}
+
+	case dt.agg == AggregateSum || dt.agg == AggregateMin || dt.agg == AggregateMax:
+		for i, r := range dt.def.Rows {
+			if _, ok := toFloat(r.Output); !ok {
+				return fmt.Errorf("%w, output of %s should be a number for aggregation '%s'",
+					ErrIllegalRule, dt.rows[i].name, dt.agg)
+			}
+		}
+	}
+	return nil
+}
+
+// outputEqual 按照 JSON 语义比较两个输出, 如 2 与 2.0 相同。无法转为 JSON 的值按照 reflect.DeepEqual 比较
+func outputEqual(a, b any) bool {
+	va, errA := jsonvalue.Import(a)
+	vb, errB := jsonvalue.Import(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return va.Equal(vb)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// Definition 返回决策表定义的深拷贝
+func (dt *DecisionTable) Definition() TableDefinition {
+	return copyTableDefinition(dt.def)
+}
+
+// copyTableDefinition 深拷贝决策表的定义, 单元格和输出的拷贝参见 copyValue
+func copyTableDefinition(def TableDefinition) TableDefinition {
+	res := def
+	res.Inputs = append([]string(nil), def.Inputs...)
+	if def.OutputOrder != nil {
+		res.OutputOrder = make([]any, len(def.OutputOrder))
+		for i, o := range def.OutputOrder {
+			res.OutputOrder[i] = copyValue(o)
+		}
+	}
+	res.Default = copyValue(def.Default)
+	if def.Rows != nil {
+		res.Rows = make([]TableRow, len(def.Rows))
+		for i, row := range def.Rows {
+			when := make([]any, len(row.When))
+			for j, c := range row.When {
+				when[j] = copyValue(c)
+			}
+			res.Rows[i] = TableRow{ID: row.ID, When: when, Output: copyValue(row.Output)}
+		}
+	}
+	return res
+}
+
+// ----------------
+// MARK: DecisionTable.Evaluate
+
+// Evaluate 按照命中策略匹配决策表, 可以并发调用。unique 表格有多行命中、any 表格命中的行输出不同时返回
+// ErrTableOverlap; 匹配出错时的处理与 RuleSet.FirstMatch 相同。返回的 Output 每次都是新的拷贝
+func (dt *DecisionTable) Evaluate(value any) (TableResult, error) {
+	v, err := importValue(value)
+	if err != nil {
+		return TableResult{}, err
+	}
+
+	res := TableResult{Matched: []int{}}
+	for i, r := range dt.rows {
+		b, err := r.prog.Match(v)
+		if err != nil {
+			return TableResult{}, fmt.Errorf("match %s error (%w)", r.name, err)
+		}
+		if !b {
+			continue
+		}
+		res.Matched = append(res.Matched, i)
+		if dt.policy == HitFirst {
+			break
+		}
+	}
+
+	if len(res.Matched) == 0 {
+		res.Output = copyValue(dt.def.Default)
+		if dt.agg == AggregateCount {
+			res.Output = float64(0)
+		}
+		return res, nil
+	}
+
+	output := func(i int) any {
+		return copyValue(dt.def.Rows[i].Output)
+	}
+	switch dt.policy {
+	case HitUnique:
+		if len(res.Matched) > 1 {
+			return TableResult{}, fmt.Errorf("%w, %s are all matched in unique table", ErrTableOverlap, dt.names(res.Matched))
+		}
+		res.Output = output(res.Matched[0])
+
+	case HitAny:
+		for _, i := range res.Matched[1:] {
+			if !outputEqual(dt.def.Rows[i].Output, dt.def.Rows[res.Matched[0]].Output) {
+				return TableResult{}, fmt.Errorf("%w, %s are matched with different outputs in any table",
+					ErrTableOverlap, dt.names(res.Matched))
+			}
+		}
+		res.Output = output(res.Matched[0])
+
+	case HitPriority:
+		best := res.Matched[0]
+		for _, i := range res.Matched[1:] {
+			if dt.rank[i] < dt.rank[best] {
+				best = i
+			}
+		}
+		res.Output = output(best)
+
+	case HitCollect:
+		res.Output = dt.aggregate(res.Matched)
+
+	default: // HitFirst
+		res.Output = output(res.Matched[0])
+	}
+	return res, nil
+}
+
+func (dt *DecisionTable) aggregate(matched []int) any {
+	if dt.agg == AggregateCount {
+		return float64(len(matched))
+	}
+	if dt.agg == AggregateNone {
+		list := make([]any, 0, len(matched))
+		for _, i := range matched {
+			list = append(list, copyValue(dt.def.Rows[i].Output))
+		}
+		return list
+	}
+
+	res, _ := toFloat(dt.def.Rows[matched[0]].Output)
+	for _, i := range matched[1:] {
+		f, _ := toFloat(dt.def.Rows[i].Output)
+		switch {
+		case dt.agg == AggregateSum:
+			res += f
+		case dt.agg == AggregateMin && f < res, dt.agg == AggregateMax && f > res:
+			res = f
+		}
+	}
+	return res
+}
+
+func (dt *DecisionTable) names(rows []int) string {
+	s := make([]string, 0, len(rows))
+	for _, i := range rows {
+		s = append(s, dt.rows[i].name)
+	}
+	return strings.Join(s, ", ")
+}
+
+// ----------------
+// MARK: DecisionTable serialization
+
+// UnmarshalDecisionTable 解析 JSON 形式的决策表, 并使用指定的参数编译
+func UnmarshalDecisionTable(b []byte, opts ...Option) (*DecisionTable, error) {
+	def := TableDefinition{}
+	if err := json.Unmarshal(b, &def); err != nil {
+		return nil, err
+	}
+	return NewDecisionTable(def, opts...)
+}
+
+// UnmarshalDecisionTableYAML 解析 YAML 形式的决策表, 并使用指定的参数编译。结构与 JSON 形式相同
+func UnmarshalDecisionTableYAML(b []byte, opts ...Option) (*DecisionTable, error) {
+	def, err := decodeTableYAML(b)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecisionTable(def, opts...)
+}
+
+// decodeTableYAML 将 YAML 转为 JSON 之后再解析, 保证单元格和输出的类型与 JSON 形式一致
+func decodeTableYAML(b []byte) (TableDefinition, error) {
+	def := TableDefinition{}
+	node := yaml.Node{}
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return def, err
+	}
+	v, err := yamlValue(&node)
+	if err != nil {
+		return def, err
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return def, err
+	}
+	err = json.Unmarshal(j, &def)
+	return def, err
+}
+
+// ReadDecisionTableCSV 读取 CSV 形式的决策表, 并使用指定的参数编译。CSV 的格式与 DMN 的表格相同:
+//
+//   - 第一行为表头, 第一格为命中策略 (如 U、first、C+), 之后为各个输入字段, 最后一格为输出的名称
+//   - 之后的每一行为一条规则, 第一格为行的 ID (可以为空), 之后为各个单元格, 最后一格为输出
+//
+// 输出是合法的 JSON 时按照 JSON 解析, 如 0.1、true、"gold"、{"a":1}, 否则为字符串本身。空行会被忽略
+func ReadDecisionTableCSV(r io.Reader, opts ...Option) (*DecisionTable, error) {
+	rd := csv.NewReader(r)
+	rd.TrimLeadingSpace = true
+	records, err := rd.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w, empty CSV decision table", ErrIllegalRule)
+	}
+
+	header := records[0]
+	if len(header) < 3 {
+		return nil, fmt.Errorf("%w, CSV header should have hit policy, at least one input and output", ErrIllegalRule)
+	}
+	def := TableDefinition{HitPolicy: HitPolicy(header[0])}
+	for _, f := range header[1 : len(header)-1] {
+		def.Inputs = append(def.Inputs, strings.TrimSpace(f))
+	}
+
+	for _, rec := range records[1:] {
+		row := TableRow{ID: strings.TrimSpace(rec[0])}
+		for _, cell := range rec[1 : len(rec)-1] {
+			row.When = append(row.When, cell)
+		}
+		out := strings.TrimSpace(rec[len(rec)-1])
+		if v, err := csvOutput(out); err == nil {
+			row.Output = v
+		} else {
+			row.Output = out
+		}
+		def.Rows = append(def.Rows, row)
+	}
+	return NewDecisionTable(def, opts...)
+}
+
+// csvOutput 按照 JSON 解析 CSV 中的输出, 整数不会丢失精度
+func csvOutput(s string) (any, error) {
+	var v any
+	if err := unmarshalExact([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return exactNumbers(v)
+}
+
+// WriteCSV 将决策表输出为 ReadDecisionTableCSV 可以读取的 CSV 形式。OutputOrder 和 Default 无法使用 CSV 表示,
+// 会被忽略
+func (dt *DecisionTable) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	policy := string(dt.policy)
+	for abbr, p := range hitPolicyAbbreviations {
+		if p.policy == dt.policy && p.agg == dt.agg && dt.agg != AggregateNone {
+			policy = strings.ToUpper(abbr)
+		}
+	}
+	header := append([]string{policy}, dt.def.Inputs...)
+	if err := cw.Write(append(header, "output")); err != nil {
+		return err
+	}
+
+	for i, r := range dt.rows {
+		rec := []string{dt.def.Rows[i].ID}
+		for _, e := range r.cells {
+			rec = append(rec, cellString(e))
+		}
+		out, err := marshalValue(dt.def.Rows[i].Output)
+		if err != nil {
+			return err
+		}
+		// 读取时无法按照 JSON 解析的字符串可以不加引号
+		if s, ok := dt.def.Rows[i].Output.(string); ok && s == strings.TrimSpace(s) && !json.Valid([]byte(s)) {
+			out = []byte(s)
+		}
+		if err := cw.Write(append(rec, string(out))); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// cellString 返回单元格的文本形式, 即省略了 field 的叶子条件
+func cellString(e *Expr) string {
+	if e == nil {
+		return "-"
+	}
+	c := Condition{Expr: *e}
+	c.Field = ""
+	return strings.TrimPrefix(c.String(), "@ ")
+}
+
+// UnmarshalJSON 解析 JSON 形式的决策表, 并使用默认参数编译
+func (dt *DecisionTable) UnmarshalJSON(b []byte) error {
+	res, err := UnmarshalDecisionTable(b)
+	if err != nil {
+		return err
+	}
+	*dt = *res
+	return nil
+}
+
+// UnmarshalYAML 解析 YAML 形式的决策表, 并使用默认参数编译
+func (dt *DecisionTable) UnmarshalYAML(node *yaml.Node) error {
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	res, err := UnmarshalDecisionTableYAML(b)
+	if err != nil {
+		return err
+	}
+	*dt = *res
+	return nil
+}
+
+// MarshalJSON 输出决策表的定义
+func (dt *DecisionTable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dt.def)
+}
+
+// MarshalYAML 输出决策表的定义
+func (dt *DecisionTable) MarshalYAML() (any, error) {
+	return dt.Definition(), nil
+}
+
+// ----------------
+// MARK: completeness
+
+// checkCompleteness 检查 unique 和 any 表格中的重叠, 以及 unique 表格中遗漏的输入。只有可以分析的单元格
+// (任意值、等于、不等于、in、数值比较以及 between) 才会参与检查, 无法分析的情况下不会报告问题
+func (dt *DecisionTable) checkCompleteness(o *options) error {
+	if dt.policy != HitUnique && dt.policy != HitAny {
+		return nil
+	}
+
+	domains := make([][]cellDomain, len(dt.rows))
+	for i, r := range dt.rows {
+		domains[i] = make([]cellDomain, len(r.cells))
+		for j, e := range r.cells {
+			domains[i][j] = newCellDomain(e, o)
+		}
+	}
+
+	var errs ValidationErrors
+	for i := range dt.rows {
+		for j := 0; j < i; j++ {
+			if dt.policy == HitAny && outputEqual(dt.def.Rows[i].Output, dt.def.Rows[j].Output) {
+				continue
+			}
+			if overlapped(domains[i], domains[j]) {
+				errs = append(errs, &ValidationError{
+					Path: dt.rows[i].name,
+					Err:  fmt.Errorf("%w with %s", ErrTableOverlap, dt.rows[j].name),
+				})
+			}
+		}
+	}
+
+	if dt.policy == HitUnique && dt.def.Default == nil {
+		g := &gapFinder{inputs: dt.def.Inputs, domains: domains}
+		if g.analyzable() {
+			all := make([]int, len(dt.rows))
+			for i := range all {
+				all[i] = i
+			}
+			g.find(0, all, nil)
+			for _, gap := range g.gaps {
+				errs = append(errs, &ValidationError{
+					Err: fmt.Errorf("%w, no row matches %s", ErrTableGap, gap),
+				})
+			}
+		}
+	}
+	return errs.orNil()
+}
+
+type domainKind uint8
+
+const (
+	domainUnknown domainKind = iota
+	domainAny
+	domainNumber
+	domainDiscrete
+)
+
+// cellDomain 表示单元格所接受的值的集合
+type cellDomain struct {
+	kind domainKind
+	// ivs 为数值的区间, 已经排序且互不相交
+	ivs []interval
+	// vals 为离散值的 JSON 形式, negate 为 true 时表示除了 vals 以外的所有值
+	vals   map[string]struct{}
+	negate bool
+}
+
+// interval 表示数值区间, 无界时使用 ±Inf
+type interval struct {
+	lo, hi         float64
+	loOpen, hiOpen bool
+}
+
+func newCellDomain(e *Expr, o *options) cellDomain {
+	if e == nil {
+		return cellDomain{kind: domainAny}
+	}
+	m, err := compileExpr(e, o)
+	if err != nil || m.ref != nil || m.op.length || m.op.caseless {
+		return cellDomain{}
+	}
+	t := m.target.v
+
+	inf := posInf()
+	var d cellDomain
+	switch m.op.kind {
+	default:
+		return cellDomain{}
+	case opEqual, opNotEqual, opLessOrGreater:
+		d = discreteOrPoints([]*jsonvalue.V{t})
+		if m.op.kind != opEqual {
+			if d.kind != domainNumber && m.op.kind == opLessOrGreater {
+				return cellDomain{}
+			}
+			d = d.complement()
+		}
+	case opIn:
+		var list []*jsonvalue.V
+		t.RangeArray(func(_ int, v *jsonvalue.V) bool {
+			list = append(list, v)
+			return true
+		})
+		d = discreteOrPoints(list)
+	case opLess, opLessOrEqual, opGreater, opGreaterOrEqual:
+		if !t.IsNumber() {
+			return cellDomain{}
+		}
+		f := t.Float64()
+		iv := map[opKind]interval{
+			opLess:           {lo: -inf, hi: f, loOpen: true, hiOpen: true},
+			opLessOrEqual:    {lo: -inf, hi: f, loOpen: true},
+			opGreater:        {lo: f, hi: inf, loOpen: true, hiOpen: true},
+			opGreaterOrEqual: {lo: f, hi: inf, hiOpen: true},
+		}[m.op.kind]
+		d = cellDomain{kind: domainNumber, ivs: []interval{iv}}
+	case opBetween:
+		lo, hi := m.target.lo, m.target.hi
+		if lo == nil || hi == nil || !lo.IsNumber() || !hi.IsNumber() {
+			return cellDomain{}
+		}
+		iv := interval{lo: lo.Float64(), hi: hi.Float64(), loOpen: m.target.loOpen, hiOpen: m.target.hiOpen}
+		d = cellDomain{kind: domainNumber, ivs: []interval{iv}}
+	}
+
+	if m.op.not {
+		d = d.complement()
+	}
+	return d
+}
+
+// discreteOrPoints 数字全部转为单点区间, 其他标量转为离散值, 两者混合时无法分析
+func discreteOrPoints(list []*jsonvalue.V) cellDomain {
+	nums, others := 0, 0
+	d := cellDomain{vals: map[string]struct{}{}}
+	for _, v := range list {
+		switch {
+		case v.IsNumber():
+			nums++
+			f := v.Float64()
+			d.ivs = append(d.ivs, interval{lo: f, hi: f})
+		case v.IsString(), v.IsBoolean(), v.IsNull():
+			others++
+			d.vals[v.MustMarshalString()] = struct{}{}
+		default:
+			return cellDomain{}
+		}
+	}
+	switch {
+	case nums > 0 && others > 0:
+		return cellDomain{}
+	case nums > 0:
+		d.kind, d.vals = domainNumber, nil
+		d.ivs = normalizeIntervals(d.ivs)
+	default:
+		d.kind, d.ivs = domainDiscrete, nil
+	}
+	return d
+}
+
+func (d cellDomain) complement() cellDomain {
+	switch d.kind {
+	case domainNumber:
+		inf := posInf()
+		var res []interval
+		prev := interval{lo: -inf, hi: -inf, loOpen: true, hiOpen: true}
+		for _, iv := range append(d.ivs, interval{lo: inf, hi: inf, loOpen: true, hiOpen: true}) {
+			gap := interval{lo: prev.hi, hi: iv.lo, loOpen: !prev.hiOpen, hiOpen: !iv.loOpen}
+			if prev.hi == -inf {
+				gap.loOpen = true
+			}
+			if iv.lo == inf {
+				gap.hiOpen = true
+			}
+			if !gap.empty() {
+				res = append(res, gap)
+			}
+			prev = iv
+		}
+		return cellDomain{kind: domainNumber, ivs: res}
+	case domainDiscrete:
+		d.negate = !d.negate
+		return d
+	default:
+		return cellDomain{}
+	}
+}
+
+func posInf() float64 {
+	return math.Inf(1)
+}
+
+func (iv interval) empty() bool {
+	return iv.lo > iv.hi || (iv.lo == iv.hi && (iv.loOpen || iv.hiOpen))
+}
+
+func (iv interval) contains(f float64) bool {
+	if f < iv.lo || f > iv.hi {
+		return false
+	}
+	return !(f == iv.lo && iv.loOpen) && !(f == iv.hi && iv.hiOpen)
+}
+
+func (iv interval) intersects(other interval) bool {
+	lo, loOpen := iv.lo, iv.loOpen
+	if other.lo > lo || (other.lo == lo && other.loOpen) {
+		lo, loOpen = other.lo, other.loOpen
+	}
+	hi, hiOpen := iv.hi, iv.hiOpen
+	if other.hi < hi || (other.hi == hi && other.hiOpen) {
+		hi, hiOpen = other.hi, other.hiOpen
+	}
+	return !interval{lo: lo, hi: hi, loOpen: loOpen, hiOpen: hiOpen}.empty()
+}
+
+// normalizeIntervals 排序并去掉重复的单点区间
+func normalizeIntervals(ivs []interval) []interval {
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i].lo < ivs[j].lo })
+	res := ivs[:0]
+	for _, iv := range ivs {
+		if len(res) > 0 && res[len(res)-1] == iv {
+			continue
+		}
+		res = append(res, iv)
+	}
+	return res
+}
+
+// intersects 表示两个集合一定有交集, 无法确定时返回 false
+func (d cellDomain) intersects(other cellDomain) bool {
+	switch {
+	case d.kind == domainUnknown || other.kind == domainUnknown:
+		return false
+	case d.kind == domainAny || other.kind == domainAny:
+		return true
+	case d.kind != other.kind:
+		return false
+	case d.kind == domainNumber:
+		for _, a := range d.ivs {
+			for _, b := range other.ivs {
+				if a.intersects(b) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// 离散值
+	if d.negate && other.negate {
+		return true
+	}
+	if d.negate {
+		d, other = other, d
+	}
+	for v := range d.vals {
+		if _, in := other.vals[v]; in != other.negate {
+			return true
+		}
+	}
+	return false
+}
+
+func overlapped(a, b []cellDomain) bool {
+	for i := range a {
+		if !a[i].intersects(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ----------------
+// MARK: type - gapFinder
+
+// maxReportedGaps 表示最多报告的遗漏数量
+const maxReportedGaps = 10
+
+// gapFinder 逐列将输入空间切分为互不相交的片段, 检查是否存在没有任何行覆盖的片段
+type gapFinder struct {
+	inputs  []string
+	domains [][]cellDomain
+	gaps    []string
+}
+
+// analyzable 表示所有单元格都可以分析, 并且每一列中的单元格类型一致
+func (g *gapFinder) analyzable() bool {
+	for col := range g.inputs {
+		kind := domainAny
+		for _, row := range g.domains {
+			switch k := row[col].kind; {
+			case k == domainUnknown:
+				return false
+			case k == domainAny:
+			case kind == domainAny:
+				kind = k
+			case k != kind:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// piece 表示某一列中的一个片段, desc 为其文字描述, 为空表示任意值
+type piece struct {
+	desc     string
+	contains func(cellDomain) bool
+}
+
+func (g *gapFinder) find(col int, rows []int, desc []string) {
+	if len(g.gaps) >= maxReportedGaps {
+		return
+	}
+	if len(rows) == 0 {
+		g.gaps = append(g.gaps, strings.Join(desc, ", "))
+		return
+	}
+	if col == len(g.inputs) {
+		return
+	}
+
+	for _, p := range g.pieces(col, rows) {
+		var covered []int
+		for _, r := range rows {
+			if d := g.domains[r][col]; d.kind == domainAny || p.contains(d) {
+				covered = append(covered, r)
+			}
+		}
+		next := desc
+		if p.desc != "" {
+			next = append(append([]string(nil), desc...), p.desc)
+		}
+		g.find(col+1, covered, next)
+	}
+}
+
+// pieces 按照所有行在该列中的边界切分片段
+func (g *gapFinder) pieces(col int, rows []int) []piece {
+	field := g.inputs[col]
+	var points []float64
+	vals := map[string]struct{}{}
+	kind := domainAny
+	for _, r := range rows {
+		d := g.domains[r][col]
+		if d.kind != domainAny {
+			kind = d.kind
+		}
+		for _, iv := range d.ivs {
+			points = append(points, iv.lo, iv.hi)
+		}
+		for v := range d.vals {
+			vals[v] = struct{}{}
+		}
+	}
+
+	switch kind {
+	case domainNumber:
+		return numberPieces(field, points)
+	case domainDiscrete:
+		return discretePieces(field, vals)
+	default:
+		return []piece{{contains: func(cellDomain) bool { return true }}}
+	}
+}
+
+func numberPieces(field string, points []float64) []piece {
+	inf := posInf()
+	var ps []float64
+	for _, p := range points {
+		if p != inf && p != -inf {
+			ps = append(ps, p)
+		}
+	}
+	ivs := make([]interval, 0, len(ps))
+	for _, p := range ps {
+		ivs = append(ivs, interval{lo: p, hi: p})
+	}
+	ivs = normalizeIntervals(ivs)
+
+	num := func(f float64) string {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	var res []piece
+	add := func(desc string, rep float64) {
+		res = append(res, piece{desc: desc, contains: func(d cellDomain) bool {
+			for _, iv := range d.ivs {
+				if iv.contains(rep) {
+					return true
+				}
+			}
+			return false
+		}})
+	}
+
+	if len(ivs) == 0 {
+		add(field+" is any number", 0)
+		return res
+	}
+	add(field+" < "+num(ivs[0].lo), ivs[0].lo-1)
+	for i, iv := range ivs {
+		add(field+" = "+num(iv.lo), iv.lo)
+		if i+1 < len(ivs) {
+			next := ivs[i+1].lo
+			add(fmt.Sprintf("%s < %s < %s", num(iv.lo), field, num(next)), iv.lo+(next-iv.lo)/2)
+		}
+	}
+	last := ivs[len(ivs)-1].lo
+	add(field+" > "+num(last), last+1)
+	return res
+}
+
+func discretePieces(field string, vals map[string]struct{}) []piece {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var res []piece
+	for _, k := range keys {
+		k := k
+		res = append(res, piece{desc: field + " = " + k, contains: func(d cellDomain) bool {
+			_, in := d.vals[k]
+			return in != d.negate
+		}})
+	}
+
+	// 布尔值只有 true 和 false 两种
+	if len(keys) == 2 && keys[0] == "false" && keys[1] == "true" {
+		return res
+	}
+	desc := field + " not in [" + strings.Join(keys, ", ") + "]"
+	if len(keys) == 0 {
+		desc = field + " is any value"
+	}
+	res = append(res, piece{desc: desc, contains: func(d cellDomain) bool { return d.negate }})
+	return res
+}
@@ -0,0 +1,334 @@
+package jsonengine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: type - ValidationError
+
+// ValidationError 表示规则中某一个节点的问题
+type ValidationError struct {
+	// Path 表示问题所在的节点, 如 and[2].not.or[0], 空字符串表示根节点或者是与节点无关的问题 (如参数不合法)
+	Path string
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrors 包含规则中的所有问题, 由 Validate、UnmarshalStrict 和 UnmarshalYAMLStrict 返回。
+// 可以使用 errors.Is 判断其中是否包含某一类错误, 使用 errors.As 获取第一个 *ValidationError
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "%d problems found: ", len(errs))
+	for i, e := range errs {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// Is 表示是否有任意一个问题满足 errors.Is
+func (errs ValidationErrors) Is(target error) bool {
+	for _, e := range errs {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 支持 errors.As 获取第一个 *ValidationError
+func (errs ValidationErrors) As(target any) bool {
+	if p, ok := target.(**ValidationError); ok && len(errs) > 0 {
+		*p = errs[0]
+		return true
+	}
+	return false
+}
+
+func (errs ValidationErrors) orNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ----------------
+// MARK: Validate
+
+// Validate 检查整个规则树, 返回所有的问题而不是第一个。除了 Compile 所检查的操作符、字段路径、目标值以及参数
+// 以外, 还会检查 Compile 会忽略的结构问题:
+//
+//   - 同一个节点中同时给出了 or、and、not、any、all、none 中的多个
+//   - or、and、not 节点中给出了 field、op、value 等叶子条件的字段
+//   - any、all、none 节点中给出了 op、value 或 ref
+//   - 叶子条件缺少 op
+//
+// 返回的错误为 ValidationErrors, 每一个问题都带有节点的路径, 如 and[2].not.or[0]: illegal operator (=>)
+func (c Condition) Validate(opts ...Option) error {
+	o := mergeOptions(opts)
+	v := &validator{opt: o}
+	if o.err != nil {
+		v.add("", o.err)
+	}
+	v.condition(&c, "")
+	return v.errs.orNil()
+}
+
+type validator struct {
+	opt  *options
+	errs ValidationErrors
+}
+
+func (v *validator) add(path string, err error) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Err: err})
+}
+
+func (v *validator) condition(c *Condition, path string) {
+	var kinds []string
+	for _, k := range []struct {
+		name  string
+		given bool
+	}{
+		{"or", len(c.OR) > 0},
+		{"and", len(c.AND) > 0},
+		{"not", c.NOT != nil},
+		{"any", c.Any != nil},
+		{"all", c.All != nil},
+		{"none", c.None != nil},
+	} {
+		if k.given {
+			kinds = append(kinds, k.name)
+		}
+	}
+	if len(kinds) > 1 {
+		v.add(path, fmt.Errorf(
+			"%w, only one of or, and, not, any, all and none can be given, but got %s",
+			ErrIllegalCondition, strings.Join(kinds, ", "),
+		))
+	}
+	if len(kinds) == 0 {
+		v.expr(&c.Expr, path)
+		return
+	}
+
+	for i := range c.OR {
+		v.condition(&c.OR[i], fmt.Sprintf("%s[%d]", joinRulePath(path, "or"), i))
+	}
+	for i := range c.AND {
+		v.condition(&c.AND[i], fmt.Sprintf("%s[%d]", joinRulePath(path, "and"), i))
+	}
+	if c.NOT != nil {
+		v.condition(&c.NOT.Condition, joinRulePath(path, "not"))
+	}
+
+	// 数组元素条件的 field 指向数组, 其他的复合条件不使用任何叶子条件的字段
+	elem := false
+	for _, q := range []struct {
+		name string
+		sub  *Condition
+		q    quantifier
+	}{
+		{"any", c.Any, quantifier{kind: quantAny}},
+		{"all", c.All, quantifier{kind: quantAll}},
+		{"none", c.None, quantifier{kind: quantNone}},
+	} {
+		if q.sub == nil {
+			continue
+		}
+		if !elem {
+			elem = true
+			if _, err := compileElemField(&c.Expr, q.q); err != nil {
+				v.add(path, err)
+			}
+		}
+		v.condition(q.sub, joinRulePath(path, q.name))
+	}
+
+	var ignored []string
+	for _, k := range []struct {
+		name  string
+		given bool
+	}{
+		{"field", c.Field != "" && !elem},
+		{"syntax", c.Syntax != "" && !elem},
+		{"op", c.Operator != ""},
+		{"value", c.Value != nil},
+		{"ref", c.Ref != ""},
+	} {
+		if k.given {
+			ignored = append(ignored, k.name)
+		}
+	}
+	if len(ignored) > 0 {
+		v.add(path, fmt.Errorf(
+			"%w, %s should not be given in '%s' condition", ErrIllegalCondition, strings.Join(ignored, ", "), kinds[0],
+		))
+	}
+}
+
+func (v *validator) expr(e *Expr, path string) {
+	if strings.TrimSpace(e.Operator) == "" {
+		v.add(path, fmt.Errorf("%w, missing op", ErrIllegalOperator))
+		if _, err := compileField(e.Field, e.Syntax, v.opt); err != nil {
+			v.add(path, err)
+		}
+		return
+	}
+	if _, err := compileExpr(e, v.opt); err != nil {
+		v.add(path, err)
+	}
+}
+
+// joinRulePath 拼接规则中节点的路径
+func joinRulePath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// ----------------
+// MARK: strict decode
+
+// UnmarshalStrict 严格地解析 JSON 形式的规则: 除了 UnmarshalJSON 支持的所有写法之外, 还会拒绝未知的 key
+// 以及类型不对的字段, 并且在解析之后调用 Validate。所有的问题以 ValidationErrors 的形式一起返回
+func UnmarshalStrict(b []byte, opts ...Option) (Condition, error) {
+	return unmarshalStrict(b, opts, func(b []byte) (any, error) {
+		var v any
+		err := json.Unmarshal(b, &v)
+		return v, err
+	}, json.Unmarshal)
+}
+
+// UnmarshalYAMLStrict 与 UnmarshalStrict 相同, 但是解析 YAML 形式的规则
+func UnmarshalYAMLStrict(b []byte, opts ...Option) (Condition, error) {
+	return unmarshalStrict(b, opts, func(b []byte) (any, error) {
+		node := yaml.Node{}
+		if err := yaml.Unmarshal(b, &node); err != nil {
+			return nil, err
+		}
+		return yamlValue(&node)
+	}, yaml.Unmarshal)
+}
+
+func unmarshalStrict(
+	b []byte, opts []Option, decodeAny func([]byte) (any, error), decode func([]byte, any) error,
+) (Condition, error) {
+	raw, err := decodeAny(b)
+	if err != nil {
+		return Condition{}, ValidationErrors{{Err: err}}
+	}
+
+	// 结构不对时 decode 返回的错误没有路径, 因此先检查一遍
+	v := &validator{}
+	v.shape(raw, "")
+	if len(v.errs) > 0 {
+		return Condition{}, v.errs
+	}
+
+	c := Condition{}
+	if err := decode(b, &c); err != nil {
+		return Condition{}, ValidationErrors{{Err: err}}
+	}
+	if err := c.Validate(opts...); err != nil {
+		return Condition{}, err
+	}
+	return c, nil
+}
+
+// shape 检查解析为 any 的规则中是否有未知的 key 或者类型不对的字段
+func (v *validator) shape(raw any, path string) {
+	switch node := raw.(type) {
+	case []any:
+		// SQL 风格的表达式
+		if len(node) != 2 && len(node) != 3 {
+			v.add(path, fmt.Errorf(
+				"%w, SQL style expr should have length 2 or 3, but got %d", ErrIllegalCondition, len(node),
+			))
+			return
+		}
+		for i, name := range []string{"field", "op"} {
+			if _, ok := node[i].(string); !ok {
+				v.add(path, fmt.Errorf("%w, %s of SQL style expr should be a string", ErrIllegalCondition, name))
+			}
+		}
+
+	case map[string]any:
+		keys := make([]string, 0, len(node))
+		for k := range node {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			switch sub := node[k]; k {
+			case "field", "syntax", "op", "ref":
+				if _, ok := sub.(string); !ok && sub != nil {
+					v.add(joinRulePath(path, k), fmt.Errorf("%w, should be a string", ErrIllegalCondition))
+				}
+			case "value":
+				// 任意值
+			case "or", "and":
+				arr, ok := sub.([]any)
+				if !ok {
+					if sub != nil {
+						v.add(joinRulePath(path, k), fmt.Errorf("%w, should be an array", ErrIllegalCondition))
+					}
+					continue
+				}
+				for i, c := range arr {
+					v.shape(c, fmt.Sprintf("%s[%d]", joinRulePath(path, k), i))
+				}
+			case "not", "any", "all", "none":
+				if sub != nil {
+					v.shape(sub, joinRulePath(path, k))
+				}
+			default:
+				v.add(path, fmt.Errorf("%w '%s'", ErrUnknownKey, k))
+			}
+		}
+
+	default:
+		v.add(path, fmt.Errorf("%w, expecting an object or an array but got %s", ErrIllegalCondition, typeName(raw)))
+	}
+}
+
+// typeName 返回解析之后的值的 JSON 类型名称
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, int64, uint64:
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
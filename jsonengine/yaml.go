@@ -0,0 +1,223 @@
+package jsonengine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ----------------
+// MARK: Condition.UnmarshalYAML
+
+// UnmarshalYAML 支持与 UnmarshalJSON 相同的所有写法, 包括 SQL 风格的简写, 如:
+//
+//	and:
+//	  - [user.age, ">=", 18]
+//	  - [user.email, exists]
+//	  - field: items
+//	    any: [sku, "=", "A-1"]
+//
+// 为了与 JSON 形式得到完全相同的 Condition, 值中的整数解析为 int64 (或 uint64), 其他数字解析为 float64, 时间戳等
+// 保持原始的字符串
+func (c *Condition) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		return c.UnmarshalYAML(node.Alias)
+	}
+	if node.Kind == yaml.SequenceNode {
+		return c.unmarshalSQLStyleYAML(node)
+	}
+
+	w := &conditionWrapping{}
+	if err := node.Decode(w); err != nil {
+		return err
+	}
+	if v := yamlMappingValue(node, "value"); v != nil {
+		value, err := yamlValue(v)
+		if err != nil {
+			return err
+		}
+		w.Value = value
+	}
+	*c = *(*Condition)(w)
+	return nil
+}
+
+func (c *Condition) unmarshalSQLStyleYAML(node *yaml.Node) error {
+	arr := node.Content
+	if len(arr) != 2 && len(arr) != 3 {
+		return fmt.Errorf("line %d: SQL style expr should have length 2 or 3, but got %d", node.Line, len(arr))
+	}
+
+	var f, o string
+	if err := yamlString(arr[0], &f); err != nil {
+		return fmt.Errorf("get SQL style expr field error (%w)", err)
+	}
+	if err := yamlString(arr[1], &o); err != nil {
+		return fmt.Errorf("get SQL style expr operator error (%w)", err)
+	}
+
+	*c = Condition{}
+	c.Field = f
+	c.Operator = o
+	if len(arr) == 3 {
+		v, err := yamlValue(arr[2])
+		if err != nil {
+			return fmt.Errorf("get SQL style expr value error (%w)", err)
+		}
+		c.setSQLStyleValue(v)
+	}
+	return nil
+}
+
+// yamlString 读取字符串标量, 与 JSON 不同, YAML 中不带引号的 field 和操作符同样是字符串
+func yamlString(node *yaml.Node, s *string) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expecting a string", node.Line)
+	}
+	*s = node.Value
+	return nil
+}
+
+// yamlMappingValue 返回 mapping 中 key 对应的值节点, 不存在时返回 nil
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlValue 将 YAML 节点转换为与 JSON 形式解析结果相同类型的值, 即 nil、bool、int64、uint64、float64、
+// string、[]any 以及 map[string]any, 数字的类型参见 numberValue
+func yamlValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+
+	case yaml.SequenceNode:
+		arr := make([]any, 0, len(node.Content))
+		for _, n := range node.Content {
+			v, err := yamlValue(n)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+
+	case yaml.MappingNode:
+		m := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var k string
+			if err := yamlString(node.Content[i], &k); err != nil {
+				return nil, fmt.Errorf("%w, object key should be a scalar", err)
+			}
+			v, err := yamlValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := node.Decode(&b)
+		return b, err
+	case "!!int":
+		var i int64
+		if err := node.Decode(&i); err == nil {
+			return i, nil
+		}
+		var u uint64
+		err := node.Decode(&u)
+		return u, err
+	case "!!float":
+		var f float64
+		if err := node.Decode(&f); err != nil {
+			return nil, err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%w, line %d: %s is not a valid JSON number",
+				ErrIllegalTargetValue, node.Line, strconv.Quote(node.Value))
+		}
+		return f, nil
+	default:
+		// 字符串、时间戳以及二进制等均保留原始文本
+		return node.Value, nil
+	}
+}
+
+// ----------------
+// MARK: Condition.MarshalYAML
+
+// MarshalYAML 输出与 MarshalJSON 相同的结构: 叶子条件使用 flow 风格的 SQL 数组, 如 [a, "=", 1], 其他节点使用
+// block 风格, 字符串仅在必要时加上引号
+func (c Condition) MarshalYAML() (any, error) {
+	b, err := c.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	// JSON 也是合法的 YAML, 这样可以保留 key 的顺序
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	yamlConditionStyle(node)
+	return node, nil
+}
+
+func yamlConditionStyle(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		// SQL 风格的叶子条件
+		yamlPlainStyle(node)
+		return
+	}
+	node.Style = 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		k.Style = 0
+		switch k.Value {
+		case "or", "and":
+			v.Style = 0
+			for _, sub := range v.Content {
+				yamlConditionStyle(sub)
+			}
+		case "not", "any", "all", "none":
+			yamlConditionStyle(v)
+		default:
+			yamlPlainStyle(v)
+		}
+	}
+}
+
+// yamlPlainStyle 去掉 JSON 中字符串的双引号, 集合保持 flow 风格
+func yamlPlainStyle(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		node.Style = 0
+		return
+	}
+	for _, sub := range node.Content {
+		yamlPlainStyle(sub)
+	}
+}